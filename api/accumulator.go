@@ -0,0 +1,307 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// completedTicketTTL is how long a completed ticket's result stays available
+const completedTicketTTL = 10 * time.Minute
+
+// unsealedTicketTTL drops tickets whose request never called Seal, e.g. because
+// its handler panicked, so they do not stay pending forever
+const unsealedTicketTTL = 5 * time.Minute
+
+// errAccumulatorFull rejects events while maxPending events wait for a flush
+var errAccumulatorFull = errors.New("event buffer is full, retry later")
+
+// Accumulator buffers events from many POST /events requests and produces them
+// together once maxEvents are pending or interval has passed. Each request gets
+// a ticket whose result can be fetched once all of its events were flushed.
+type Accumulator struct {
+	producer  *KafkaProducer
+	breaker   *CircuitBreaker
+	maxEvents int
+	interval  time.Duration
+
+	// maxPending bounds the events waiting for a flush while Kafka is slow
+	maxPending int
+
+	// dedup is nil unless DEDUP_CACHE_SIZE is set
+	dedup *DedupCache
+
+	mu      sync.Mutex
+	pending []pendingEvent
+	tickets map[string]*ticket
+
+	flush chan struct{} // signals that maxEvents are pending
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+type pendingEvent struct {
+	event    Event
+	ticketID string
+	opts     SendOptions
+}
+
+type ticket struct {
+	response    EventResponse
+	remaining   int  // events added but not yet flushed
+	sealed      bool // no more events will be added
+	createdAt   time.Time
+	completedAt time.Time
+}
+
+// TicketStatus is the state of a ticket returned by Ticket
+type TicketStatus struct {
+	Status string         `json:"status"` // pending or completed
+	Result *EventResponse `json:"result,omitempty"`
+}
+
+// NewAccumulator creates an accumulator and starts its background flusher. With
+// a dedup cache, events whose ID was produced recently are dropped as duplicates.
+// At most maxPending events wait for a flush; Add rejects events beyond that.
+func NewAccumulator(producer *KafkaProducer, breaker *CircuitBreaker, maxEvents, maxPending int, interval time.Duration, dedup *DedupCache) *Accumulator {
+	a := &Accumulator{
+		producer:   producer,
+		breaker:    breaker,
+		maxEvents:  maxEvents,
+		maxPending: maxPending,
+		interval:   interval,
+		dedup:      dedup,
+		tickets:    make(map[string]*ticket),
+		flush:      make(chan struct{}, 1),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	go a.run()
+	return a
+}
+
+// NewTicket opens a ticket for a request. Its events are attached with Add
+// until the request is done and calls Seal.
+func (a *Accumulator) NewTicket() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	id := hex.EncodeToString(b)
+
+	a.mu.Lock()
+	a.tickets[id] = &ticket{response: newEventResponse(), createdAt: time.Now()}
+	a.mu.Unlock()
+	return id
+}
+
+// Add queues events for the next flush and records them on the ticket. It returns
+// errAccumulatorFull without queueing anything when the events would take the
+// buffer over maxPending; a single oversized request is still let through when
+// the buffer is empty.
+func (a *Accumulator) Add(ticketID string, events []Event, opts SendOptions) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	a.mu.Lock()
+	if len(a.pending) > 0 && len(a.pending)+len(events) > a.maxPending {
+		a.mu.Unlock()
+		return errAccumulatorFull
+	}
+	if t, ok := a.tickets[ticketID]; ok {
+		t.remaining += len(events)
+	}
+	for _, event := range events {
+		a.pending = append(a.pending, pendingEvent{event: event, ticketID: ticketID, opts: opts})
+	}
+	full := len(a.pending) >= a.maxEvents
+	a.mu.Unlock()
+
+	if full {
+		select {
+		case a.flush <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Seal marks a ticket as complete once its pending events are flushed, and merges
+// the request's own results (invalid and rate limited events) into it
+func (a *Accumulator) Seal(ticketID string, response EventResponse) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	t, ok := a.tickets[ticketID]
+	if !ok {
+		return
+	}
+	t.response.InvalidEventIds = append(t.response.InvalidEventIds, response.InvalidEventIds...)
+	t.response.RateLimitedEventIds = append(t.response.RateLimitedEventIds, response.RateLimitedEventIds...)
+	t.response.Failures = append(t.response.Failures, response.Failures...)
+	t.sealed = true
+	if t.remaining == 0 {
+		t.completedAt = time.Now()
+	}
+}
+
+// Ticket returns the status of a ticket, or false if it is unknown or expired
+func (a *Accumulator) Ticket(ticketID string) (TicketStatus, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	t, ok := a.tickets[ticketID]
+	if !ok {
+		return TicketStatus{}, false
+	}
+	if t.completedAt.IsZero() {
+		return TicketStatus{Status: "pending"}, true
+	}
+	response := t.response
+	return TicketStatus{Status: "completed", Result: &response}, true
+}
+
+// Close stops the background flusher after flushing the remaining events
+func (a *Accumulator) Close() {
+	close(a.stop)
+	<-a.done
+}
+
+// run flushes pending events whenever maxEvents are queued or interval passes
+func (a *Accumulator) run() {
+	defer close(a.done)
+
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+	expiry := time.NewTicker(time.Minute)
+	defer expiry.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			a.flushPending()
+		case <-expiry.C:
+			a.expireTickets()
+		case <-a.flush:
+			a.flushPending()
+		case <-a.stop:
+			a.flushPending()
+			return
+		}
+	}
+}
+
+// flushPending produces all queued events, grouped by ack level, and records
+// each outcome on the event's ticket
+func (a *Accumulator) flushPending() {
+	a.mu.Lock()
+	batch := a.pending
+	a.pending = nil
+	a.mu.Unlock()
+
+	if a.dedup != nil {
+		batch = a.dropDuplicates(batch)
+	}
+	if len(batch) == 0 {
+		return
+	}
+
+	// Events with different ack overrides or X-Sync are written by different writers,
+	// and events pinned with X-Kafka-Partition are written to their partition
+	groups := make(map[string][]pendingEvent)
+	var order []string
+	for _, p := range batch {
+		key := "default"
+		if p.opts.Acks != nil {
+			key = p.opts.Acks.String()
+		}
+		if p.opts.Sync {
+			key += "/sync"
+		}
+		if p.opts.Partition != nil {
+			key += "/" + strconv.Itoa(*p.opts.Partition)
+		}
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], p)
+	}
+
+	for _, key := range order {
+		group := groups[key]
+		events := make([]Event, len(group))
+		for i, p := range group {
+			events[i] = p.event
+		}
+		outcomes := produceEvents(a.producer, a.breaker, events, group[0].opts)
+
+		a.mu.Lock()
+		for i, p := range group {
+			a.finish(p, outcomeFor(p.event, outcomes[i]))
+
+			// Failed events stay unknown, so an upstream retry is not dropped
+			if a.dedup != nil && (outcomes[i] == nil || errors.Is(outcomes[i], errBuffered) || errors.Is(outcomes[i], errSpooled)) {
+				a.dedup.Add(p.event.ID)
+			}
+		}
+		a.mu.Unlock()
+	}
+}
+
+// dropDuplicates finishes the events of batch whose ID was produced recently or
+// appears earlier in the batch, and returns the others
+func (a *Accumulator) dropDuplicates(batch []pendingEvent) []pendingEvent {
+	unique := batch[:0]
+	seen := make(map[string]bool, len(batch))
+	dropped := 0
+
+	a.mu.Lock()
+	for _, p := range batch {
+		if seen[p.event.ID] || a.dedup.Contains(p.event.ID) {
+			a.finish(p, EventOutcome{ID: p.event.ID, Status: OutcomeDuplicate})
+			dropped++
+			continue
+		}
+		seen[p.event.ID] = true
+		unique = append(unique, p)
+	}
+	a.mu.Unlock()
+
+	if dropped > 0 && !a.producer.config.LiteMode {
+		dedupDroppedEventsTotal.Add(float64(dropped))
+	}
+	return unique
+}
+
+// finish records the outcome of a flushed event on its ticket (a.mu must be held)
+func (a *Accumulator) finish(p pendingEvent, outcome EventOutcome) {
+	t, ok := a.tickets[p.ticketID]
+	if !ok {
+		return
+	}
+	t.response.record(outcome)
+	t.remaining--
+	if t.sealed && t.remaining == 0 {
+		t.completedAt = time.Now()
+	}
+}
+
+// expireTickets forgets tickets completed more than completedTicketTTL ago and
+// tickets still unsealed after unsealedTicketTTL
+func (a *Accumulator) expireTickets() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	for id, t := range a.tickets {
+		if !t.completedAt.IsZero() && now.Sub(t.completedAt) > completedTicketTTL {
+			delete(a.tickets, id)
+		} else if !t.sealed && now.Sub(t.createdAt) > unsealedTicketTTL {
+			delete(a.tickets, id)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTestAccumulator(maxEvents, maxPending int) *Accumulator {
+	return NewAccumulator(newTestProducer(ProducerConfig{}), NewCircuitBreaker(5, time.Second), maxEvents, maxPending, time.Hour, nil)
+}
+
+func TestAccumulatorAddRejectsBeyondMaxPending(t *testing.T) {
+	a := newTestAccumulator(100, 10)
+	defer a.Close()
+
+	ticketID := a.NewTicket()
+	if err := a.Add(ticketID, testEvents(8), SendOptions{}); err != nil {
+		t.Fatalf("Add() = %v, want nil", err)
+	}
+	if err := a.Add(ticketID, testEvents(3), SendOptions{}); !errors.Is(err, errAccumulatorFull) {
+		t.Fatalf("Add() = %v, want %v", err, errAccumulatorFull)
+	}
+	if got := len(a.pending); got != 8 {
+		t.Errorf("pending = %d, want 8", got)
+	}
+	if got := a.tickets[ticketID].remaining; got != 8 {
+		t.Errorf("remaining = %d, want 8", got)
+	}
+}
+
+func TestAccumulatorAddAllowsOversizedRequestWhenEmpty(t *testing.T) {
+	a := newTestAccumulator(100, 10)
+	defer a.Close()
+
+	if err := a.Add(a.NewTicket(), testEvents(20), SendOptions{}); err != nil {
+		t.Fatalf("Add() = %v, want nil", err)
+	}
+}
+
+func TestAccumulatorExpiresUnsealedTickets(t *testing.T) {
+	a := newTestAccumulator(100, 1000)
+	defer a.Close()
+
+	stale := a.NewTicket()
+	fresh := a.NewTicket()
+	a.mu.Lock()
+	a.tickets[stale].createdAt = time.Now().Add(-unsealedTicketTTL - time.Second)
+	a.mu.Unlock()
+
+	a.expireTickets()
+
+	if _, ok := a.Ticket(stale); ok {
+		t.Error("unsealed ticket older than unsealedTicketTTL was not expired")
+	}
+	if _, ok := a.Ticket(fresh); !ok {
+		t.Error("fresh unsealed ticket was expired")
+	}
+}
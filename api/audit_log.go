@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// auditRecord is one NDJSON line of the audit log
+type auditRecord struct {
+	ID        string    `json:"id"`
+	Topic     string    `json:"topic"`
+	Partition int       `json:"partition"`
+	Offset    int64     `json:"offset"`
+	Timestamp time.Time `json:"timestamp"`
+
+	// Prev is the SHA-256 of the previous line, empty for the first line ever
+	// written, so a line that is changed, removed or reordered breaks the chain
+	Prev string `json:"prev"`
+}
+
+// AuditLog appends a record of every event Kafka acknowledged to an NDJSON file.
+// Once the file reaches maxBytes it is renamed with a timestamp suffix and a new
+// file is started; the hash chain continues across files.
+type AuditLog struct {
+	mu       sync.Mutex
+	path     string
+	maxBytes int64 // 0 never rotates
+	file     *os.File
+	size     int64
+	prev     string // hash of the last line written
+}
+
+// OpenAuditLog opens path for appending, continuing the hash chain of a file left
+// by a previous run
+func OpenAuditLog(path string, maxBytes int64) (*AuditLog, error) {
+	l := &AuditLog{path: path, maxBytes: maxBytes}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	last, err := lastLine(l.file, l.size)
+	if err != nil {
+		l.file.Close()
+		return nil, err
+	}
+	if last != nil {
+		l.prev = lineHash(last)
+	}
+	return l, nil
+}
+
+func (l *AuditLog) open() error {
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	l.file, l.size = file, info.Size()
+	return nil
+}
+
+// Record appends a line for each message acknowledged on topic that carries an
+// event ID. Failures are logged, they never fail the delivery itself.
+func (l *AuditLog) Record(topic string, messages []kafka.Message) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var data bytes.Buffer
+	for _, msg := range messages {
+		md, ok := msg.WriterData.(*messageData)
+		if !ok || md.eventID == "" {
+			continue
+		}
+		line, err := json.Marshal(auditRecord{
+			ID:        md.eventID,
+			Topic:     topic,
+			Partition: msg.Partition,
+			Offset:    msg.Offset,
+			Timestamp: msg.Time,
+			Prev:      l.prev,
+		})
+		if err != nil {
+			log.Printf("Failed to encode audit record for event %s: %v", md.eventID, err)
+			continue
+		}
+		l.prev = lineHash(line)
+		data.Write(line)
+		data.WriteByte('\n')
+	}
+	if data.Len() == 0 {
+		return
+	}
+
+	if l.maxBytes > 0 && l.size > 0 && l.size+int64(data.Len()) > l.maxBytes {
+		if err := l.rotate(); err != nil {
+			log.Printf("Failed to rotate audit log %s: %v", l.path, err)
+		}
+	}
+	n, err := l.file.Write(data.Bytes())
+	l.size += int64(n)
+	if err != nil {
+		log.Printf("Failed to write audit log %s: %v", l.path, err)
+	}
+}
+
+// rotate renames the current file with a timestamp suffix and starts a new one
+func (l *AuditLog) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	rotated := fmt.Sprintf("%s.%s", l.path, time.Now().UTC().Format("20060102T150405.000000000"))
+	if err := os.Rename(l.path, rotated); err != nil {
+		// Keep appending to the current file rather than losing records
+		if openErr := l.open(); openErr != nil {
+			return openErr
+		}
+		return err
+	}
+	return l.open()
+}
+
+// Close syncs and closes the file
+func (l *AuditLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.file.Sync(); err != nil {
+		l.file.Close()
+		return err
+	}
+	return l.file.Close()
+}
+
+// lineHash is the hex SHA-256 of a line without its newline
+func lineHash(line []byte) string {
+	sum := sha256.Sum256(line)
+	return hex.EncodeToString(sum[:])
+}
+
+// lastLine returns the last complete line of a file of the given size, nil if it has none
+func lastLine(file *os.File, size int64) ([]byte, error) {
+	const chunk = 64 << 10
+	start := max(size-chunk, 0)
+	data := make([]byte, size-start)
+	if _, err := file.ReadAt(data, start); err != nil && err != io.EOF {
+		return nil, err
+	}
+	data = bytes.TrimRight(data, "\n")
+	if len(data) == 0 {
+		return nil, nil
+	}
+	if i := bytes.LastIndexByte(data, '\n'); i >= 0 {
+		return data[i+1:], nil
+	}
+	if start > 0 {
+		return nil, fmt.Errorf("last line of %s is longer than %d bytes", file.Name(), chunk)
+	}
+	return data, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// addressRewriter dials a replacement for broker addresses found in its map and
+// every other address unchanged. Brokers in containers often advertise listeners
+// like kafka-0:9092 that only resolve inside their own network; the map points
+// them at an address this service can reach.
+type addressRewriter struct {
+	dial    func(ctx context.Context, network, address string) (net.Conn, error)
+	address map[string]string
+}
+
+func newAddressRewriter(dial func(ctx context.Context, network, address string) (net.Conn, error), address map[string]string) *addressRewriter {
+	return &addressRewriter{dial: dial, address: address}
+}
+
+// DialContext dials the mapped address of address, if it has one
+func (r *addressRewriter) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if mapped, ok := r.address[address]; ok {
+		address = mapped
+	}
+	return r.dial(ctx, network, address)
+}
+
+// brokerDial wraps dial with the BROKER_ADDRESS_MAP rewrite and the
+// KAFKA_MAX_CONNS_PER_BROKER limit, each only when set. The limit counts
+// connections by advertised address, so brokers mapped to one address (e.g.
+// through a proxy) are still limited one by one.
+func brokerDial(dial func(ctx context.Context, network, address string) (net.Conn, error), addressMap map[string]string, maxConnsPerBroker int) func(ctx context.Context, network, address string) (net.Conn, error) {
+	if len(addressMap) > 0 {
+		dial = newAddressRewriter(dial, addressMap).DialContext
+	}
+	if maxConnsPerBroker > 0 {
+		dial = newConnLimiter(dial, maxConnsPerBroker).DialContext
+	}
+	return dial
+}
+
+// ParseBrokerAddressMap parses rewrites like "kafka-0:9092=10.0.0.1:9092,kafka-1:9092=10.0.0.2:9092"
+func ParseBrokerAddressMap(s string) (map[string]string, error) {
+	addresses := make(map[string]string)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		from, to, ok := strings.Cut(entry, "=")
+		from, to = strings.TrimSpace(from), strings.TrimSpace(to)
+		if !ok || from == "" || to == "" {
+			return nil, fmt.Errorf("invalid entry %q, expected advertised=address", entry)
+		}
+		for _, address := range []string{from, to} {
+			if _, _, err := net.SplitHostPort(address); err != nil {
+				return nil, fmt.Errorf("invalid address %q in entry %q, expected host:port", address, entry)
+			}
+		}
+		addresses[from] = to
+	}
+	return addresses, nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+)
+
+// recordingDial is a dial func that records the addresses it was asked for and
+// returns one end of a pipe
+type recordingDial struct {
+	addresses []string
+}
+
+func (d *recordingDial) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	d.addresses = append(d.addresses, address)
+	conn, peer := net.Pipe()
+	peer.Close()
+	return conn, nil
+}
+
+func TestBrokerDialRewritesAndLimits(t *testing.T) {
+	addressMap, err := ParseBrokerAddressMap("kafka-0:9092=10.0.0.1:9092,kafka-1:9092=10.0.0.2:9092")
+	if err != nil {
+		t.Fatalf("ParseBrokerAddressMap() = %v", err)
+	}
+	recorder := &recordingDial{}
+	dial := brokerDial(recorder.DialContext, addressMap, 1)
+	ctx := context.Background()
+
+	conn, err := dial(ctx, "tcp", "kafka-0:9092")
+	if err != nil {
+		t.Fatalf("dial(kafka-0) = %v", err)
+	}
+	if _, err := dial(ctx, "tcp", "kafka-0:9092"); !errors.Is(err, errConnLimit) {
+		t.Fatalf("second dial(kafka-0) = %v, want %v", err, errConnLimit)
+	}
+	if _, err := dial(ctx, "tcp", "kafka-1:9092"); err != nil {
+		t.Fatalf("dial(kafka-1) = %v", err)
+	}
+	if _, err := dial(ctx, "tcp", "kafka-2:9092"); err != nil {
+		t.Fatalf("dial(kafka-2) = %v", err)
+	}
+
+	// Closing a connection frees its slot
+	conn.Close()
+	if _, err := dial(ctx, "tcp", "kafka-0:9092"); err != nil {
+		t.Fatalf("dial(kafka-0) after close = %v", err)
+	}
+
+	want := []string{"10.0.0.1:9092", "10.0.0.2:9092", "kafka-2:9092", "10.0.0.1:9092"}
+	if !reflect.DeepEqual(recorder.addresses, want) {
+		t.Errorf("dialed %v, want %v", recorder.addresses, want)
+	}
+}
+
+func TestBrokerDialWithoutSettings(t *testing.T) {
+	recorder := &recordingDial{}
+	dial := brokerDial(recorder.DialContext, nil, 0)
+
+	for i := 0; i < 3; i++ {
+		if _, err := dial(context.Background(), "tcp", "kafka-0:9092"); err != nil {
+			t.Fatalf("dial() = %v", err)
+		}
+	}
+	if want := []string{"kafka-0:9092", "kafka-0:9092", "kafka-0:9092"}; !reflect.DeepEqual(recorder.addresses, want) {
+		t.Errorf("dialed %v, want %v", recorder.addresses, want)
+	}
+}
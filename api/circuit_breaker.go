@@ -0,0 +1,126 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Circuit breaker states
+const (
+	BreakerClosed   = "closed"    // writes flow normally
+	BreakerOpen     = "open"      // writes fail fast until the cooldown has passed
+	BreakerHalfOpen = "half-open" // a single trial write decides whether to close again
+)
+
+// CircuitBreaker stops sending to Kafka after threshold consecutive failed
+// SendEvents calls, so requests fail fast instead of waiting for write timeouts
+// while the cluster is down. After the cooldown one trial call is let through:
+// success closes the breaker, failure opens it for another cooldown.
+type CircuitBreaker struct {
+	mu        sync.Mutex
+	threshold int // 0 disables the breaker
+	cooldown  time.Duration
+
+	state         string
+	failures      int
+	openedAt      time.Time
+	trialInFlight bool
+}
+
+// NewCircuitBreaker creates a closed breaker
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		state:     BreakerClosed,
+	}
+}
+
+// Ready reports whether a call would currently be allowed, without claiming a trial
+func (cb *CircuitBreaker) Ready() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case BreakerOpen:
+		return time.Since(cb.openedAt) >= cb.cooldown
+	case BreakerHalfOpen:
+		return !cb.trialInFlight
+	default:
+		return true
+	}
+}
+
+// Allow reports whether a call may proceed. Every allowed call must be followed by Record.
+func (cb *CircuitBreaker) Allow() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case BreakerOpen:
+		if time.Since(cb.openedAt) < cb.cooldown {
+			return false
+		}
+		cb.state = BreakerHalfOpen
+		cb.trialInFlight = true
+		return true
+	case BreakerHalfOpen:
+		if cb.trialInFlight {
+			return false
+		}
+		cb.trialInFlight = true
+		return true
+	default:
+		return true
+	}
+}
+
+// Record reports the outcome of an allowed call
+func (cb *CircuitBreaker) Record(success bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.threshold <= 0 {
+		return
+	}
+
+	if success {
+		cb.state = BreakerClosed
+		cb.failures = 0
+		cb.trialInFlight = false
+		return
+	}
+
+	cb.failures++
+	if cb.state == BreakerHalfOpen || cb.failures >= cb.threshold {
+		cb.state = BreakerOpen
+		cb.openedAt = time.Now()
+		cb.trialInFlight = false
+	}
+}
+
+// State returns the current state name. An open breaker whose cooldown has
+// passed is reported as half-open since the next call will be a trial.
+func (cb *CircuitBreaker) State() string {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == BreakerOpen && time.Since(cb.openedAt) >= cb.cooldown {
+		return BreakerHalfOpen
+	}
+	return cb.state
+}
+
+// RetryAfter returns how long until the open breaker lets a trial call through
+func (cb *CircuitBreaker) RetryAfter() time.Duration {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state != BreakerOpen {
+		return 0
+	}
+	if remaining := cb.cooldown - time.Since(cb.openedAt); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
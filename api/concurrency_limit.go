@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Policies for requests over MAX_CONCURRENT_REQUESTS
+const (
+	OverloadPolicyQueue  = "queue"  // wait up to OVERLOAD_QUEUE_TIMEOUT for a slot
+	OverloadPolicyReject = "reject" // answer 503 right away
+)
+
+// concurrencyLimiter bounds the /events requests served at once, so a traffic
+// spike cannot start an unbounded number of produce calls. The slots channel is
+// the semaphore: a request holds one of its buffer slots while it is served.
+type concurrencyLimiter struct {
+	slots   chan struct{}
+	policy  string
+	timeout time.Duration // how long a queued request waits
+	lite    bool          // skip metrics
+}
+
+func newConcurrencyLimiter(max int, policy string, timeout time.Duration, lite bool) *concurrencyLimiter {
+	return &concurrencyLimiter{
+		slots:   make(chan struct{}, max),
+		policy:  policy,
+		timeout: timeout,
+		lite:    lite,
+	}
+}
+
+// Middleware serves the request once it got a slot and answers 503 with
+// Retry-After when it gets none
+func (l *concurrencyLimiter) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !l.acquire(c) {
+			if !l.lite {
+				overloadRejectedRequestsTotal.Inc()
+			}
+			c.Header("Retry-After", "1")
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+				"error": fmt.Sprintf("Too many concurrent requests, at most %d are served at once", cap(l.slots)),
+			})
+			return
+		}
+		defer l.release()
+		c.Next()
+	}
+}
+
+func (l *concurrencyLimiter) acquire(c *gin.Context) bool {
+	select {
+	case l.slots <- struct{}{}:
+		l.record(1)
+		return true
+	default:
+	}
+	if l.policy == OverloadPolicyReject {
+		return false
+	}
+
+	timer := time.NewTimer(l.timeout)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		l.record(1)
+		return true
+	case <-timer.C:
+		return false
+	case <-c.Request.Context().Done():
+		return false
+	}
+}
+
+func (l *concurrencyLimiter) release() {
+	<-l.slots
+	l.record(-1)
+}
+
+// record exports the change in requests holding a slot
+func (l *concurrencyLimiter) record(delta float64) {
+	if !l.lite {
+		concurrentRequests.Add(delta)
+	}
+}
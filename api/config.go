@@ -0,0 +1,112 @@
+package main
+
+import "time"
+
+// Batching settings shared by all writers
+const (
+	writerBatchSize    = 100                   // Number of messages per batch
+	writerBatchBytes   = 1048576               // 1MB batch size
+	writerBatchTimeout = 10 * time.Millisecond // 10ms batch timeout
+)
+
+// probeTimeout bounds the broker check of the startup and readiness probes
+const probeTimeout = 2 * time.Second
+
+// RuntimeConfig is the effective configuration reported by GET /protected/config.
+// New settings get a field here and are filled in where main resolves them.
+// Secrets (passwords, keys) must never be stored as-is; report them redacted.
+type RuntimeConfig struct {
+	Port              string            `json:"port"`
+	ConfigFile        string            `json:"configFile,omitempty"`
+	LogLevel          string            `json:"logLevel"`
+	Brokers           []string          `json:"brokers"`
+	PartitionBalancer string            `json:"partitionBalancer"`
+	DurabilityProfile string            `json:"durabilityProfile"`
+	RequiredAcks      string            `json:"requiredAcks"`
+	WriteAttempts     int               `json:"writeAttempts"`
+	WriteTimeout      string            `json:"writeTimeout"`
+	SyncWrites        bool              `json:"syncWrites"`
+	Compression       string            `json:"compression"`
+	ZstdDictFile      string            `json:"zstdDictFile,omitempty"`
+	DialTimeout       string            `json:"dialTimeout"`
+	IdleTimeout       string            `json:"idleTimeout"`
+	MaxConnsPerBroker int               `json:"maxConnsPerBroker"`
+	BrokerAddressMap  map[string]string `json:"brokerAddressMap,omitempty"`
+
+	BatchSize            int    `json:"batchSize"`
+	BatchBytes           int64  `json:"batchBytes"`
+	BatchTimeout         string `json:"batchTimeout"`
+	FlushOnBatchComplete bool   `json:"flushOnBatchComplete"`
+
+	TopicTemplate          string   `json:"topicTemplate"`
+	FixedTopic             string   `json:"fixedTopic,omitempty"`
+	TopicPartitions        int      `json:"topicPartitions"`
+	ReplicationFactor      int      `json:"replicationFactor"`
+	AllowAutoTopicCreation bool     `json:"allowAutoTopicCreation"`
+	AllowedTopics          []string `json:"allowedTopics,omitempty"`
+	DefaultTopic           string   `json:"defaultTopic,omitempty"`
+	WarmupTopics           []string `json:"warmupTopics,omitempty"`
+	DeadLetterTopic        string   `json:"deadLetterTopic,omitempty"`
+
+	SpoolMaxEvents          int    `json:"spoolMaxEvents,omitempty"`
+	DiskBufferDir           string `json:"diskBufferDir,omitempty"`
+	DiskBufferSegmentBytes  int64  `json:"diskBufferSegmentBytes,omitempty"`
+	DiskBufferMaxBytes      int64  `json:"diskBufferMaxBytes,omitempty"`
+	DiskBufferRetryInterval string `json:"diskBufferRetryInterval,omitempty"`
+
+	ValueMode          string   `json:"valueMode"`
+	KeyTemplate        string   `json:"keyTemplate,omitempty"`
+	PartitionKey       string   `json:"partitionKey"`
+	PreserveOrder      bool     `json:"preserveOrder"`
+	StripFields        []string `json:"stripFields,omitempty"`
+	MessageTimeSource  string   `json:"messageTimeSource"`
+	ProduceConcurrency int      `json:"produceConcurrency"`
+	MaxRequestBytes    int64    `json:"maxRequestBytes"`
+	GzipMinBytes       int      `json:"responseCompressionMinBytes"`
+	StrictJSON         bool     `json:"strictJSON"`
+	VerboseErrors      bool     `json:"verboseErrors"`
+	AllowEmptyBatch    bool     `json:"allowEmptyBatch"`
+	PartialStatusCode  bool     `json:"partialStatusCode"`
+	EventIDField       string   `json:"eventIdField"`
+	PayloadMustBeJSON  bool     `json:"payloadMustBeJSON"`
+	PositiveIntFields  []string `json:"positiveIntFields,omitempty"`
+	ForwardHeaders     []string `json:"forwardHeaders,omitempty"`
+	EventTimeFormat    string   `json:"eventTimeFormat,omitempty"`
+	EnableEnrichment   bool     `json:"enableEnrichment"`
+	EventEnrichers     []string `json:"eventEnrichers,omitempty"`
+	SourceInstance     string   `json:"sourceInstance,omitempty"`
+	DomainRateLimits   string   `json:"domainRateLimits,omitempty"`
+
+	EventsPerSecondLimit   float64 `json:"eventsPerSecondLimit,omitempty"`
+	EventsPerSecondMaxWait string  `json:"eventsPerSecondMaxWait,omitempty"`
+
+	IdempotencyEnabled   bool   `json:"idempotencyEnabled"`
+	IdempotencyCacheSize int    `json:"idempotencyCacheSize"`
+	IdempotencyTTL       string `json:"idempotencyTTL"`
+
+	BreakerFailureThreshold int    `json:"breakerFailureThreshold"`
+	BreakerCooldown         string `json:"breakerCooldown"`
+
+	TimeoutBackpressureThreshold int    `json:"timeoutBackpressureThreshold,omitempty"`
+	TimeoutBackpressureWindow    string `json:"timeoutBackpressureWindow,omitempty"`
+	TimeoutBackpressureCooldown  string `json:"timeoutBackpressureCooldown,omitempty"`
+
+	MaxConcurrentRequests int    `json:"maxConcurrentRequests,omitempty"`
+	OverloadPolicy        string `json:"overloadPolicy,omitempty"`
+	OverloadQueueTimeout  string `json:"overloadQueueTimeout,omitempty"`
+
+	AccumulateEvents     bool   `json:"accumulateEvents"`
+	FlushMaxEvents       int    `json:"flushMaxEvents"`
+	AccumulateMaxPending int    `json:"accumulateMaxPending,omitempty"`
+	FlushInterval        string `json:"flushInterval"`
+	DedupCacheSize       int    `json:"dedupCacheSize,omitempty"`
+
+	ShutdownTimeout      string `json:"shutdownTimeout"`
+	DrainTimeout         string `json:"drainTimeout"`
+	FailedEventsDumpFile string `json:"failedEventsDumpFile,omitempty"`
+	AuditLogFile         string `json:"auditLogFile,omitempty"`
+	AuditLogMaxMB        int    `json:"auditLogMaxMB,omitempty"`
+
+	LiteMode            bool   `json:"liteMode"`
+	WriterStatsInterval string `json:"writerStatsInterval"`
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Log levels selected with LOG_LEVEL
+const (
+	LogLevelInfo  = "info"
+	LogLevelDebug = "debug"
+)
+
+// Settings resolves the service's settings by their environment variable names.
+// A variable that is set and not empty wins; otherwise the value comes from the
+// CONFIG_FILE, if one was loaded, and main applies its default when neither has
+// it. Every value goes through the same parsing and validation in main, so a
+// file value is checked exactly like the variable it stands for.
+type Settings struct {
+	path  string
+	file  map[string]string // by upper-case variable name
+	used  map[string]bool   // names looked up so far
+	debug bool
+}
+
+// LoadSettings reads the config file at path, YAML for .yaml and .yml and JSON
+// for .json; an empty path leaves only the environment. Keys are the variable
+// names, in any case. Lists are joined with commas, like KAFKA_BROKERS, and
+// mappings are encoded as JSON, like DOMAIN_RATE_LIMITS.
+func LoadSettings(path string) (*Settings, error) {
+	s := &Settings{path: path, file: make(map[string]string), used: make(map[string]bool)}
+	if path != "" {
+		if err := s.load(path); err != nil {
+			return nil, err
+		}
+	}
+
+	switch level := strings.ToLower(s.Get("LOG_LEVEL")); level {
+	case "", LogLevelInfo:
+	case LogLevelDebug:
+		s.debug = true
+		s.logSource("LOG_LEVEL")
+	default:
+		return nil, fmt.Errorf("invalid LOG_LEVEL %q, expected %s or %s", level, LogLevelInfo, LogLevelDebug)
+	}
+	return s, nil
+}
+
+func (s *Settings) load(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var values map[string]any
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &values)
+	case ".json":
+		err = json.Unmarshal(data, &values)
+	default:
+		return fmt.Errorf("unsupported config file extension %q, expected .yaml, .yml or .json", ext)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	for key, value := range values {
+		name := strings.ToUpper(strings.TrimSpace(key))
+		if _, ok := s.file[name]; ok {
+			return fmt.Errorf("setting %s is given more than once in %s", name, path)
+		}
+		if name == "CONFIG_FILE" {
+			return fmt.Errorf("CONFIG_FILE cannot be set in %s", path)
+		}
+		v, err := settingValue(value)
+		if err != nil {
+			return fmt.Errorf("invalid value for %s in %s: %w", name, path, err)
+		}
+		s.file[name] = v
+	}
+	return nil
+}
+
+// settingValue turns a decoded config file value into the string its variable would hold
+func settingValue(value any) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case []any:
+		items := make([]string, len(v))
+		for i, item := range v {
+			if _, ok := item.([]any); ok {
+				return "", fmt.Errorf("nested lists are not supported")
+			}
+			s, err := settingValue(item)
+			if err != nil {
+				return "", err
+			}
+			items[i] = s
+		}
+		return strings.Join(items, ","), nil
+	case map[string]any:
+		data, err := json.Marshal(v)
+		return string(data), err
+	default:
+		return "", fmt.Errorf("unsupported value %v", v)
+	}
+}
+
+// Get returns the value of the named setting, empty when it is not set
+func (s *Settings) Get(name string) string {
+	if !s.used[name] {
+		s.used[name] = true
+		s.logSource(name)
+	}
+
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return s.file[name]
+}
+
+// logSource logs at debug level where the named setting comes from, never its value
+func (s *Settings) logSource(name string) {
+	if !s.debug {
+		return
+	}
+	source := "default"
+	if os.Getenv(name) != "" {
+		source = "environment"
+	} else if _, ok := s.file[name]; ok {
+		source = "config file " + s.path
+	}
+	log.Printf("[debug] Setting %s: %s", name, source)
+}
+
+// LogLevel returns the LOG_LEVEL in effect
+func (s *Settings) LogLevel() string {
+	if s.debug {
+		return LogLevelDebug
+	}
+	return LogLevelInfo
+}
+
+// Unused returns the config file settings that were never looked up, sorted.
+// Called once every setting was read, they are names the service does not know.
+func (s *Settings) Unused() []string {
+	var unused []string
+	for name := range s.file {
+		if !s.used[name] {
+			unused = append(unused, name)
+		}
+	}
+	sort.Strings(unused)
+	return unused
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// errConnLimit is returned by connLimiter.DialContext when a broker already has
+// the maximum number of open connections
+var errConnLimit = errors.New("connection limit reached")
+
+// connLimiter caps the open connections per broker address of the shared
+// kafka.Transport, which otherwise dials a new connection whenever all of its
+// connections to a broker are busy. A dial over the limit fails instead of
+// waiting, and the writer's retry picks up a connection that became idle.
+type connLimiter struct {
+	dial func(ctx context.Context, network, address string) (net.Conn, error)
+	max  int
+
+	mu   sync.Mutex
+	open map[string]int
+}
+
+func newConnLimiter(dial func(ctx context.Context, network, address string) (net.Conn, error), max int) *connLimiter {
+	return &connLimiter{dial: dial, max: max, open: make(map[string]int)}
+}
+
+// DialContext opens a connection unless address is at the limit
+func (l *connLimiter) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	l.mu.Lock()
+	if l.open[address] >= l.max {
+		l.mu.Unlock()
+		return nil, fmt.Errorf("%w: %d connections to %s", errConnLimit, l.max, address)
+	}
+	l.open[address]++
+	l.mu.Unlock()
+
+	conn, err := l.dial(ctx, network, address)
+	if err != nil {
+		l.release(address)
+		return nil, err
+	}
+	return &limitedConn{Conn: conn, release: func() { l.release(address) }}, nil
+}
+
+func (l *connLimiter) release(address string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.open[address]--; l.open[address] <= 0 {
+		delete(l.open, address)
+	}
+}
+
+// limitedConn gives its slot back to the connLimiter when closed
+type limitedConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *limitedConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+	return err
+}
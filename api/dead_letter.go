@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Headers added to messages written to the dead-letter topic
+const (
+	deadLetterTopicHeader = "dead-letter-topic" // the topic the message failed to reach
+	deadLetterErrorHeader = "dead-letter-error" // why it failed
+)
+
+// replayGroupID is the consumer group that tracks how far the dead-letter topic was replayed
+const replayGroupID = "go-kafka-producer-replay"
+
+// replayIdleTimeout ends a replay when no dead-lettered message arrives for this long
+const replayIdleTimeout = 10 * time.Second
+
+// ReplayResult reports the outcome of POST /protected/replay
+type ReplayResult struct {
+	Replayed int    `json:"replayed"`
+	Skipped  int    `json:"skipped"` // messages without a dead-letter-topic header
+	Failed   int    `json:"failed"`
+	Error    string `json:"error,omitempty"`
+}
+
+// deadLetter writes messages that could not be produced to topicName to the
+// dead-letter topic, so they can be replayed once the problem is fixed
+func (kp *KafkaProducer) deadLetter(topicName string, messages []kafka.Message, cause error) {
+	if kp.deadLetterWriter == nil || topicName == kp.config.DeadLetterTopic || len(messages) == 0 {
+		return
+	}
+
+	dead := make([]kafka.Message, len(messages))
+	for i, msg := range messages {
+		headers := make([]kafka.Header, 0, len(msg.Headers)+2)
+		headers = append(headers, msg.Headers...)
+		headers = append(headers,
+			kafka.Header{Key: deadLetterTopicHeader, Value: []byte(topicName)},
+			kafka.Header{Key: deadLetterErrorHeader, Value: []byte(cause.Error())},
+		)
+		dead[i] = kafka.Message{Key: msg.Key, Value: msg.Value, Headers: headers, Time: msg.Time}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := kp.deadLetterWriter.write(ctx, dead...); err != nil {
+		log.Printf("Failed to dead-letter %d messages for topic %s: %v", len(dead), topicName, err)
+	}
+}
+
+// Replay consumes up to max messages from the dead-letter topic and produces each
+// one to the topic in its dead-letter-topic header. Offsets are committed only
+// after a message was produced, and replay stops at the first failure so the
+// failed message stays in the dead-letter topic for the next attempt.
+func (kp *KafkaProducer) Replay(ctx context.Context, max int) ReplayResult {
+	var result ReplayResult
+
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:     kp.config.Brokers,
+		Topic:       kp.config.DeadLetterTopic,
+		GroupID:     replayGroupID,
+		StartOffset: kafka.FirstOffset,
+		MaxWait:     time.Second,
+	})
+	defer reader.Close()
+
+	// Replayed messages are written synchronously so the offset is only committed once they are stored
+	writer := &kafka.Writer{
+		Addr:                   kafka.TCP(kp.config.Brokers...),
+		Balancer:               kp.config.NewBalancer(),
+		RequiredAcks:           kafka.RequireAll,
+		Compression:            kp.config.Compression,
+		WriteTimeout:           10 * time.Second,
+		AllowAutoTopicCreation: kp.config.AllowAutoTopicCreation,
+		Transport:              kp.config.Transport,
+	}
+	defer writer.Close()
+
+	for result.Replayed+result.Skipped < max {
+		fetchCtx, cancel := context.WithTimeout(ctx, replayIdleTimeout)
+		msg, err := reader.FetchMessage(fetchCtx)
+		cancel()
+		if err != nil {
+			// An idle timeout means the dead-letter topic is drained
+			if !errors.Is(err, context.DeadlineExceeded) || ctx.Err() != nil {
+				result.Error = err.Error()
+			}
+			break
+		}
+
+		topicName, headers := splitDeadLetterHeaders(msg.Headers)
+		if topicName != "" {
+			err := writer.WriteMessages(ctx, kafka.Message{
+				Topic:   topicName,
+				Key:     msg.Key,
+				Value:   msg.Value,
+				Headers: headers,
+				Time:    msg.Time,
+			})
+			if err != nil {
+				log.Printf("Failed to replay message from offset %d to topic %s: %v", msg.Offset, topicName, err)
+				result.Failed++
+				result.Error = err.Error()
+				break
+			}
+			kp.recordProduced(topicName, 1)
+			result.Replayed++
+		} else {
+			log.Printf("Skipping dead-lettered message at offset %d without a %s header", msg.Offset, deadLetterTopicHeader)
+			result.Skipped++
+		}
+
+		if err := reader.CommitMessages(ctx, msg); err != nil {
+			result.Error = err.Error()
+			break
+		}
+	}
+
+	return result
+}
+
+// splitDeadLetterHeaders returns the original topic of a dead-lettered message
+// and its headers without the ones added by deadLetter
+func splitDeadLetterHeaders(headers []kafka.Header) (string, []kafka.Header) {
+	var topicName string
+	kept := make([]kafka.Header, 0, len(headers))
+	for _, header := range headers {
+		switch header.Key {
+		case deadLetterTopicHeader:
+			topicName = string(header.Value)
+		case deadLetterErrorHeader:
+		default:
+			kept = append(kept, header)
+		}
+	}
+	return topicName, kept
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+)
+
+// DedupCache remembers the IDs of the last maxSize events produced by the
+// accumulator, so an event sent again by a retrying upstream in a later request
+// is dropped instead of produced twice. The least recently seen ID is evicted first.
+type DedupCache struct {
+	mu      sync.Mutex
+	maxSize int
+	entries map[string]*list.Element
+	order   *list.List // front is the most recently seen ID
+}
+
+// NewDedupCache creates a cache holding up to maxSize event IDs
+func NewDedupCache(maxSize int) *DedupCache {
+	return &DedupCache{
+		maxSize: maxSize,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// Contains reports whether id was produced recently, refreshing it if so
+func (c *DedupCache) Contains(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[id]
+	if ok {
+		c.order.MoveToFront(elem)
+	}
+	return ok
+}
+
+// Add records a produced event ID, evicting the oldest IDs beyond maxSize
+func (c *DedupCache) Add(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[id]; ok {
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.entries[id] = c.order.PushFront(id)
+	for c.order.Len() > c.maxSize {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(string))
+	}
+}
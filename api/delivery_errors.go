@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// recentDeliveryErrors is how many delivery failures are kept for /protected/errors
+const recentDeliveryErrors = 100
+
+// DeliveryError describes a message that failed after it was handed to an async writer
+type DeliveryError struct {
+	Time  time.Time `json:"time"`
+	Topic string    `json:"topic"`
+	Key   string    `json:"key"`
+	Error string    `json:"error"`
+
+	value []byte // the undelivered message value, only written by DumpDeliveryErrors
+}
+
+// dumpedDeliveryError is one NDJSON line written by DumpDeliveryErrors
+type dumpedDeliveryError struct {
+	DeliveryError
+	Value string `json:"value"`
+}
+
+// errorRing keeps the most recent delivery errors in a fixed-size ring buffer
+type errorRing struct {
+	mu      sync.Mutex
+	entries []DeliveryError
+	next    int
+	full    bool
+}
+
+func newErrorRing(size int) *errorRing {
+	return &errorRing{entries: make([]DeliveryError, size)}
+}
+
+// Add records an error, overwriting the oldest entry once the buffer is full
+func (r *errorRing) Add(e DeliveryError) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.entries[r.next] = e
+	r.next = (r.next + 1) % len(r.entries)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// List returns the buffered errors, newest first
+func (r *errorRing) List() []DeliveryError {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	count := r.next
+	if r.full {
+		count = len(r.entries)
+	}
+
+	result := make([]DeliveryError, 0, count)
+	for i := 1; i <= count; i++ {
+		result = append(result, r.entries[(r.next-i+len(r.entries))%len(r.entries)])
+	}
+	return result
+}
+
+// DumpDeliveryErrors writes the buffered delivery errors, oldest first, as NDJSON to
+// path together with the undelivered message values, so they survive a restart.
+// Lines are appended, so dumps of earlier runs are kept.
+func (kp *KafkaProducer) DumpDeliveryErrors(path string) (int, error) {
+	errs := kp.deliveryErrors.List()
+	if len(errs) == 0 {
+		return 0, nil
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return 0, err
+	}
+	w := bufio.NewWriter(f)
+	encoder := json.NewEncoder(w)
+	for i := len(errs) - 1; i >= 0; i-- {
+		if err := encoder.Encode(dumpedDeliveryError{DeliveryError: errs[i], Value: string(errs[i].value)}); err != nil {
+			f.Close()
+			return 0, err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return 0, err
+	}
+	return len(errs), f.Close()
+}
@@ -0,0 +1,439 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Buffer segments are named segment-<unix nanoseconds>.ndjson so they sort oldest first
+const (
+	diskBufferPrefix = "segment-"
+	diskBufferSuffix = ".ndjson"
+)
+
+var (
+	// errBuffered is the outcome of events that could not reach Kafka and were
+	// stored in the disk buffer to be produced later
+	errBuffered = errors.New("buffered on disk")
+
+	errDiskBufferFull   = errors.New("disk buffer is full")
+	errDiskBufferClosed = errors.New("disk buffer is closed")
+)
+
+// bufferedEvent is one NDJSON line of a buffer segment
+type bufferedEvent struct {
+	Event
+	BatchID string         `json:"batchid,omitempty"`
+	Headers []kafka.Header `json:"headers,omitempty"` // forwarded request headers
+}
+
+// DiskBuffer is a write-ahead log for events that could not be produced while
+// Kafka was unreachable. Events are appended to NDJSON segments in dir, a new
+// segment is started once the current one reaches segmentBytes, and a background
+// goroutine produces the segments oldest first whenever the circuit breaker lets
+// writes through. Segments left over from a previous run are drained as well.
+type DiskBuffer struct {
+	dir           string
+	segmentBytes  int64
+	maxBytes      int64 // total size of all segments, beyond which Append fails
+	retryInterval time.Duration
+
+	producer *KafkaProducer
+	breaker  *CircuitBreaker
+
+	mu          sync.Mutex
+	active      *os.File // segment being appended to, nil until the next Append
+	activeName  string
+	activeBytes int64
+	totalBytes  int64
+	closed      bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// OpenDiskBuffer creates dir if needed, picks up the segments already in it and
+// starts draining them every retryInterval
+func OpenDiskBuffer(dir string, segmentBytes, maxBytes int64, retryInterval time.Duration, producer *KafkaProducer, breaker *CircuitBreaker) (*DiskBuffer, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	b := &DiskBuffer{
+		dir:           dir,
+		segmentBytes:  segmentBytes,
+		maxBytes:      maxBytes,
+		retryInterval: retryInterval,
+		producer:      producer,
+		breaker:       breaker,
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+
+	segments, err := b.segments()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range segments {
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		b.totalBytes += info.Size()
+	}
+	if len(segments) > 0 {
+		log.Printf("Disk buffer: found %d segments (%d bytes) from a previous run", len(segments), b.totalBytes)
+	}
+	b.recordBytes()
+
+	go b.run()
+	return b, nil
+}
+
+// Append stores events at the end of the current segment and syncs it to disk
+func (b *DiskBuffer) Append(events []Event) error {
+	var data bytes.Buffer
+	encoder := json.NewEncoder(&data)
+	for _, event := range events {
+		if err := encoder.Encode(bufferedEvent{Event: event, BatchID: event.batchID, Headers: event.forwarded}); err != nil {
+			return err
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return errDiskBufferClosed
+	}
+	if b.totalBytes+int64(data.Len()) > b.maxBytes {
+		return errDiskBufferFull
+	}
+	if b.active == nil {
+		b.activeName = fmt.Sprintf("%s%020d%s", diskBufferPrefix, time.Now().UnixNano(), diskBufferSuffix)
+		f, err := os.OpenFile(filepath.Join(b.dir, b.activeName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return err
+		}
+		b.active = f
+		b.activeBytes = 0
+	}
+
+	n, err := b.active.Write(data.Bytes())
+	b.activeBytes += int64(n)
+	b.totalBytes += int64(n)
+	b.recordBytes()
+	if err != nil {
+		return err
+	}
+	if err := b.active.Sync(); err != nil {
+		return err
+	}
+
+	if b.activeBytes >= b.segmentBytes {
+		b.rotate()
+	}
+	return nil
+}
+
+// Close stops draining and closes the current segment. Events still buffered
+// stay on disk and are produced after the next start.
+func (b *DiskBuffer) Close() {
+	close(b.stop)
+	<-b.done
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	b.rotate()
+	if b.totalBytes > 0 {
+		log.Printf("Disk buffer: %d bytes of events left in %s", b.totalBytes, b.dir)
+	}
+}
+
+// rotate closes the current segment so the next Append starts a new one. Callers hold mu.
+func (b *DiskBuffer) rotate() {
+	if b.active == nil {
+		return
+	}
+	if err := b.active.Close(); err != nil {
+		log.Printf("Disk buffer: failed to close segment %s: %v", b.activeName, err)
+	}
+	b.active = nil
+	b.activeName = ""
+	b.activeBytes = 0
+}
+
+// segments lists the segment files in dir, oldest first
+func (b *DiskBuffer) segments() ([]string, error) {
+	entries, err := os.ReadDir(b.dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() && strings.HasPrefix(name, diskBufferPrefix) && strings.HasSuffix(name, diskBufferSuffix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// run drains the buffer every retryInterval until Close
+func (b *DiskBuffer) run() {
+	defer close(b.done)
+
+	ticker := time.NewTicker(b.retryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			b.drain()
+		case <-b.stop:
+			return
+		}
+	}
+}
+
+// drain produces segments oldest first until the buffer is empty, a write fails
+// or the circuit breaker is open
+func (b *DiskBuffer) drain() {
+	for b.breaker.Ready() {
+		select {
+		case <-b.stop:
+			return
+		default:
+		}
+
+		name, ok := b.oldestSegment()
+		if !ok || !b.drainSegment(name) {
+			return
+		}
+	}
+}
+
+// oldestSegment returns the oldest segment that is no longer appended to. When
+// only the current segment has events it is rotated so it can be drained.
+func (b *DiskBuffer) oldestSegment() (string, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	segments, err := b.segments()
+	if err != nil {
+		log.Printf("Disk buffer: failed to list segments: %v", err)
+		return "", false
+	}
+	for _, name := range segments {
+		if name != b.activeName {
+			return name, true
+		}
+	}
+	if b.active != nil && b.activeBytes > 0 {
+		name := b.activeName
+		b.rotate()
+		return name, true
+	}
+	return "", false
+}
+
+// drainSegment produces the events of a segment in chunks and removes it once all
+// of them were produced. On a Kafka failure the segment is rewritten with the
+// events that are left and false is returned, so the next attempt resumes there.
+// Events that can never be produced (e.g. they fail to marshal) are dropped.
+func (b *DiskBuffer) drainSegment(name string) bool {
+	path := filepath.Join(b.dir, name)
+	events, size, err := readSegment(path)
+	if err != nil {
+		log.Printf("Disk buffer: failed to read segment %s: %v", name, err)
+		return false
+	}
+
+	produced, dropped := 0, 0
+	for start := 0; start < len(events); start += ndjsonChunkSize {
+		chunk := events[start:min(start+ndjsonChunkSize, len(events))]
+		if !b.breaker.Allow() {
+			b.rewriteSegment(name, size, events[start:])
+			return false
+		}
+		errs := b.producer.SendEvents(chunk, SendOptions{})
+		b.breaker.Record(!hasTopicErrors(errs, chunk))
+
+		var retry []Event
+		for _, event := range chunk {
+			topicName, _ := b.producer.Route(event)
+			if err, ok := errs[event.ID]; ok && errors.Is(err, errThrottled) {
+				retry = append(retry, event) // retried once the events per second budget allows
+			} else if ok {
+				log.Printf("Disk buffer: dropping event %s: %v", event.ID, err)
+				dropped++
+			} else if _, ok := errs[topicName]; ok {
+				retry = append(retry, event)
+			} else {
+				produced++
+			}
+		}
+		b.recordEvents(diskBufferStageDrained, produced)
+		b.recordEvents(diskBufferStageDropped, dropped)
+		produced, dropped = 0, 0
+
+		if len(retry) > 0 {
+			log.Printf("Disk buffer: Kafka still unreachable, %d events of %s left", len(retry)+len(events)-start-len(chunk), name)
+			b.rewriteSegment(name, size, append(retry, events[start+len(chunk):]...))
+			return false
+		}
+	}
+
+	if err := os.Remove(path); err != nil {
+		log.Printf("Disk buffer: failed to remove drained segment %s: %v", name, err)
+		return false
+	}
+	b.mu.Lock()
+	b.totalBytes -= size
+	b.recordBytes()
+	b.mu.Unlock()
+	log.Printf("Disk buffer: drained %d events from %s", len(events), name)
+	return true
+}
+
+// readSegment decodes the events of a segment and returns them with the file size.
+// A torn last line, left by a crash while appending, is skipped.
+func readSegment(path string) ([]Event, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var events []Event
+	decoder := json.NewDecoder(bufio.NewReader(f))
+	for {
+		var line bufferedEvent
+		err := decoder.Decode(&line)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Printf("Disk buffer: skipping the rest of %s after %d events: %v", filepath.Base(path), len(events), err)
+			break
+		}
+		event := line.Event
+		event.batchID = line.BatchID
+		event.forwarded = line.Headers
+		events = append(events, event)
+	}
+	return events, info.Size(), nil
+}
+
+// rewriteSegment replaces a segment of oldSize bytes with the given events,
+// through a temporary file so a crash never leaves it half written
+func (b *DiskBuffer) rewriteSegment(name string, oldSize int64, events []Event) {
+	path := filepath.Join(b.dir, name)
+	tmp := path + ".tmp"
+
+	f, err := os.Create(tmp)
+	if err != nil {
+		log.Printf("Disk buffer: failed to rewrite segment %s: %v", name, err)
+		return
+	}
+	w := bufio.NewWriter(f)
+	encoder := json.NewEncoder(w)
+	for _, event := range events {
+		if err = encoder.Encode(bufferedEvent{Event: event, BatchID: event.batchID, Headers: event.forwarded}); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	var info os.FileInfo
+	if err == nil {
+		info, err = os.Stat(tmp)
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		log.Printf("Disk buffer: failed to rewrite segment %s: %v", name, err)
+		os.Remove(tmp)
+		return
+	}
+
+	b.mu.Lock()
+	b.totalBytes += info.Size() - oldSize
+	b.recordBytes()
+	b.mu.Unlock()
+}
+
+// bufferUnreachable holds the events at indexes, which failed because Kafka could
+// not be reached, for a later retry: in the spool while it has room (errSpooled),
+// otherwise in the disk buffer (errBuffered). If neither is configured or can
+// take them their outcome is kept.
+func (kp *KafkaProducer) bufferUnreachable(events []Event, outcomes []error, indexes []int) {
+	if len(indexes) == 0 || (kp.spool == nil && kp.diskBuffer == nil) {
+		return
+	}
+
+	unreachable := make([]Event, len(indexes))
+	for j, i := range indexes {
+		unreachable[j] = events[i]
+	}
+	if kp.spool != nil && kp.spool.Add(unreachable) {
+		for _, i := range indexes {
+			outcomes[i] = errSpooled
+		}
+		return
+	}
+	if kp.diskBuffer == nil {
+		return
+	}
+	if err := kp.diskBuffer.Append(unreachable); err != nil {
+		log.Printf("Disk buffer: failed to store %d events: %v", len(unreachable), err)
+		return
+	}
+	kp.diskBuffer.recordEvents(diskBufferStageBuffered, len(unreachable))
+	for _, i := range indexes {
+		outcomes[i] = errBuffered
+	}
+}
+
+// recordEvents counts n events at the given stage of the disk buffer
+func (b *DiskBuffer) recordEvents(stage string, n int) {
+	if b.producer.config.LiteMode || n == 0 {
+		return
+	}
+	diskBufferEventsTotal.WithLabelValues(stage).Add(float64(n))
+}
+
+// recordBytes publishes the buffered size. Callers hold mu.
+func (b *DiskBuffer) recordBytes() {
+	if b.producer.config.LiteMode {
+		return
+	}
+	diskBufferBytes.Set(float64(b.totalBytes))
+}
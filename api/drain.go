@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+)
+
+// drainCounts tracks the async messages of the topic writers, so shutdown can
+// report what became of the ones still in flight. Dead-letter copies are not
+// counted; the failure that produced them is.
+type drainCounts struct {
+	pending   atomic.Int64 // handed to a writer, not completed yet
+	delivered atomic.Int64
+	failed    atomic.Int64
+}
+
+// DrainReport is what became of the messages in flight when Shutdown was called
+type DrainReport struct {
+	InFlight  int64 `json:"inFlight"`
+	Delivered int64 `json:"delivered"`
+	Failed    int64 `json:"failed"`  // reported by Kafka, dead-lettered when DLQ_TOPIC is set
+	Dropped   int64 `json:"dropped"` // not completed when the drain timed out, so lost
+}
+
+// Shutdown closes the producer like Close, which flushes every writer, but stops
+// waiting once ctx ends. Messages still in flight at that point are reported as
+// dropped: the process is about to exit, so they are lost.
+func (kp *KafkaProducer) Shutdown(ctx context.Context) (DrainReport, error) {
+	report := DrainReport{InFlight: kp.drain.pending.Load()}
+	delivered, failed := kp.drain.delivered.Load(), kp.drain.failed.Load()
+
+	closed := make(chan error, 1)
+	go func() {
+		closed <- kp.Close()
+	}()
+
+	var err error
+	select {
+	case err = <-closed:
+	case <-ctx.Done():
+		err = fmt.Errorf("drain timed out: %w", ctx.Err())
+	}
+
+	report.Delivered = kp.drain.delivered.Load() - delivered
+	report.Failed = kp.drain.failed.Load() - failed
+	report.Dropped = kp.drain.pending.Load()
+	return report, err
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// DURABILITY_PROFILE names
+const (
+	DurabilityFast     = "fast"
+	DurabilityBalanced = "balanced"
+	DurabilitySafe     = "safe"
+)
+
+// NewDurabilityProfile returns the ProducerConfig settings of a named profile, a
+// consistent set of ack level, write attempts, write timeout and write mode. main
+// uses them as defaults that REQUIRED_ACKS, KAFKA_WRITE_ATTEMPTS,
+// KAFKA_WRITE_TIMEOUT and KAFKA_SYNC_WRITES still override one by one.
+func NewDurabilityProfile(name string) (ProducerConfig, error) {
+	switch name {
+	case DurabilityFast:
+		// No broker confirmation and no retries: the lowest latency, and a
+		// message the broker never received is not noticed
+		return ProducerConfig{
+			RequiredAcks:  kafka.RequireNone,
+			WriteAttempts: 1,
+			WriteTimeout:  5 * time.Second,
+		}, nil
+	case DurabilityBalanced:
+		// What the service has always done, kafka-go's own retry defaults
+		return ProducerConfig{
+			RequiredAcks:  kafka.RequireOne,
+			WriteAttempts: 10,
+			WriteTimeout:  10 * time.Second,
+		}, nil
+	case DurabilitySafe:
+		// Every in-sync replica confirms, a batch rides out a leader election, and
+		// a request is answered only once its messages were confirmed
+		return ProducerConfig{
+			RequiredAcks:  kafka.RequireAll,
+			WriteAttempts: 20,
+			WriteTimeout:  30 * time.Second,
+			SyncWrites:    true,
+		}, nil
+	default:
+		return ProducerConfig{}, fmt.Errorf("unknown profile %q, expected %s, %s or %s", name, DurabilityFast, DurabilityBalanced, DurabilitySafe)
+	}
+}
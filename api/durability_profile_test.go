@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/segmentio/kafka-go"
+)
+
+func TestDurabilityProfileWriteMode(t *testing.T) {
+	tests := []struct {
+		profile  string
+		header   string
+		wantSync bool
+	}{
+		{profile: DurabilityFast, wantSync: false},
+		{profile: DurabilityBalanced, wantSync: false},
+		{profile: DurabilityBalanced, header: "true", wantSync: true},
+		{profile: DurabilitySafe, wantSync: true},
+		{profile: DurabilitySafe, header: "false", wantSync: false},
+	}
+
+	gin.SetMode(gin.TestMode)
+	for _, tt := range tests {
+		t.Run(tt.profile+"/X-Sync="+tt.header, func(t *testing.T) {
+			config, err := NewDurabilityProfile(tt.profile)
+			if err != nil {
+				t.Fatalf("NewDurabilityProfile(%q) = %v", tt.profile, err)
+			}
+			var mu sync.Mutex
+			var writers []bool
+			recorder := newMessageRecorder()
+			config.NewWriter = func(topicName string, acks kafka.RequiredAcks, sync bool, completion func(messages []kafka.Message, err error)) MessageWriter {
+				mu.Lock()
+				writers = append(writers, sync)
+				mu.Unlock()
+				return recorder.NewWriter(topicName, acks, sync, completion)
+			}
+			kp := newTestProducer(config)
+			defer kp.Close()
+			h := &EventsHandler{
+				producer: kp,
+				breaker:  NewCircuitBreaker(5, time.Second),
+				stats:    NewServerStats(),
+			}
+			router := gin.New()
+			router.POST("/events", h.Handle)
+
+			body := `[{"id":"1","domain":"orders","subdomain":"checkout","code":"created","version":"1"}]`
+			req := httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.header != "" {
+				req.Header.Set("X-Sync", tt.header)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("POST /events = %d %s, want 200", w.Code, w.Body)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if len(writers) != 1 || writers[0] != tt.wantSync {
+				t.Errorf("writers created with sync = %v, want [%v]", writers, tt.wantSync)
+			}
+		})
+	}
+}
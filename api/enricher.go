@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// EventEnricher adds server-side data to an event right before it is produced.
+// SendEvents runs it on every event, including retries from the spool and disk
+// buffer, so an enricher must leave data it already added unchanged.
+type EventEnricher interface {
+	Enrich(event *Event)
+}
+
+// noopEnricher leaves events as they are; it is used when no enricher is configured
+type noopEnricher struct{}
+
+func (noopEnricher) Enrich(*Event) {}
+
+// EnricherChain runs its enrichers in order
+type EnricherChain []EventEnricher
+
+func (c EnricherChain) Enrich(event *Event) {
+	for _, enricher := range c {
+		enricher.Enrich(event)
+	}
+}
+
+// receivedAtEnricher stamps the time the event was first produced into ReceivedAt
+type receivedAtEnricher struct{}
+
+func (receivedAtEnricher) Enrich(event *Event) {
+	if event.ReceivedAt == "" {
+		event.ReceivedAt = time.Now().Format(time.RFC3339Nano)
+	}
+}
+
+// defaultVersionEnricher fills in defaultEventVersion for events sent without a version
+type defaultVersionEnricher struct{}
+
+func (defaultVersionEnricher) Enrich(event *Event) {
+	if event.Version == "" {
+		event.Version = defaultEventVersion
+	}
+}
+
+// sourceInstanceEnricher records which instance of the service produced the event
+type sourceInstanceEnricher struct {
+	instance string
+}
+
+func (e sourceInstanceEnricher) Enrich(event *Event) {
+	event.SourceInstance = e.instance
+}
+
+// Enricher names accepted by EVENT_ENRICHERS
+const (
+	EnricherReceivedAt     = "received_at"
+	EnricherDefaultVersion = "default_version"
+	EnricherSourceInstance = "source_instance"
+)
+
+// legacyEnrichers is what ENABLE_ENRICHMENT=true has always done
+var legacyEnrichers = []string{EnricherReceivedAt, EnricherDefaultVersion}
+
+// NewEnricherChain builds the enrichers named in order; instance is stamped by source_instance
+func NewEnricherChain(names []string, instance string) (EnricherChain, error) {
+	var chain EnricherChain
+	for _, name := range names {
+		switch name {
+		case EnricherReceivedAt:
+			chain = append(chain, receivedAtEnricher{})
+		case EnricherDefaultVersion:
+			chain = append(chain, defaultVersionEnricher{})
+		case EnricherSourceInstance:
+			chain = append(chain, sourceInstanceEnricher{instance: instance})
+		default:
+			return nil, fmt.Errorf("unknown enricher %q, expected %s, %s or %s", name, EnricherReceivedAt, EnricherDefaultVersion, EnricherSourceInstance)
+		}
+	}
+	return chain, nil
+}
+
+// ParseEnricherNames splits the comma-separated EVENT_ENRICHERS list, dropping
+// empty entries and repeats
+func ParseEnricherNames(list string) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return names
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EVENT_TIME_FORMAT names for the formats that are not a Go layout
+const (
+	EventTimeRFC3339 = "rfc3339"
+	EventTimeUnixMs  = "unixms"
+	EventTimeUnixSec = "unixsec"
+)
+
+// EventTimeFormat is the format clients send EventTime in. Accepted values are
+// normalized to RFC3339 before they are produced, so consumers and
+// MESSAGE_TIME_SOURCE=event see a single format whatever the client sent.
+type EventTimeFormat struct {
+	name   string
+	layout string // Go layout, empty for the unix formats
+}
+
+// ParseEventTimeFormat parses EVENT_TIME_FORMAT: rfc3339, unixms, unixsec or a Go
+// time layout like "2006-01-02 15:04:05"
+func ParseEventTimeFormat(s string) (*EventTimeFormat, error) {
+	switch strings.ToLower(s) {
+	case EventTimeRFC3339:
+		return &EventTimeFormat{name: EventTimeRFC3339, layout: time.RFC3339Nano}, nil
+	case EventTimeUnixMs:
+		return &EventTimeFormat{name: EventTimeUnixMs}, nil
+	case EventTimeUnixSec:
+		return &EventTimeFormat{name: EventTimeUnixSec}, nil
+	}
+	// A layout without any reference time element formats every time as itself
+	probe := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	if probe.Format(s) == s {
+		return nil, fmt.Errorf("%q is neither rfc3339, unixms, unixsec nor a Go time layout", s)
+	}
+	return &EventTimeFormat{name: s, layout: s}, nil
+}
+
+// String returns the format as configured
+func (f *EventTimeFormat) String() string {
+	return f.name
+}
+
+// Parse parses an EventTime value in this format
+func (f *EventTimeFormat) Parse(value string) (time.Time, error) {
+	if f.layout != "" {
+		t, err := time.Parse(f.layout, value)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("eventtime %q does not match format %s", value, f.name)
+		}
+		return t, nil
+	}
+
+	n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("eventtime %q is not a %s epoch", value, f.name)
+	}
+	if f.name == EventTimeUnixMs {
+		return time.UnixMilli(n).UTC(), nil
+	}
+	return time.Unix(n, 0).UTC(), nil
+}
+
+// Normalize returns value in the canonical RFC3339 form. Values are checked by
+// checkEvent first, so one that does not parse is returned unchanged.
+func (f *EventTimeFormat) Normalize(value string) string {
+	t, err := f.Parse(value)
+	if err != nil {
+		return value
+	}
+	return t.Format(time.RFC3339Nano)
+}
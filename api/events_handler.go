@@ -0,0 +1,1175 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ndjsonChunkSize is how many NDJSON events are decoded before they are produced
+const ndjsonChunkSize = 500
+
+// EventsHandler serves POST /events
+type EventsHandler struct {
+	producer         *KafkaProducer
+	strictJSON       bool
+	enableEnrichment bool // the producer runs EVENT_ENRICHERS
+
+	// idempotency is nil unless Idempotency-Key support is enabled
+	idempotency *IdempotencyCache
+
+	breaker *CircuitBreaker
+
+	// shedder is nil unless TIMEOUT_BACKPRESSURE_THRESHOLD is set
+	shedder *LoadShedder
+
+	// accumulator is nil unless events are buffered across requests
+	accumulator *Accumulator
+
+	// rateLimiter is nil unless DOMAIN_RATE_LIMITS is set
+	rateLimiter *DomainRateLimiter
+
+	// partialStatus answers 207 instead of 200 when some events were invalid or failed
+	partialStatus bool
+
+	// payloadJSON rejects events whose payload is not valid JSON
+	payloadJSON bool
+
+	// positiveFields lists the POSITIVE_INT_FIELDS that must be greater than zero
+	positiveFields []string
+
+	// eventTimeFormat is nil unless EVENT_TIME_FORMAT is set
+	eventTimeFormat *EventTimeFormat
+
+	// verboseErrors adds the decoder's message to invalid JSON responses
+	verboseErrors bool
+
+	// allowEmptyBatch answers an empty array with empty result lists instead of 400
+	allowEmptyBatch bool
+
+	// forwardHeaders lists the FORWARD_HEADERS copied from the request into every message
+	forwardHeaders []string
+
+	// flushOnBatchComplete answers only once the batches of the async writes completed
+	flushOnBatchComplete bool
+
+	// stats counts requests and event outcomes for GET /protected/stats
+	stats *ServerStats
+
+	// idField is the JSON field holding the event ID when it is not "id"; empty
+	// decodes straight into Event
+	idField string
+}
+
+// ndjsonErrorResponse is returned when an NDJSON stream breaks after some events were
+// already produced, so the client still learns the outcome of those events.
+type ndjsonErrorResponse struct {
+	EventResponse
+	Error  string   `json:"error"`
+	Detail string   `json:"detail,omitempty"`
+	Fields []string `json:"fields,omitempty"`
+	Event  int      `json:"event"` // 1-based position of the event that failed to decode
+}
+
+func newEventResponse() EventResponse {
+	return EventResponse{
+		SuccessEventIds: []string{},
+		InvalidEventIds: []string{},
+		FailedEventIds:  []string{},
+		Failures:        []EventFailure{},
+
+		RateLimitedEventIds: []string{},
+	}
+}
+
+// Event outcome statuses, matching the EventResponse lists
+const (
+	OutcomeSuccess     = "success"
+	OutcomeAccepted    = "accepted"
+	OutcomeInvalid     = "invalid"
+	OutcomeFailed      = "failed"
+	OutcomeRateLimited = "rate_limited"
+	OutcomeBuffered    = "buffered"
+	OutcomeSpooled     = "spooled"
+	OutcomeDuplicate   = "duplicate"
+)
+
+// EventOutcome is the result of a single event. Streamed responses send one per line.
+type EventOutcome struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+
+	err error // the produce error behind a failed or rate limited outcome
+}
+
+// record adds an outcome to the list matching its status, and its reason to Failures
+func (r *EventResponse) record(outcome EventOutcome) {
+	switch outcome.Status {
+	case OutcomeSuccess:
+		r.SuccessEventIds = append(r.SuccessEventIds, outcome.ID)
+	case OutcomeAccepted:
+		r.AcceptedEventIds = append(r.AcceptedEventIds, outcome.ID)
+	case OutcomeInvalid:
+		r.InvalidEventIds = append(r.InvalidEventIds, outcome.ID)
+	case OutcomeFailed:
+		r.FailedEventIds = append(r.FailedEventIds, outcome.ID)
+	case OutcomeRateLimited:
+		r.RateLimitedEventIds = append(r.RateLimitedEventIds, outcome.ID)
+	case OutcomeBuffered:
+		r.BufferedEventIds = append(r.BufferedEventIds, outcome.ID)
+	case OutcomeSpooled:
+		r.SpooledEventIds = append(r.SpooledEventIds, outcome.ID)
+	case OutcomeDuplicate:
+		r.DuplicateEventIds = append(r.DuplicateEventIds, outcome.ID)
+	}
+	if outcome.Reason != "" {
+		r.Failures = append(r.Failures, EventFailure{ID: outcome.ID, Reason: outcome.Reason})
+	}
+	if outcome.Status == OutcomeFailed && errors.Is(outcome.err, errQueueFull) {
+		r.queueFull = true
+	}
+	if errors.Is(outcome.err, errPartitionOutOfRange) {
+		r.partitionOutOfRange = true
+	}
+}
+
+// produced reports whether any event was produced or taken for a later write
+func (r *EventResponse) produced() bool {
+	return len(r.SuccessEventIds) > 0 || len(r.AcceptedEventIds) > 0 ||
+		len(r.BufferedEventIds) > 0 || len(r.SpooledEventIds) > 0
+}
+
+// Handle decodes the request body as a JSON array, or as NDJSON when the
+// Content-Type is application/x-ndjson, and produces the valid events
+func (h *EventsHandler) Handle(c *gin.Context) {
+	defer func() { h.stats.RecordRequest(c.Writer.Status()) }()
+
+	// Every request gets an ID that is returned, logged and added to its Kafka messages
+	var sendOpts SendOptions
+	sendOpts.BatchID = newBatchID()
+	c.Header("X-Batch-ID", sendOpts.BatchID)
+	c.Set(batchIDKey, sendOpts.BatchID)
+	sendOpts.Headers = forwardedHeaders(c.Request, h.forwardHeaders)
+
+	// Optional per-request ack level override. X-Required-Acks does the same, but
+	// an invalid value falls back to REQUIRED_ACKS instead of failing the request.
+	if v := c.GetHeader("X-Kafka-Acks"); v != "" {
+		acks, err := parseRequiredAcks(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Invalid X-Kafka-Acks header, expected none, one or all",
+			})
+			return
+		}
+		sendOpts.Acks = &acks
+	} else if v := c.GetHeader("X-Required-Acks"); v != "" {
+		if acks, err := parseRequiredAcks(v); err == nil {
+			sendOpts.Acks = &acks
+		} else {
+			log.Printf("Ignoring invalid X-Required-Acks header %q, using the default ack level", v)
+		}
+	}
+
+	// X-Kafka-Partition pins the request's messages to one partition, for debugging
+	// and ordering special cases; SendEvents checks it against each topic
+	if v := c.GetHeader("X-Kafka-Partition"); v != "" {
+		partition, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil || partition < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Invalid X-Kafka-Partition header, expected a partition number",
+			})
+			return
+		}
+		sendOpts.Partition = &partition
+	}
+
+	// X-Sync waits for the broker's answer for every message, so the response
+	// reports real delivery failures at the cost of the request's latency. Its
+	// default is KAFKA_SYNC_WRITES, which DURABILITY_PROFILE=safe turns on.
+	sendOpts.Sync = h.producer.config.SyncWrites
+	if v := c.GetHeader("X-Sync"); v != "" {
+		sync, err := strconv.ParseBool(strings.TrimSpace(v))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Invalid X-Sync header, expected true or false",
+			})
+			return
+		}
+		sendOpts.Sync = sync
+	}
+	// Accumulated events are answered with a ticket, not after their write
+	sendOpts.Flush = h.flushOnBatchComplete && h.accumulator == nil
+
+	// Fail fast while Kafka writes are known to be failing
+	if !h.breaker.Ready() {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(h.breaker.RetryAfter().Seconds()))))
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Kafka is unavailable, circuit breaker is open",
+		})
+		return
+	}
+	if h.shedder != nil {
+		if retryAfter := h.shedder.RetryAfter(); retryAfter > 0 {
+			h.shedder.Shed()
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "Kafka writes are timing out, shedding load",
+			})
+			return
+		}
+	}
+
+	// Repeated Idempotency-Key values are answered from the cache without producing again
+	idempotencyKey := c.GetHeader("Idempotency-Key")
+	if h.idempotency != nil && idempotencyKey != "" {
+		cached, inFlight := h.idempotency.Begin(idempotencyKey)
+		if cached != nil {
+			c.Header("Idempotent-Replayed", "true")
+			c.JSON(h.responseStatus(*cached), cached)
+			return
+		}
+		if inFlight {
+			c.JSON(http.StatusConflict, gin.H{
+				"error": "A request with this Idempotency-Key is still being processed",
+			})
+			return
+		}
+	}
+
+	// With accumulation the events are produced later under a ticket
+	var ticketID string
+	if h.accumulator != nil {
+		ticketID = h.accumulator.NewTicket()
+	}
+
+	// JSON array requests can ask for their outcomes to be streamed as they are produced
+	streaming := h.accumulator == nil && c.ContentType() != "application/x-ndjson" &&
+		strings.Contains(c.GetHeader("Accept"), "application/x-ndjson")
+
+	var response EventResponse
+	var ok bool
+	start := time.Now()
+	switch {
+	case streaming:
+		response, ok = h.handleStream(c, sendOpts)
+	case c.ContentType() == "application/x-ndjson":
+		response, ok = h.handleNDJSON(c, ticketID, sendOpts)
+	default:
+		response, ok = h.handleJSON(c, ticketID, sendOpts)
+	}
+	h.stats.RecordEvents(response)
+	if h.accumulator != nil {
+		h.accumulator.Seal(ticketID, response)
+	}
+
+	// Rate limited and backpressured requests are not cached, a retry later may succeed
+	if h.idempotency != nil && idempotencyKey != "" {
+		if status := h.responseStatus(response); ok && status != http.StatusTooManyRequests && status != http.StatusServiceUnavailable {
+			h.idempotency.Complete(idempotencyKey, response)
+		} else {
+			h.idempotency.Abort(idempotencyKey)
+		}
+	}
+	if ok && !streaming {
+		// Shows what waiting for the broker cost the request
+		if sendOpts.Sync {
+			c.Header("Server-Timing", fmt.Sprintf("produce;desc=\"sync\";dur=%.1f", float64(time.Since(start).Microseconds())/1000))
+		} else if sendOpts.Flush {
+			c.Header("Server-Timing", fmt.Sprintf("produce;desc=\"flush\";dur=%.1f", float64(time.Since(start).Microseconds())/1000))
+		}
+		// Async messages of all requests still waiting in the writers
+		c.Header("X-Pending-Messages", strconv.FormatInt(h.producer.Pending(), 10))
+		c.JSON(h.responseStatus(response), response)
+	}
+}
+
+// responseStatus picks the status of a produce response by the first rule that
+// applies:
+//
+//  1. 400 when X-Kafka-Partition was out of range for a topic and no event was produced
+//  2. 503 when ASYNC_QUEUE_POLICY=error rejected events and no event was produced
+//  3. 429 when events were rate limited (or over ASYNC_MAX_BYTES) and no other event
+//     was produced or failed
+//  4. 202 when the events were accumulated under a ticket and are not produced yet
+//  5. 207 when some events were invalid, failed or rate limited, unless
+//     PARTIAL_STATUS_CODE is disabled
+//  6. 202 when events were spooled or stored in the disk buffer
+//  7. 200 otherwise
+func (h *EventsHandler) responseStatus(response EventResponse) int {
+	if response.partitionOutOfRange && !response.produced() {
+		return http.StatusBadRequest
+	}
+	if response.queueFull && !response.produced() {
+		return http.StatusServiceUnavailable
+	}
+
+	switch {
+	case len(response.RateLimitedEventIds) > 0 && len(response.SuccessEventIds) == 0 &&
+		len(response.FailedEventIds) == 0 && len(response.AcceptedEventIds) == 0 &&
+		len(response.BufferedEventIds) == 0 && len(response.SpooledEventIds) == 0:
+		return http.StatusTooManyRequests
+	case response.Ticket != "":
+		return http.StatusAccepted
+	case h.partialStatus && (len(response.InvalidEventIds) > 0 || len(response.FailedEventIds) > 0 ||
+		len(response.RateLimitedEventIds) > 0):
+		return http.StatusMultiStatus
+	case len(response.BufferedEventIds) > 0 || len(response.SpooledEventIds) > 0:
+		return http.StatusAccepted
+	}
+	return http.StatusOK
+}
+
+// Ticket serves GET /events/tickets/:ticket with the result of accumulated events
+func (h *EventsHandler) Ticket(c *gin.Context) {
+	status, ok := h.accumulator.Ticket(c.Param("ticket"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Unknown or expired ticket",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+// ValidationReport is the response of POST /events/validate
+type ValidationReport struct {
+	Valid   int                `json:"valid"`
+	Invalid int                `json:"invalid"`
+	Results []ValidationResult `json:"results"`
+}
+
+// ValidationResult is the validation outcome of a single event, in request order
+type ValidationResult struct {
+	ID     string `json:"id"`
+	Valid  bool   `json:"valid"`
+	Topic  string `json:"topic,omitempty"` // topic a valid event would be produced to
+	Reason string `json:"reason,omitempty"`
+}
+
+// Validate serves POST /events/validate. It decodes and validates the events
+// exactly like POST /events but never enriches or produces them.
+func (h *EventsHandler) Validate(c *gin.Context) {
+	events, err := h.decodeBatch(c.Request.Body)
+	if err != nil {
+		status, body := decodeErrorResponse(err, h.verboseErrors)
+		c.JSON(status, body)
+		return
+	}
+
+	report := ValidationReport{Results: make([]ValidationResult, 0, len(events))}
+	for _, event := range events {
+		event = normalizeEvent(event)
+		result := ValidationResult{ID: event.ID, Valid: true}
+		if err := h.checkEvent(event); err != nil {
+			result.Valid = false
+			result.Reason = err.Error()
+			report.Invalid++
+		} else {
+			result.Topic, _ = h.producer.Route(event)
+			report.Valid++
+		}
+		report.Results = append(report.Results, result)
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// handleJSON decodes a JSON array body and produces the valid events. On a decode
+// error it writes the error response itself and returns false.
+func (h *EventsHandler) handleJSON(c *gin.Context, ticketID string, sendOpts SendOptions) (EventResponse, bool) {
+	events, err := h.decodeBatch(c.Request.Body)
+	if err != nil {
+		status, body := decodeErrorResponse(err, h.verboseErrors)
+		c.JSON(status, body)
+		return EventResponse{}, false
+	}
+
+	response := newEventResponse()
+	response.Ticket = ticketID
+	if wantsTopics(c) {
+		response.Topics = map[string]string{}
+	}
+	if wantsRouting(c) {
+		response.Routed = []RoutedEvent{}
+	}
+	h.process(events, sendOpts, &response)
+
+	return response, true
+}
+
+// handleNDJSON streams newline-delimited events from the body and produces them
+// in chunks, so large batches never have to be held in memory all at once. On a
+// decode error it writes the partial results itself and returns false.
+func (h *EventsHandler) handleNDJSON(c *gin.Context, ticketID string, sendOpts SendOptions) (EventResponse, bool) {
+	decoder := json.NewDecoder(c.Request.Body)
+
+	response := newEventResponse()
+	response.Ticket = ticketID
+	if wantsTopics(c) {
+		response.Topics = map[string]string{}
+	}
+	if wantsRouting(c) {
+		response.Routed = []RoutedEvent{}
+	}
+	chunk := make([]Event, 0, ndjsonChunkSize)
+	for decoded := 0; ; decoded++ {
+		event, err := decodeEvent(decoder, h.strictJSON, h.idField)
+		if err == io.EOF && decoded == 0 {
+			status, body := decodeErrorResponse(errEmptyBody, h.verboseErrors)
+			c.JSON(status, body)
+			return response, false
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// Events decoded before the broken line are still produced and reported
+			h.process(chunk, sendOpts, &response)
+
+			status, body := decodeErrorResponse(err, h.verboseErrors)
+			fields, _ := body["fields"].([]string)
+			detail, _ := body["detail"].(string)
+			c.JSON(status, ndjsonErrorResponse{
+				EventResponse: response,
+				Error:         fmt.Sprint(body["error"]),
+				Detail:        detail,
+				Fields:        fields,
+				Event:         decoded + 1,
+			})
+			return response, false
+		}
+
+		chunk = append(chunk, event)
+		if len(chunk) == ndjsonChunkSize {
+			h.process(chunk, sendOpts, &response)
+			chunk = chunk[:0]
+		}
+	}
+	h.process(chunk, sendOpts, &response)
+
+	return response, true
+}
+
+// streamSummary is the last line of a streamed response
+type streamSummary struct {
+	Done        bool `json:"done"`
+	Success     int  `json:"success"`
+	Invalid     int  `json:"invalid"`
+	Failed      int  `json:"failed"`
+	RateLimited int  `json:"rateLimited"`
+	Buffered    int  `json:"buffered,omitempty"`
+	Spooled     int  `json:"spooled,omitempty"`
+}
+
+// handleStream decodes a JSON array body and writes one EventOutcome line per event
+// as NDJSON, followed by a streamSummary. Topics are produced one at a time and the
+// response is flushed after each, so clients see results before the whole batch is
+// done. On a decode error it writes the error response itself and returns false.
+func (h *EventsHandler) handleStream(c *gin.Context, sendOpts SendOptions) (EventResponse, bool) {
+	events, err := h.decodeBatch(c.Request.Body)
+	if err != nil {
+		status, body := decodeErrorResponse(err, h.verboseErrors)
+		c.JSON(status, body)
+		return EventResponse{}, false
+	}
+
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Status(http.StatusOK)
+
+	response := newEventResponse()
+	encoder := json.NewEncoder(c.Writer)
+	emit := func(outcome EventOutcome) {
+		response.record(outcome)
+		if err := encoder.Encode(outcome); err != nil {
+			log.Printf("Failed to stream outcome of event %s: %v", outcome.ID, err)
+		}
+	}
+
+	validEvents := h.prepare(events, sendOpts, emit)
+	c.Writer.Flush()
+
+	for _, topicEvents := range h.groupByTopic(validEvents) {
+		outcomes := produceEvents(h.producer, h.breaker, topicEvents, sendOpts)
+		for i, event := range topicEvents {
+			emit(outcomeFor(event, outcomes[i]))
+		}
+		c.Writer.Flush()
+	}
+
+	_ = encoder.Encode(streamSummary{
+		Done:        true,
+		Success:     len(response.SuccessEventIds),
+		Invalid:     len(response.InvalidEventIds),
+		Failed:      len(response.FailedEventIds),
+		RateLimited: len(response.RateLimitedEventIds),
+		Buffered:    len(response.BufferedEventIds),
+		Spooled:     len(response.SpooledEventIds),
+	})
+	return response, true
+}
+
+// groupByTopic splits events by the topic they are routed to, in order of first appearance
+func (h *EventsHandler) groupByTopic(events []Event) [][]Event {
+	index := make(map[string]int)
+	var groups [][]Event
+	for _, event := range events {
+		topicName, _ := h.producer.Route(event)
+		i, ok := index[topicName]
+		if !ok {
+			i = len(groups)
+			index[topicName] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], event)
+	}
+	return groups
+}
+
+// process validates, enriches and produces events, recording each outcome in response
+func (h *EventsHandler) process(events []Event, sendOpts SendOptions, response *EventResponse) {
+	validEvents := h.prepare(events, sendOpts, response.record)
+	if len(validEvents) == 0 {
+		return
+	}
+
+	if response.Topics != nil {
+		for _, event := range validEvents {
+			if topicName, err := h.producer.Route(event); err == nil {
+				response.Topics[event.ID] = topicName
+			}
+		}
+	}
+
+	if h.accumulator != nil {
+		status, reason := OutcomeAccepted, ""
+		if err := h.accumulator.Add(response.Ticket, validEvents, sendOpts); err != nil {
+			status, reason = OutcomeRateLimited, err.Error()
+		}
+		for _, event := range validEvents {
+			response.record(EventOutcome{ID: event.ID, Status: status, Reason: reason})
+		}
+		return
+	}
+
+	outcomes := produceEvents(h.producer, h.breaker, validEvents, sendOpts)
+	for i, event := range validEvents {
+		response.record(outcomeFor(event, outcomes[i]))
+		if response.Routed != nil && outcomes[i] == nil {
+			topicName, _ := h.producer.Route(event)
+			response.Routed = append(response.Routed, RoutedEvent{
+				ID:    event.ID,
+				Topic: topicName,
+				Key:   string(h.producer.MessageKey(event)),
+			})
+		}
+	}
+}
+
+// prepare normalizes, validates and rate limits events, passing the
+// outcome of every rejected event to record and returning the ones to produce
+func (h *EventsHandler) prepare(events []Event, sendOpts SendOptions, record func(EventOutcome)) []Event {
+	start := time.Now()
+	validEvents := []Event{}
+	for _, event := range events {
+		event = normalizeEvent(event)
+		if err := h.checkEvent(event); err != nil {
+			record(EventOutcome{ID: event.ID, Status: OutcomeInvalid, Reason: err.Error()})
+			continue
+		}
+		if h.eventTimeFormat != nil && event.EventTime != "" {
+			event.EventTime = h.eventTimeFormat.Normalize(event.EventTime)
+		}
+		if h.rateLimiter != nil && !h.rateLimiter.Allow(event.Domain) {
+			h.countDomain(event.Domain, domainOutcomeRateLimited)
+			record(EventOutcome{ID: event.ID, Status: OutcomeRateLimited, Reason: fmt.Sprintf("rate limit exceeded for domain %s", event.Domain)})
+			continue
+		}
+		h.countDomain(event.Domain, domainOutcomeAllowed)
+		// Fields the enrichers set are never taken from the client
+		if h.enableEnrichment {
+			event.ReceivedAt, event.SourceInstance = "", ""
+		}
+		event.batchID = sendOpts.BatchID
+		event.forwarded = sendOpts.Headers
+		validEvents = append(validEvents, event)
+	}
+
+	if !h.producer.config.LiteMode && len(events) > 0 {
+		observeLatency(validationLatencySeconds, start, len(validEvents) < len(events))
+	}
+	return validEvents
+}
+
+// countDomain adds a valid event to domain_events_total
+func (h *EventsHandler) countDomain(domain, outcome string) {
+	if !h.producer.config.LiteMode {
+		domainEventsTotal.WithLabelValues(domain, outcome).Inc()
+	}
+}
+
+// wantsRouting reports whether the request asked for the topic and key of each
+// produced event with ?verbose=true
+func wantsRouting(c *gin.Context) bool {
+	verbose, _ := strconv.ParseBool(c.Query("verbose"))
+	return verbose
+}
+
+// wantsTopics reports whether the request asked for the topic of each event in the response
+func wantsTopics(c *gin.Context) bool {
+	verbose, _ := strconv.ParseBool(c.Query("verbose"))
+	header, _ := strconv.ParseBool(c.GetHeader("X-Return-Topics"))
+	return verbose || header
+}
+
+// newBatchID returns a random (version 4) UUID
+func newBatchID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// checkEvent validates an event and makes sure it has a topic to be produced to.
+// With a default topic, events that only lack routing fields are still accepted.
+func (h *EventsHandler) checkEvent(event Event) error {
+	if event.invalid != nil {
+		return event.invalid
+	}
+	if event.ID == "" && h.idField != "" {
+		return fmt.Errorf("%s is required", h.idField)
+	}
+	err := validateEvent(event, h.payloadJSON)
+	if err != nil && (event.ID == "" || h.producer.config.DefaultTopic == "" || errors.Is(err, errPayloadNotJSON)) {
+		return err
+	}
+	if err := checkPositiveInts(event, h.positiveFields); err != nil {
+		return err
+	}
+	if h.eventTimeFormat != nil && event.EventTime != "" {
+		if _, err := h.eventTimeFormat.Parse(event.EventTime); err != nil {
+			return err
+		}
+	}
+	_, err = h.producer.Route(event)
+	return err
+}
+
+// errBreakerOpen is the outcome of events rejected by an open circuit breaker
+var errBreakerOpen = errors.New("circuit breaker is open")
+
+// produceEvents sends validated events through the circuit breaker and returns
+// each event's outcome, aligned with events: nil when it was produced, errSpooled
+// or errBuffered when Kafka was unreachable and the event is held for a retry
+func produceEvents(producer *KafkaProducer, breaker *CircuitBreaker, events []Event, sendOpts SendOptions) []error {
+	outcomes := make([]error, len(events))
+
+	// The breaker may have opened while the request was being decoded
+	if !breaker.Allow() {
+		unreachable := make([]int, len(events))
+		for i := range events {
+			outcomes[i] = errBreakerOpen
+			unreachable[i] = i
+		}
+		producer.bufferUnreachable(events, outcomes, unreachable)
+		return outcomes
+	}
+
+	// Send valid events in batch
+	start := time.Now()
+	errs := producer.SendEvents(events, sendOpts)
+	if !producer.config.LiteMode {
+		observeLatency(produceLatencySeconds, start, len(errs) > 0)
+	}
+	breaker.Record(!hasTopicErrors(errs, events))
+
+	// Process results
+	var unreachable []int // events whose topic could not be written to
+	for i, event := range events {
+		eventID := event.ID
+		topicName, _ := producer.Route(event)
+
+		// Check for individual event errors (marshaling errors, or a failed sub-batch)
+		if err, exists := errs[eventID]; exists {
+			log.Printf("Error processing event with ID %s: %v", eventID, err)
+			outcomes[i] = err
+			var batchErr *batchWriteError
+			if errors.As(err, &batchErr) && !errors.Is(err, errQueueFull) && !errors.Is(err, errQueueDropped) {
+				unreachable = append(unreachable, i)
+			}
+		} else if err, exists := errs[topicName]; exists {
+			// Check for topic-level errors (sending errors)
+			log.Printf("Error sending event with ID %s to topic %s: %v", eventID, topicName, err)
+			outcomes[i] = err
+			// Backpressure from the async queue and a bad X-Kafka-Partition are not an outage
+			if !errors.Is(err, errQueueFull) && !errors.Is(err, errQueueDropped) && !errors.Is(err, errPartitionOutOfRange) {
+				unreachable = append(unreachable, i)
+			}
+		}
+	}
+	producer.bufferUnreachable(events, outcomes, unreachable)
+
+	return outcomes
+}
+
+// outcomeFor turns a produceEvents result into the event's outcome
+func outcomeFor(event Event, err error) EventOutcome {
+	if errors.Is(err, errBuffered) {
+		return EventOutcome{ID: event.ID, Status: OutcomeBuffered}
+	}
+	if errors.Is(err, errSpooled) {
+		return EventOutcome{ID: event.ID, Status: OutcomeSpooled}
+	}
+	if errors.Is(err, errThrottled) || errors.Is(err, errQueueBytesFull) {
+		return EventOutcome{ID: event.ID, Status: OutcomeRateLimited, Reason: err.Error(), err: err}
+	}
+	if err != nil {
+		return EventOutcome{ID: event.ID, Status: OutcomeFailed, Reason: err.Error(), err: err}
+	}
+	return EventOutcome{ID: event.ID, Status: OutcomeSuccess}
+}
+
+// hasTopicErrors reports whether any topic-level or sub-batch write failed.
+// Other per-event errors, like marshal errors, do not indicate a Kafka problem.
+func hasTopicErrors(errs map[string]error, events []Event) bool {
+	eventIDs := make(map[string]bool, len(events))
+	for _, event := range events {
+		eventIDs[event.ID] = true
+	}
+	for key, err := range errs {
+		// A full async queue is backpressure, not a Kafka failure
+		if errors.Is(err, errQueueFull) || errors.Is(err, errPartitionOutOfRange) {
+			continue
+		}
+		var batchErr *batchWriteError
+		if !eventIDs[key] || errors.As(err, &batchErr) {
+			return true
+		}
+	}
+	return false
+}
+
+// errEmptyBody is returned when a request body contains no JSON at all. An empty
+// array is not an error unless ALLOW_EMPTY_BATCH is disabled.
+var errEmptyBody = errors.New("request body is empty")
+
+// errEmptyBatch is returned for an empty JSON array with ALLOW_EMPTY_BATCH=false
+var errEmptyBatch = errors.New("request contains no events")
+
+// decodeBatch decodes a JSON body with the handler's settings. An empty array is
+// answered with empty result lists, or rejected with ALLOW_EMPTY_BATCH=false so a
+// client whose payload serialized to nothing notices.
+func (h *EventsHandler) decodeBatch(body io.Reader) ([]Event, error) {
+	events, err := decodeEvents(body, h.strictJSON, h.idField)
+	if err == nil && len(events) == 0 && !h.allowEmptyBatch {
+		return nil, errEmptyBatch
+	}
+	return events, err
+}
+
+// decodeErrorResponse maps a body decoding error to an HTTP status and JSON body.
+// With verbose set, malformed JSON also gets the decoder's message as detail.
+func decodeErrorResponse(err error, verbose bool) (int, gin.H) {
+	if errors.Is(err, errEmptyBody) {
+		return http.StatusBadRequest, gin.H{
+			"error": "Request body is empty, expected a JSON array of events",
+		}
+	}
+	if errors.Is(err, errEmptyBatch) {
+		return http.StatusBadRequest, gin.H{
+			"error": "Request contains no events, expected at least one event",
+		}
+	}
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return http.StatusRequestEntityTooLarge, gin.H{
+			"error": fmt.Sprintf("Request body exceeds %d bytes", maxBytesErr.Limit),
+		}
+	}
+	var unknownErr *UnknownFieldsError
+	if errors.As(err, &unknownErr) {
+		return http.StatusBadRequest, gin.H{
+			"error":  "Unknown JSON fields",
+			"fields": unknownErr.Fields,
+		}
+	}
+	if field, ok := unknownFieldName(err); ok {
+		return http.StatusBadRequest, gin.H{
+			"error":  "Unknown JSON fields",
+			"fields": []string{field},
+		}
+	}
+	if verbose {
+		return http.StatusBadRequest, gin.H{
+			"error":  "Invalid JSON format",
+			"detail": decodeErrorDetail(err),
+		}
+	}
+	return http.StatusBadRequest, gin.H{
+		"error": "Invalid JSON format",
+	}
+}
+
+// jsonPositionError is a syntax or type error located in the request body
+type jsonPositionError struct {
+	err          error
+	line, column int
+}
+
+func (e *jsonPositionError) Error() string { return e.err.Error() }
+func (e *jsonPositionError) Unwrap() error { return e.err }
+
+// locateJSONError adds the line and column to syntax and type errors from
+// decoding data, so clients can find the broken spot in their body
+func locateJSONError(err error, data []byte) error {
+	offset, ok := jsonErrorOffset(err)
+	if !ok {
+		return err
+	}
+	offset = min(offset, int64(len(data)))
+	before := data[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := len(before) - bytes.LastIndexByte(before, '\n') - 1
+	return &jsonPositionError{err: err, line: line, column: column}
+}
+
+// jsonErrorOffset returns the input offset encoding/json reported for err
+func jsonErrorOffset(err error) (int64, bool) {
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return syntaxErr.Offset, true
+	}
+	// Type errors from custom unmarshalers carry no offset
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return typeErr.Offset, typeErr.Offset > 0
+	}
+	return 0, false
+}
+
+// decodeErrorDetail describes a decode error for VERBOSE_ERRORS responses, with
+// its position for whole bodies. NDJSON errors report the broken event instead.
+func decodeErrorDetail(err error) string {
+	var posErr *jsonPositionError
+	if errors.As(err, &posErr) {
+		return fmt.Sprintf("%v (line %d, column %d)", posErr.err, posErr.line, posErr.column)
+	}
+	return err.Error()
+}
+
+// unknownFieldName extracts the field from a DisallowUnknownFields decode error.
+// encoding/json has no typed error for unknown fields, so the message is parsed.
+func unknownFieldName(err error) (string, bool) {
+	const prefix = "json: unknown field "
+	if !strings.HasPrefix(err.Error(), prefix) {
+		return "", false
+	}
+	field, unquoteErr := strconv.Unquote(strings.TrimPrefix(err.Error(), prefix))
+	if unquoteErr != nil {
+		return "", false
+	}
+	return field, true
+}
+
+// UnknownFieldsError reports JSON fields that do not map to any Event field
+type UnknownFieldsError struct {
+	Fields []string
+}
+
+func (e *UnknownFieldsError) Error() string {
+	return fmt.Sprintf("unknown fields: %s", strings.Join(e.Fields, ", "))
+}
+
+// eventFields holds the lower-cased JSON names of all Event fields. encoding/json
+// matches keys case-insensitively, so lookups use the same folding.
+var eventFields = func() map[string]bool {
+	fields := make(map[string]bool)
+	t := reflect.TypeOf(Event{})
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			fields[strings.ToLower(name)] = true
+		}
+	}
+	return fields
+}()
+
+// decodeEvents decodes the request body into events. The body is either an array
+// of events or a single event object, which is treated as a one-element array.
+// In strict mode unknown fields are rejected, and all of them are reported rather
+// than just the first. With an idField the events are decoded through a generic
+// map so the ID can be taken from that field.
+func decodeEvents(body io.Reader, strict bool, idField string) ([]Event, error) {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, errEmptyBody
+	}
+	if idField != "" {
+		return decodeMappedEvents(data, strict, idField)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if strict {
+		decoder.DisallowUnknownFields()
+	}
+
+	var events []Event
+	if isJSONObject(data) {
+		var event Event
+		err = decoder.Decode(&event)
+		events = []Event{event}
+	} else {
+		err = decoder.Decode(&events)
+	}
+	if err == nil {
+		err = trailingData(decoder, data)
+	}
+	if err != nil {
+		if _, ok := unknownFieldName(err); ok {
+			return nil, &UnknownFieldsError{Fields: unknownEventFields(data)}
+		}
+		var idErr *numericIDError
+		if errors.As(err, &idErr) {
+			if events, ok := decodeEachEvent(data, strict); ok {
+				return events, nil
+			}
+		}
+		return nil, locateJSONError(err, data)
+	}
+
+	return events, nil
+}
+
+// decodeEachEvent decodes the events of a body one by one, after decoding it as a
+// whole failed on a NumericID value. Events with such a value are returned with
+// only their ID and the reason they are invalid, so the others are still produced.
+// It reports false when an event fails for any other reason, leaving the body's
+// own error to be returned.
+func decodeEachEvent(data []byte, strict bool) ([]Event, bool) {
+	if !json.Valid(data) {
+		return nil, false
+	}
+	var rawEvents []json.RawMessage
+	if isJSONObject(data) {
+		rawEvents = []json.RawMessage{data}
+	} else if err := json.Unmarshal(data, &rawEvents); err != nil {
+		return nil, false
+	}
+
+	events := make([]Event, 0, len(rawEvents))
+	for _, rawEvent := range rawEvents {
+		decoder := json.NewDecoder(bytes.NewReader(rawEvent))
+		if strict {
+			decoder.DisallowUnknownFields()
+		}
+		var event Event
+		if err := decoder.Decode(&event); err != nil {
+			reason := invalidNumericID(err, rawEvent)
+			if reason == nil {
+				return nil, false
+			}
+			event = invalidEvent(rawEvent, reason)
+		}
+		events = append(events, event)
+	}
+	return events, true
+}
+
+// invalidEvent is the event reported for rawEvent, which failed to decode for reason
+func invalidEvent(rawEvent []byte, reason error) Event {
+	var id struct {
+		ID string `json:"id"`
+	}
+	_ = json.Unmarshal(rawEvent, &id)
+	return Event{ID: id.ID, invalid: reason}
+}
+
+// decodeMappedEvents is decodeEvents for a configured idField
+func decodeMappedEvents(data []byte, strict bool, idField string) ([]Event, error) {
+	var rawEvents []map[string]json.RawMessage
+	if isJSONObject(data) {
+		var rawEvent map[string]json.RawMessage
+		if err := json.Unmarshal(data, &rawEvent); err != nil {
+			return nil, locateJSONError(err, data)
+		}
+		rawEvents = append(rawEvents, rawEvent)
+	} else if err := json.Unmarshal(data, &rawEvents); err != nil {
+		return nil, locateJSONError(err, data)
+	}
+
+	events := make([]Event, 0, len(rawEvents))
+	unknown := []string{}
+	seen := make(map[string]bool)
+	for _, rawEvent := range rawEvents {
+		event, err := eventFromMap(rawEvent, strict, idField)
+		var unknownErr *UnknownFieldsError
+		if errors.As(err, &unknownErr) {
+			for _, field := range unknownErr.Fields {
+				if !seen[field] {
+					seen[field] = true
+					unknown = append(unknown, field)
+				}
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, &UnknownFieldsError{Fields: unknown}
+	}
+
+	return events, nil
+}
+
+// decodeEvent decodes the next event of an NDJSON stream. Each event is read whole
+// first, so one with a non-integer ID is reported invalid and the stream goes on.
+func decodeEvent(decoder *json.Decoder, strict bool, idField string) (Event, error) {
+	var event Event
+	if idField == "" {
+		var rawEvent json.RawMessage
+		if err := decoder.Decode(&rawEvent); err != nil {
+			return event, err
+		}
+		eventDecoder := json.NewDecoder(bytes.NewReader(rawEvent))
+		if strict {
+			eventDecoder.DisallowUnknownFields()
+		}
+		err := eventDecoder.Decode(&event)
+		if reason := invalidNumericID(err, rawEvent); reason != nil {
+			return invalidEvent(rawEvent, reason), nil
+		}
+		return event, err
+	}
+
+	var rawEvent map[string]json.RawMessage
+	if err := decoder.Decode(&rawEvent); err != nil {
+		return event, err
+	}
+	return eventFromMap(rawEvent, strict, idField)
+}
+
+// eventFromMap builds an event from its JSON fields, taking the ID from idField.
+// A missing or null idField leaves the ID empty, so the event is reported invalid.
+// Like encoding/json, field names match case-insensitively.
+func eventFromMap(rawEvent map[string]json.RawMessage, strict bool, idField string) (Event, error) {
+	var id string
+	for key, value := range rawEvent {
+		if !strings.EqualFold(key, idField) {
+			continue
+		}
+		delete(rawEvent, key)
+		if err := json.Unmarshal(value, &id); err != nil {
+			return Event{}, fmt.Errorf("%s must be a string: %w", idField, err)
+		}
+	}
+
+	if strict {
+		unknown := []string{}
+		for key := range rawEvent {
+			if !eventFields[strings.ToLower(key)] {
+				unknown = append(unknown, key)
+			}
+		}
+		if len(unknown) > 0 {
+			sort.Strings(unknown)
+			return Event{}, &UnknownFieldsError{Fields: unknown}
+		}
+	}
+
+	data, err := json.Marshal(rawEvent)
+	if err != nil {
+		return Event{}, err
+	}
+	var event Event
+	if err := json.Unmarshal(data, &event); err != nil {
+		if reason := invalidNumericID(err, data); reason != nil {
+			return Event{ID: id, invalid: reason}, nil
+		}
+		return Event{}, err
+	}
+	event.ID = id
+	return event, nil
+}
+
+// trailingData fails a body with more after its event or array, such as an object
+// followed by an array, which the decoder would otherwise ignore. json.Unmarshal
+// reports it as a syntax error at the offset of the extra value.
+func trailingData(decoder *json.Decoder, data []byte) error {
+	if len(bytes.TrimSpace(data[decoder.InputOffset():])) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, new(json.RawMessage))
+}
+
+// isJSONObject reports whether the first non-whitespace byte of data opens an object
+func isJSONObject(data []byte) bool {
+	trimmed := bytes.TrimLeft(data, " \t\r\n")
+	return len(trimmed) > 0 && trimmed[0] == '{'
+}
+
+// unknownEventFields lists the distinct keys across all events that are not Event fields
+func unknownEventFields(data []byte) []string {
+	var rawEvents []map[string]json.RawMessage
+	if isJSONObject(data) {
+		var rawEvent map[string]json.RawMessage
+		if err := json.Unmarshal(data, &rawEvent); err != nil {
+			return nil
+		}
+		rawEvents = append(rawEvents, rawEvent)
+	} else if err := json.Unmarshal(data, &rawEvents); err != nil {
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	fields := []string{}
+	for _, rawEvent := range rawEvents {
+		for key := range rawEvent {
+			if !eventFields[strings.ToLower(key)] && !seen[key] {
+				seen[key] = true
+				fields = append(fields, key)
+			}
+		}
+	}
+	sort.Strings(fields)
+
+	return fields
+}
+
+// maxBodyBytes limits the request body size before any JSON decoding allocates.
+// Requests declaring a larger Content-Length are rejected immediately; chunked
+// or misreported bodies are cut off by http.MaxBytesReader while reading.
+func maxBodyBytes(limit int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.ContentLength > limit {
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{
+				"error": fmt.Sprintf("Request body exceeds %d bytes", limit),
+			})
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+		c.Next()
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestResponseStatus(t *testing.T) {
+	queueFull := fmt.Errorf("topic orders_checkout_created: %w", errQueueFull)
+	outOfRange := fmt.Errorf("%w: topic orders_checkout_created has 3 partitions, got 5", errPartitionOutOfRange)
+
+	tests := []struct {
+		name     string
+		outcomes []EventOutcome
+		want     int
+	}{
+		{
+			name:     "all produced",
+			outcomes: []EventOutcome{outcomeFor(Event{ID: "1"}, nil)},
+			want:     http.StatusOK,
+		},
+		{
+			name:     "kafka write error on every event",
+			outcomes: []EventOutcome{outcomeFor(Event{ID: "1"}, errors.New("kafka: leader not available")), outcomeFor(Event{ID: "2"}, errors.New("kafka: leader not available"))},
+			want:     http.StatusMultiStatus,
+		},
+		{
+			name:     "some produced, some rate limited",
+			outcomes: []EventOutcome{outcomeFor(Event{ID: "1"}, nil), outcomeFor(Event{ID: "2"}, errThrottled)},
+			want:     http.StatusMultiStatus,
+		},
+		{
+			name:     "queue full",
+			outcomes: []EventOutcome{outcomeFor(Event{ID: "1"}, queueFull), outcomeFor(Event{ID: "2"}, queueFull)},
+			want:     http.StatusServiceUnavailable,
+		},
+		{
+			name:     "queue full after some were produced",
+			outcomes: []EventOutcome{outcomeFor(Event{ID: "1"}, nil), outcomeFor(Event{ID: "2"}, queueFull)},
+			want:     http.StatusMultiStatus,
+		},
+		{
+			name:     "partition out of range",
+			outcomes: []EventOutcome{outcomeFor(Event{ID: "1"}, outOfRange)},
+			want:     http.StatusBadRequest,
+		},
+		{
+			name:     "partition out of range for one of the topics",
+			outcomes: []EventOutcome{outcomeFor(Event{ID: "1"}, nil), outcomeFor(Event{ID: "2"}, outOfRange)},
+			want:     http.StatusMultiStatus,
+		},
+		{
+			name:     "in-flight bytes limit",
+			outcomes: []EventOutcome{outcomeFor(Event{ID: "1"}, errQueueBytesFull)},
+			want:     http.StatusTooManyRequests,
+		},
+	}
+
+	h := &EventsHandler{partialStatus: true}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := newEventResponse()
+			for _, outcome := range tt.outcomes {
+				response.record(outcome)
+			}
+			if got := h.responseStatus(response); got != tt.want {
+				t.Errorf("responseStatus() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
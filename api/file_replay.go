@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// FileReplayResult reports the outcome of replaying an uploaded NDJSON file
+type FileReplayResult struct {
+	Replayed    int    `json:"replayed"`
+	Invalid     int    `json:"invalid"`
+	Failed      int    `json:"failed"`
+	RateLimited int    `json:"rateLimited"`
+	Buffered    int    `json:"buffered,omitempty"`
+	Spooled     int    `json:"spooled,omitempty"`
+	Error       string `json:"error,omitempty"`
+	Detail      string `json:"detail,omitempty"`
+	Event       int    `json:"event,omitempty"` // 1-based position of the event that failed to decode
+}
+
+// ReplayFile produces the NDJSON events of the multipart "file" upload through the
+// normal validation and routing path, optionally throttled to ?rate= events per
+// second. Events are produced directly, even when ACCUMULATE_EVENTS is enabled.
+func (h *EventsHandler) ReplayFile(c *gin.Context) {
+	var rate float64 // default value: unthrottled
+	if v := c.Query("rate"); v != "" {
+		var err error
+		rate, err = strconv.ParseFloat(v, 64)
+		if err != nil || rate <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "Invalid rate, expected a positive number of events per second",
+			})
+			return
+		}
+	}
+
+	header, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Expected an NDJSON file in the multipart field \"file\"",
+		})
+		return
+	}
+	file, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer file.Close()
+
+	sendOpts := SendOptions{BatchID: newBatchID()}
+	c.Header("X-Batch-ID", sendOpts.BatchID)
+	c.Set(batchIDKey, sendOpts.BatchID)
+
+	// With a rate the chunks are kept small enough to be paced smoothly
+	chunkSize := ndjsonChunkSize
+	if rate > 0 && rate < float64(chunkSize) {
+		chunkSize = int(math.Ceil(rate))
+	}
+
+	var result FileReplayResult
+	start := time.Now()
+	submitted := 0
+	produce := func(chunk []Event) {
+		if len(chunk) == 0 {
+			return
+		}
+		if rate > 0 {
+			// Wait until the events produced so far fit within the rate
+			if wait := time.Until(start.Add(time.Duration(float64(submitted) / rate * float64(time.Second)))); wait > 0 {
+				time.Sleep(wait)
+			}
+			submitted += len(chunk)
+		}
+		h.replayChunk(chunk, sendOpts, &result)
+	}
+
+	decoder := json.NewDecoder(file)
+	chunk := make([]Event, 0, chunkSize)
+	for decoded := 0; ; decoded++ {
+		event, err := decodeEvent(decoder, h.strictJSON, h.idField)
+		if err == io.EOF && decoded == 0 {
+			status, body := decodeErrorResponse(errEmptyBody, h.verboseErrors)
+			c.JSON(status, body)
+			return
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// Events decoded before the broken line are still produced and counted
+			produce(chunk)
+
+			status, body := decodeErrorResponse(err, h.verboseErrors)
+			result.Error = fmt.Sprint(body["error"])
+			result.Detail, _ = body["detail"].(string)
+			result.Event = decoded + 1
+			c.JSON(status, result)
+			return
+		}
+
+		chunk = append(chunk, event)
+		if len(chunk) == chunkSize {
+			produce(chunk)
+			chunk = chunk[:0]
+		}
+	}
+	produce(chunk)
+
+	log.Printf("Replayed file %s: %d produced, %d invalid, %d failed, %d rate limited",
+		header.Filename, result.Replayed, result.Invalid, result.Failed, result.RateLimited)
+	c.JSON(http.StatusOK, result)
+}
+
+// replayChunk validates and produces a chunk of replayed events, adding the outcomes to result
+func (h *EventsHandler) replayChunk(events []Event, sendOpts SendOptions, result *FileReplayResult) {
+	validEvents := h.prepare(events, sendOpts, func(outcome EventOutcome) {
+		if outcome.Status == OutcomeRateLimited {
+			result.RateLimited++
+		} else {
+			result.Invalid++
+		}
+	})
+	if len(validEvents) == 0 {
+		return
+	}
+
+	for _, err := range produceEvents(h.producer, h.breaker, validEvents, sendOpts) {
+		switch {
+		case errors.Is(err, errBuffered):
+			result.Buffered++
+		case errors.Is(err, errSpooled):
+			result.Spooled++
+		case err != nil:
+			result.Failed++
+		default:
+			result.Replayed++
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// flushWaitTimeout bounds how long a FLUSH_ON_BATCH_COMPLETE request waits for its batches
+const flushWaitTimeout = 30 * time.Second
+
+// errNotFlushed fails events whose batch did not complete within flushWaitTimeout.
+// They are still in the writer and may be delivered later.
+var errNotFlushed = errors.New("batch did not complete in time, the event may still be delivered")
+
+// flushTracker follows the async messages of one request from the write until the
+// writer's Completion callback reports their batch, so the request can be answered
+// once its messages left the process without using a sync writer.
+type flushTracker struct {
+	mu      sync.Mutex
+	pending map[string]int   // messages not completed yet, by event ID
+	failed  map[string]error // delivery failures, by event ID
+	changed chan struct{}    // signalled whenever a message completes
+}
+
+func newFlushTracker() *flushTracker {
+	return &flushTracker{
+		pending: make(map[string]int),
+		failed:  make(map[string]error),
+		changed: make(chan struct{}, 1),
+	}
+}
+
+// add starts tracking the messages of ids, before they are written
+func (t *flushTracker) add(ids []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, id := range ids {
+		t.pending[id]++
+	}
+}
+
+// complete records the outcome of one message's batch
+func (t *flushTracker) complete(id string, err error) {
+	t.mu.Lock()
+	if t.pending[id]--; t.pending[id] <= 0 {
+		delete(t.pending, id)
+	}
+	if err != nil {
+		t.failed[id] = fmt.Errorf("delivery failed: %w", err)
+	}
+	t.mu.Unlock()
+
+	select {
+	case t.changed <- struct{}{}:
+	default:
+	}
+}
+
+// wait blocks until every tracked message completed and returns the delivery
+// failures by event ID. Messages still pending when ctx ends fail with errNotFlushed.
+func (t *flushTracker) wait(ctx context.Context) map[string]error {
+	for {
+		t.mu.Lock()
+		if len(t.pending) == 0 {
+			defer t.mu.Unlock()
+			return t.failed
+		}
+		t.mu.Unlock()
+
+		select {
+		case <-t.changed:
+		case <-ctx.Done():
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			for id := range t.pending {
+				t.failed[id] = errNotFlushed
+			}
+			return t.failed
+		}
+	}
+}
+
+// completeFlushes reports a completed batch to the trackers of its messages
+func completeFlushes(messages []kafka.Message, err error) {
+	for _, msg := range messages {
+		if data, ok := msg.WriterData.(*messageData); ok && data.flush != nil {
+			data.flush.complete(data.eventID, err)
+		}
+	}
+}
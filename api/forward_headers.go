@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// ParseForwardHeaders parses the comma-separated FORWARD_HEADERS list of HTTP
+// header names. Names keep the spelling given, which becomes the Kafka header
+// key; a name listed twice, in any case, is forwarded once.
+func ParseForwardHeaders(list string) ([]string, error) {
+	var names []string
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[http.CanonicalHeaderKey(name)] {
+			continue
+		}
+		if strings.ContainsAny(name, " \t:()<>@;\\\"/[]?={}") {
+			return nil, fmt.Errorf("invalid header name %q", name)
+		}
+		seen[http.CanonicalHeaderKey(name)] = true
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// forwardedHeaders returns the headers of r listed in names as Kafka headers.
+// Headers the request does not carry are skipped; a header sent more than once
+// is forwarded with its values joined by commas, as HTTP would combine them.
+func forwardedHeaders(r *http.Request, names []string) []kafka.Header {
+	var headers []kafka.Header
+	for _, name := range names {
+		values := r.Header.Values(name)
+		if len(values) == 0 {
+			continue
+		}
+		headers = append(headers, kafka.Header{Key: name, Value: []byte(strings.Join(values, ", "))})
+	}
+	return headers
+}
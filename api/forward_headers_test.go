@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseForwardHeaders(t *testing.T) {
+	names, err := ParseForwardHeaders("X-Tenant-ID, x-tenant-id ,X-Source-App")
+	if err != nil {
+		t.Fatalf("ParseForwardHeaders() = %v", err)
+	}
+	if got, want := strings.Join(names, ","), "X-Tenant-ID,X-Source-App"; got != want {
+		t.Errorf("ParseForwardHeaders() = %v, want %s", names, want)
+	}
+	if _, err := ParseForwardHeaders("X Tenant"); err == nil {
+		t.Error("ParseForwardHeaders() accepted a header name with a space")
+	}
+}
+
+func TestForwardHeadersCopiesListedHeadersOnly(t *testing.T) {
+	names, err := ParseForwardHeaders("X-Tenant-ID,X-Source-App,X-Missing")
+	if err != nil {
+		t.Fatalf("ParseForwardHeaders() = %v", err)
+	}
+	recorder := newMessageRecorder()
+	kp := newTestProducer(ProducerConfig{NewWriter: recorder.NewWriter})
+	defer kp.Close()
+	h := &EventsHandler{
+		producer:       kp,
+		breaker:        NewCircuitBreaker(5, time.Second),
+		stats:          NewServerStats(),
+		forwardHeaders: names,
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/events", h.Handle)
+
+	body := `[{"id":"1","domain":"orders","subdomain":"checkout","code":"created","version":"1"},
+		{"id":"2","domain":"orders","subdomain":"checkout","code":"created","version":"1"}]`
+	req := httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-tenant-id", "tenant-1")
+	req.Header.Add("X-Source-App", "checkout")
+	req.Header.Add("X-Source-App", "mobile")
+	req.Header.Set("X-Unlisted", "secret")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /events = %d %s, want 200", w.Code, w.Body)
+	}
+	messages := recorder.Messages("orders_checkout_created")
+	if len(messages) != 2 {
+		t.Fatalf("messages written = %d, want 2", len(messages))
+	}
+	for _, msg := range messages {
+		headers := make(map[string]string)
+		for _, header := range msg.Headers {
+			headers[header.Key] = string(header.Value)
+		}
+		if got := headers["X-Tenant-ID"]; got != "tenant-1" {
+			t.Errorf("X-Tenant-ID header = %q, want %q", got, "tenant-1")
+		}
+		if got := headers["X-Source-App"]; got != "checkout, mobile" {
+			t.Errorf("X-Source-App header = %q, want %q", got, "checkout, mobile")
+		}
+		for _, name := range []string{"X-Unlisted", "X-Missing"} {
+			if _, ok := headers[name]; ok {
+				t.Errorf("header %s was forwarded", name)
+			}
+		}
+	}
+}
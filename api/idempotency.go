@@ -0,0 +1,96 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// IdempotencyCache remembers the responses of processed Idempotency-Key values for a TTL,
+// so a retried POST /events is answered from the cache instead of producing again.
+// It holds at most maxSize keys and evicts the least recently stored one when full.
+type IdempotencyCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	maxSize int
+	entries map[string]*list.Element
+	order   *list.List // front is the most recently stored entry
+}
+
+type idempotencyEntry struct {
+	key       string
+	response  *EventResponse // nil while the first request is still in flight
+	expiresAt time.Time
+}
+
+// NewIdempotencyCache creates a cache holding up to maxSize keys for ttl each
+func NewIdempotencyCache(maxSize int, ttl time.Duration) *IdempotencyCache {
+	return &IdempotencyCache{
+		ttl:     ttl,
+		maxSize: maxSize,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// Begin claims the key for a new request. It returns the cached response if the key
+// was already processed, or inFlight if another request with the key is still running.
+// When neither is returned the caller owns the key and must call Complete or Abort.
+func (c *IdempotencyCache) Begin(key string) (cached *EventResponse, inFlight bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*idempotencyEntry)
+		if now.Before(entry.expiresAt) {
+			if entry.response == nil {
+				return nil, true
+			}
+			return entry.response, false
+		}
+		c.remove(elem)
+	}
+
+	c.store(&idempotencyEntry{key: key, expiresAt: now.Add(c.ttl)})
+	return nil, false
+}
+
+// Complete stores the response for a key claimed with Begin
+func (c *IdempotencyCache) Complete(key string, response EventResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+	c.store(&idempotencyEntry{key: key, response: &response, expiresAt: time.Now().Add(c.ttl)})
+}
+
+// Abort releases a key claimed with Begin without caching anything, so the client can retry
+func (c *IdempotencyCache) Abort(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+}
+
+// store inserts an entry, evicting expired and then oldest entries beyond maxSize (c.mu must be held)
+func (c *IdempotencyCache) store(entry *idempotencyEntry) {
+	c.entries[entry.key] = c.order.PushFront(entry)
+
+	now := time.Now()
+	for oldest := c.order.Back(); oldest != nil; oldest = c.order.Back() {
+		if c.order.Len() <= c.maxSize && now.Before(oldest.Value.(*idempotencyEntry).expiresAt) {
+			break
+		}
+		c.remove(oldest)
+	}
+}
+
+func (c *IdempotencyCache) remove(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.entries, elem.Value.(*idempotencyEntry).key)
+}
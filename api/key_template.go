@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// keyFields are the event fields a KAFKA_KEY_TEMPLATE placeholder can refer to,
+// by their JSON name
+var keyFields = map[string]func(Event) string{
+	"id":             func(e Event) string { return e.ID },
+	"domain":         func(e Event) string { return e.Domain },
+	"subdomain":      func(e Event) string { return e.Subdomain },
+	"code":           func(e Event) string { return e.Code },
+	"version":        func(e Event) string { return e.Version },
+	"eventtime":      func(e Event) string { return e.EventTime },
+	"eventtimestamp": func(e Event) string { return strconv.FormatInt(e.EventTimestamp, 10) },
+	"branchid":       func(e Event) string { return e.BranchID.String() },
+	"channelid":      func(e Event) string { return e.ChannelID.String() },
+	"customerid":     func(e Event) string { return e.CustomerID.String() },
+	"userid":         func(e Event) string { return e.UserID.String() },
+}
+
+// KeyTemplate builds message keys from event fields, using the same {field}
+// placeholders as topic names, e.g. "{customerid}:{branchid}"
+type KeyTemplate struct {
+	literals []string             // text before each field, plus the trailing text
+	fields   []func(Event) string // len(literals)-1 placeholders
+}
+
+// ParseKeyTemplate parses a key template, rejecting unknown or unclosed placeholders
+func ParseKeyTemplate(template string) (*KeyTemplate, error) {
+	t := &KeyTemplate{}
+	rest := template
+	for {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			break
+		}
+		end := strings.IndexByte(rest[open:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("unclosed placeholder in %q", template)
+		}
+		name := rest[open+1 : open+end]
+		field, ok := keyFields[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown placeholder {%s}", name)
+		}
+		t.literals = append(t.literals, rest[:open])
+		t.fields = append(t.fields, field)
+		rest = rest[open+end+1:]
+	}
+	t.literals = append(t.literals, rest)
+	return t, nil
+}
+
+// Key renders the template for an event
+func (t *KeyTemplate) Key(event Event) []byte {
+	var b strings.Builder
+	for i, field := range t.fields {
+		b.WriteString(t.literals[i])
+		b.WriteString(field(event))
+	}
+	b.WriteString(t.literals[len(t.literals)-1])
+	return []byte(b.String())
+}
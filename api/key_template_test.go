@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestKeyTemplateKey(t *testing.T) {
+	template, err := ParseKeyTemplate("{customerid}:{branchid}")
+	if err != nil {
+		t.Fatalf("ParseKeyTemplate() = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		a, b Event
+		same bool
+	}{
+		{
+			name: "same customer and branch",
+			a:    Event{ID: "1", Code: "created", CustomerID: 42, BranchID: 7},
+			b:    Event{ID: "2", Code: "cancelled", CustomerID: 42, BranchID: 7},
+			same: true,
+		},
+		{
+			name: "different customers",
+			a:    Event{ID: "1", CustomerID: 42, BranchID: 7},
+			b:    Event{ID: "1", CustomerID: 43, BranchID: 7},
+			same: false,
+		},
+		{
+			name: "different branches",
+			a:    Event{ID: "1", CustomerID: 42, BranchID: 7},
+			b:    Event{ID: "1", CustomerID: 42, BranchID: 8},
+			same: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := template.Key(tt.a), template.Key(tt.b)
+			if bytes.Equal(a, b) != tt.same {
+				t.Errorf("Key() = %q and %q, want same: %v", a, b, tt.same)
+			}
+		})
+	}
+
+	if got, want := string(template.Key(Event{CustomerID: 42, BranchID: 7})), "42:7"; got != want {
+		t.Errorf("Key() = %q, want %q", got, want)
+	}
+}
+
+func TestParseKeyTemplateRejectsInvalidPlaceholders(t *testing.T) {
+	for _, template := range []string{"{customerid", "{unknown}"} {
+		if _, err := ParseKeyTemplate(template); err == nil {
+			t.Errorf("ParseKeyTemplate(%q) succeeded, want an error", template)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// LoadShedder rejects /events requests for a cooldown once threshold Kafka
+// writes timed out within window. Unlike the circuit breaker, which opens on
+// consecutive failures, it reacts to a slow broker that still answers now and
+// then, where every request would otherwise wait for the full write timeout.
+type LoadShedder struct {
+	mu        sync.Mutex
+	threshold int
+	window    time.Duration
+	cooldown  time.Duration
+	lite      bool
+
+	timeouts   []time.Time // write timeouts within the window, oldest first
+	shedUntil  time.Time
+	shedPeriod int // incremented per shedding period, so a stale end timer is ignored
+}
+
+// NewLoadShedder creates a shedder that is not shedding
+func NewLoadShedder(threshold int, window, cooldown time.Duration, lite bool) *LoadShedder {
+	return &LoadShedder{
+		threshold: threshold,
+		window:    window,
+		cooldown:  cooldown,
+		lite:      lite,
+	}
+}
+
+// RecordTimeout counts a timed out write and starts shedding once threshold
+// timeouts fall within the window
+func (s *LoadShedder) RecordTimeout() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	cutoff := now.Add(-s.window)
+	kept := s.timeouts[:0]
+	for _, t := range s.timeouts {
+		if t.After(cutoff) {
+			kept = append(kept, t)
+		}
+	}
+	s.timeouts = append(kept, now)
+	if len(s.timeouts) < s.threshold || now.Before(s.shedUntil) {
+		return
+	}
+
+	s.timeouts = s.timeouts[:0]
+	s.shedUntil = now.Add(s.cooldown)
+	s.shedPeriod++
+	if s.lite {
+		return
+	}
+	loadShedding.Set(1)
+	period := s.shedPeriod
+	time.AfterFunc(s.cooldown, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.shedPeriod == period {
+			loadShedding.Set(0)
+		}
+	})
+}
+
+// RetryAfter returns how long requests are still shed for, 0 when they are not
+func (s *LoadShedder) RetryAfter() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if remaining := time.Until(s.shedUntil); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+// Shed counts a rejected request
+func (s *LoadShedder) Shed() {
+	if !s.lite {
+		loadShedRequestsTotal.Inc()
+	}
+}
+
+// isTimeoutError reports whether a write failed because Kafka did not answer in
+// time, either locally (context deadline, network timeout) or on the broker
+func isTimeoutError(err error) bool {
+	var writeErrs kafka.WriteErrors
+	if errors.As(err, &writeErrs) {
+		for _, e := range writeErrs {
+			if e != nil && isTimeoutError(e) {
+				return true
+			}
+		}
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var timeoutErr interface{ Timeout() bool }
+	return errors.As(err, &timeoutErr) && timeoutErr.Timeout()
+}
@@ -3,202 +3,2094 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/segmentio/kafka-go"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/time/rate"
 )
 
 // Event represents the incoming event structure
 type Event struct {
-	EventTimestamp int64  `json:"eventtimestamp"`
-	EventTime      string `json:"eventtime"`
-	ID             string `json:"id"`
-	Domain         string `json:"domain"`
-	Subdomain      string `json:"subdomain"`
-	Code           string `json:"code"`
-	Version        string `json:"version"`
-	BranchID       int    `json:"branchid"`
-	ChannelID      int    `json:"channelid"`
-	CustomerID     int    `json:"customerid"`
-	UserID         int    `json:"userid"`
-	Payload        string `json:"payload"`
+	EventTimestamp int64     `json:"eventtimestamp"`
+	EventTime      string    `json:"eventtime"`
+	ID             string    `json:"id"`
+	Domain         string    `json:"domain"`
+	Subdomain      string    `json:"subdomain"`
+	Code           string    `json:"code"`
+	Version        string    `json:"version"`
+	BranchID       NumericID `json:"branchid"`
+	ChannelID      NumericID `json:"channelid"`
+	CustomerID     NumericID `json:"customerid"`
+	UserID         NumericID `json:"userid"`
+	Payload        string    `json:"payload"`
+	ReceivedAt     string    `json:"receivedat,omitempty"`     // set by the received_at enricher
+	SourceInstance string    `json:"sourceinstance,omitempty"` // set by the source_instance enricher
+
+	// Topic, when set, replaces the topic computed from domain, subdomain and code
+	Topic string `json:"topic,omitempty"`
+
+	batchID string // ID of the /events request the event came in, sent as the batch-id header
+
+	invalid error // set when a field could not be decoded, so checkEvent rejects the event
+
+	forwarded []kafka.Header // request headers listed in FORWARD_HEADERS
+}
+
+// Message value modes selected with VALUE_MODE
+const (
+	ValueModeFull    = "full"    // the whole Event as JSON
+	ValueModePayload = "payload" // only the Payload string, metadata goes into headers
+)
+
+// Partition keys selected with PARTITION_KEY
+const (
+	PartitionKeyID   = "id"   // the event ID, or KAFKA_KEY_TEMPLATE when set
+	PartitionKeyNone = "none" // no key, the balancer spreads messages
+)
+
+// Message time sources selected with MESSAGE_TIME_SOURCE
+const (
+	TimeSourceIngest = "ingest" // the time the message is built
+	TimeSourceEvent  = "event"  // the event's own EventTimestamp or EventTime
+)
+
+// defaultEventVersion is applied by enrichment to events sent without a version
+const defaultEventVersion = "1.0"
+
+// EventResponse represents the response structure
+type EventResponse struct {
+	SuccessEventIds []string       `json:"successEventIds"`
+	InvalidEventIds []string       `json:"invalidEventIds"`
+	FailedEventIds  []string       `json:"failedEventIds"`
+	Failures        []EventFailure `json:"failures"`
+
+	// Events not produced because of a limit the client should back off from: their
+	// domain's DOMAIN_RATE_LIMITS entry, the global EVENTS_PER_SECOND_LIMIT, the
+	// ASYNC_MAX_BYTES in-flight bytes or the ACCUMULATE_MAX_PENDING buffer
+	RateLimitedEventIds []string `json:"rateLimitedEventIds"`
+
+	// Set instead of the success and failed lists when events are accumulated across requests
+	Ticket           string   `json:"ticket,omitempty"`
+	AcceptedEventIds []string `json:"acceptedEventIds,omitempty"`
+	// Events Kafka could not take that were stored in the DISK_BUFFER_DIR buffer instead
+	BufferedEventIds []string `json:"bufferedEventIds,omitempty"`
+	// Events Kafka could not take that are held in the SPOOL_MAX_EVENTS spool for retry
+	SpooledEventIds []string `json:"spooledEventIds,omitempty"`
+	// Events dropped by the accumulator because their ID was produced recently (DEDUP_CACHE_SIZE)
+	DuplicateEventIds []string `json:"duplicateEventIds,omitempty"`
+	// Topic each valid event was routed to, keyed by event ID; only filled when the
+	// request asks for it with ?verbose=true or X-Return-Topics
+	Topics map[string]string `json:"topics,omitempty"`
+	// Topic and key of each produced event; only filled for ?verbose=true
+	Routed []RoutedEvent `json:"routed,omitempty"`
+
+	// queueFull is set when an event was rejected by ASYNC_QUEUE_POLICY=error
+	queueFull bool
+	// partitionOutOfRange is set when X-Kafka-Partition did not exist for an event's topic
+	partitionOutOfRange bool
+}
+
+// RoutedEvent shows how a produced event was routed. Key is omitted for keyless messages.
+type RoutedEvent struct {
+	ID    string `json:"id"`
+	Topic string `json:"topic"`
+	Key   string `json:"key,omitempty"`
+}
+
+// EventFailure explains why an invalid or failed event was not produced
+type EventFailure struct {
+	ID     string `json:"id"`
+	Reason string `json:"reason"`
+}
+
+// ProducerConfig holds the settings used to build a KafkaProducer
+type ProducerConfig struct {
+	Brokers     []string
+	NewBalancer func() kafka.Balancer
+
+	// MessageTimeSource is TimeSourceIngest or TimeSourceEvent
+	MessageTimeSource string
+
+	// ValueMode selects what is written into the message value: ValueModeFull or ValueModePayload
+	ValueMode string
+
+	// TopicTemplate names topics from event fields; nil uses {domain}_{subdomain}_{code}
+	TopicTemplate *TopicTemplate
+
+	// FixedTopic, when set, receives every event regardless of its fields
+	FixedTopic string
+
+	// KeyTemplate builds message keys from event fields; nil keys messages by event ID
+	KeyTemplate *KeyTemplate
+
+	// Keyless leaves message keys nil so the balancer alone spreads messages
+	Keyless bool
+
+	// StripFields are event JSON field names left out of produced messages
+	StripFields map[string]bool
+
+	// ProduceConcurrency bounds how many topics SendEvents writes to in parallel
+	ProduceConcurrency int
+
+	// RequiredAcks is the default ack level, overridable per request via SendOptions
+	RequiredAcks kafka.RequiredAcks
+
+	// WriteAttempts is how often a writer tries a batch before it fails, and
+	// WriteTimeout how long one attempt may take; 0 uses kafka-go's defaults
+	WriteAttempts int
+	WriteTimeout  time.Duration
+
+	// SyncWrites writes /events requests with the sync writers by default, as if
+	// they sent X-Sync: true; X-Sync: false still picks the async writers
+	SyncWrites bool
+
+	// BatchTimeout is the longest an async message waits in a writer's batch
+	// before the batch is sent; 0 uses writerBatchTimeout
+	BatchTimeout time.Duration
+
+	// Compression is the codec every writer compresses batches with
+	Compression kafka.Compression
+
+	// Transport is shared by every writer and the admin client; nil uses kafka.DefaultTransport
+	Transport *kafka.Transport
+
+	// Partitions and replication factor for topics the producer creates itself.
+	// When TopicPartitions is 0 topic creation is left to broker auto-creation.
+	TopicPartitions   int
+	ReplicationFactor int
+
+	// AllowAutoTopicCreation lets writers create missing topics through broker auto-creation.
+	// When false, events for topics that do not exist fail instead.
+	AllowAutoTopicCreation bool
+
+	// AllowedTopics restricts the topics events are produced to; nil allows every topic.
+	// Events for other topics go to DefaultTopic, or are rejected when it is empty.
+	// DefaultTopic also receives events without domain, subdomain or code.
+	AllowedTopics map[string]bool
+	DefaultTopic  string
+
+	// DeadLetterTopic receives messages that failed to be produced; empty disables it
+	DeadLetterTopic string
+
+	// LiteMode skips Prometheus metrics and per-topic counts entirely
+	LiteMode bool
+
+	// NewWriter creates the per-topic writers; nil uses async kafka.Writers
+	NewWriter WriterFactory
+
+	// AuditLog records every acknowledged event; nil unless AUDIT_LOG_FILE is set
+	AuditLog *AuditLog
+
+	// PartitionCount looks up a topic's partitions for X-Kafka-Partition and
+	// PreserveOrder; nil reads the topic's metadata from the cluster
+	PartitionCount PartitionCounter
+
+	// PreserveOrder writes all of a topic's events to one partition chosen by topic
+	// name, in the order they were sent, unless X-Kafka-Partition pins them
+	PreserveOrder bool
+
+	// MaxInFlight bounds the async messages not completed yet across all writers,
+	// with QueuePolicy deciding what happens at the limit. 0 means unlimited.
+	MaxInFlight int
+	QueuePolicy string
+	// MaxInFlightBytes bounds the bytes of those messages; batches that would
+	// exceed it are rejected whatever the QueuePolicy. 0 means unlimited.
+	MaxInFlightBytes int
+
+	// EventsPerSecond caps the events produced per second across all requests,
+	// waiting at most ThrottleMaxWait for the budget. 0 means unlimited.
+	EventsPerSecond float64
+	ThrottleMaxWait time.Duration
+
+	// WriterStatsInterval is how often kafka.Writer stats are exported as metrics.
+	// 0 disables the export; it is also skipped in lite mode.
+	WriterStatsInterval time.Duration
+
+	// Enricher runs on every event before it is marshaled; nil leaves events unchanged
+	Enricher EventEnricher
+}
+
+// KafkaProducer produces events through per-topic writers created by config.NewWriter
+type KafkaProducer struct {
+	config ProducerConfig
+	admin  *kafka.Client
+
+	// Topics known to exist, so CreateTopics is only called once per topic
+	topicsMu      sync.Mutex
+	ensuredTopics map[string]bool
+
+	// Per-topic writers are created on first use and reused across requests.
+	// A topic gets one writer per ack level it is produced with.
+	writersMu sync.Mutex
+	writers   map[writerKey]*topicWriter
+
+	// Most recent async delivery failures reported by the writers' Completion callback
+	deliveryErrors *errorRing
+
+	// deadLetterWriter is nil unless DeadLetterTopic is set. It is kept outside
+	// writers so Completion callbacks can use it while Close holds writersMu.
+	deadLetterWriter *topicWriter
+
+	// inFlight is nil unless MaxInFlight or MaxInFlightBytes is set
+	inFlight *inFlightLimiter
+
+	// throttle is nil unless EventsPerSecond is set
+	throttle *Throttle
+
+	// Stop and done of the writer stats loop; nil when it is not running
+	statsStop chan struct{}
+	statsDone chan struct{}
+
+	// Topics seen by SendEvents since startup with the number of messages produced to each
+	producedMu     sync.Mutex
+	producedCounts map[string]int64
+
+	// Async messages of the topic writers, reported by Shutdown
+	drain drainCounts
+
+	// started is set once a message was delivered or a broker answered Ping
+	started atomic.Bool
+
+	// diskBuffer is nil unless DISK_BUFFER_DIR is set
+	diskBuffer *DiskBuffer
+
+	// spool is nil unless SPOOL_MAX_EVENTS is set
+	spool *Spool
+
+	// shedder is nil unless TIMEOUT_BACKPRESSURE_THRESHOLD is set
+	shedder *LoadShedder
+}
+
+// writerKey identifies a cached writer
+type writerKey struct {
+	topic string
+	acks  kafka.RequiredAcks
+	sync  bool // X-Sync requests have writers of their own
+}
+
+func (k writerKey) String() string {
+	if k.sync {
+		return fmt.Sprintf("%s (acks=%s, sync)", k.topic, k.acks)
+	}
+	return fmt.Sprintf("%s (acks=%s)", k.topic, k.acks)
+}
+
+// SendOptions carries per-request overrides for SendEvents
+type SendOptions struct {
+	// Acks overrides the producer's default RequiredAcks when non-nil
+	Acks *kafka.RequiredAcks
+
+	// Partition pins every message to this partition, bypassing the balancer, when non-nil
+	Partition *int
+
+	// BatchID identifies the /events request the events belong to
+	BatchID string
+
+	// Headers are the request headers listed in FORWARD_HEADERS, added to every message
+	Headers []kafka.Header
+
+	// Sync writes the messages synchronously, so SendEvents returns the broker's
+	// answer for each of them instead of only having enqueued them
+	Sync bool
+
+	// Flush keeps the async writers but makes SendEvents wait until the batches
+	// holding the messages completed, reporting delivery failures per event
+	Flush bool
+}
+
+// TopicCount reports how many messages were produced to a topic since startup
+type TopicCount struct {
+	Topic    string `json:"topic"`
+	Messages int64  `json:"messages"`
+}
+
+// MessageWriter is the part of kafka.Writer the producer depends on, so another
+// implementation (such as the in-memory mockWriter) can stand in for Kafka
+type MessageWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// WriterFactory creates the writer for one topic and ack level. The writer must call
+// completion for every batch once it was delivered or failed. A sync writer's
+// WriteMessages returns only once its messages were delivered or failed.
+type WriterFactory func(topicName string, acks kafka.RequiredAcks, sync bool, completion func(messages []kafka.Message, err error)) MessageWriter
+
+// topicWriter is a cached per-topic writer that tracks how many async messages
+// are still waiting for their Completion callback.
+type topicWriter struct {
+	MessageWriter
+
+	// limiter is shared by all writers; nil when in-flight messages are unlimited
+	limiter *inFlightLimiter
+
+	// drain is the producer's drainCounts; nil for the dead-letter writer
+	drain *drainCounts
+
+	// sync writers report failures from WriteMessages, not only to completion
+	sync bool
+
+	mu      sync.Mutex
+	pending int
+	idle    chan struct{} // closed whenever pending drops to zero
+}
+
+// FlushResult summarises a Flush call
+type FlushResult struct {
+	Flushed int               `json:"flushed"`
+	Errored int               `json:"errored"`
+	Errors  map[string]string `json:"errors,omitempty"`
+}
+
+// balancerFactory returns a constructor for the partition balancer with the given name.
+// Every writer gets its own balancer instance so per-writer state (byte counters,
+// round-robin offsets) is not shared across topics.
+func balancerFactory(name string) (func() kafka.Balancer, error) {
+	switch strings.ToLower(name) {
+	case "", "leastbytes":
+		return func() kafka.Balancer { return &kafka.LeastBytes{} }, nil
+	case "roundrobin":
+		return func() kafka.Balancer { return &kafka.RoundRobin{} }, nil
+	case "hash":
+		// Hash uses the message key (event ID); keyless messages fall back to round-robin
+		return func() kafka.Balancer { return &kafka.Hash{} }, nil
+	case "crc32":
+		// CRC32Balancer matches librdkafka's default partitioner for the message key
+		return func() kafka.Balancer { return &kafka.CRC32Balancer{} }, nil
+	default:
+		return nil, fmt.Errorf("unknown partition balancer %q (expected leastbytes, roundrobin, hash or crc32)", name)
+	}
+}
+
+// NewKafkaProducer creates a new Kafka producer
+func NewKafkaProducer(config ProducerConfig) *KafkaProducer {
+	if config.Transport == nil {
+		config.Transport = kafka.DefaultTransport.(*kafka.Transport)
+	}
+	if config.Enricher == nil {
+		config.Enricher = noopEnricher{}
+	}
+	if config.BatchTimeout <= 0 {
+		config.BatchTimeout = writerBatchTimeout
+	}
+	kp := &KafkaProducer{
+		config: config,
+		admin: &kafka.Client{
+			Addr:      kafka.TCP(config.Brokers...),
+			Timeout:   10 * time.Second,
+			Transport: config.Transport,
+		},
+		ensuredTopics:  make(map[string]bool),
+		producedCounts: make(map[string]int64),
+		writers:        make(map[writerKey]*topicWriter),
+		deliveryErrors: newErrorRing(recentDeliveryErrors),
+	}
+	if kp.config.PartitionCount == nil {
+		kp.config.PartitionCount = kp.partitionCount
+	}
+	if config.MaxInFlight > 0 || config.MaxInFlightBytes > 0 {
+		kp.inFlight = newInFlightLimiter(config.MaxInFlight, config.MaxInFlightBytes, config.QueuePolicy, config.LiteMode)
+	}
+	if config.EventsPerSecond > 0 {
+		kp.throttle = NewThrottle(config.EventsPerSecond, config.ThrottleMaxWait, config.LiteMode)
+	}
+	if config.DeadLetterTopic != "" {
+		kp.deadLetterWriter = kp.newTopicWriter(config.DeadLetterTopic, kafka.RequireAll, false)
+		kp.deadLetterWriter.drain = nil
+	}
+	if config.WriterStatsInterval > 0 && !config.LiteMode {
+		kp.statsStop = make(chan struct{})
+		kp.statsDone = make(chan struct{})
+		go kp.runWriterStats(config.WriterStatsInterval, kp.statsStop, kp.statsDone)
+	}
+
+	return kp
+}
+
+// ensureTopic creates the topic with the configured partitions and replication factor
+// before it is first produced to. Topics that already exist are treated as created,
+// and if creation fails the topic is left to broker auto-creation when that is allowed.
+// Without TopicPartitions and with auto-creation disabled it only checks the topic exists.
+func (kp *KafkaProducer) ensureTopic(ctx context.Context, topicName string) error {
+	if kp.config.TopicPartitions <= 0 && kp.config.AllowAutoTopicCreation {
+		return nil
+	}
+
+	kp.topicsMu.Lock()
+	ensured := kp.ensuredTopics[topicName]
+	kp.topicsMu.Unlock()
+	if ensured {
+		return nil
+	}
+
+	if kp.config.TopicPartitions <= 0 {
+		if err := kp.checkTopicExists(ctx, topicName); err != nil {
+			return err
+		}
+	} else if err := kp.createTopic(ctx, topicName); err != nil {
+		if !kp.config.AllowAutoTopicCreation {
+			return err
+		}
+		log.Printf("%v, falling back to auto topic creation", err)
+	}
+
+	kp.topicsMu.Lock()
+	kp.ensuredTopics[topicName] = true
+	kp.topicsMu.Unlock()
+
+	return nil
+}
+
+// createTopic creates the topic, treating an existing topic as success
+func (kp *KafkaProducer) createTopic(ctx context.Context, topicName string) error {
+	resp, err := kp.admin.CreateTopics(ctx, &kafka.CreateTopicsRequest{
+		Topics: []kafka.TopicConfig{{
+			Topic:             topicName,
+			NumPartitions:     kp.config.TopicPartitions,
+			ReplicationFactor: kp.config.ReplicationFactor,
+		}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create topic %s: %w", topicName, err)
+	}
+	if err := resp.Errors[topicName]; err != nil && !errors.Is(err, kafka.TopicAlreadyExists) {
+		return fmt.Errorf("failed to create topic %s: %w", topicName, err)
+	}
+	return nil
+}
+
+// checkTopicExists fails for topics the cluster does not have
+func (kp *KafkaProducer) checkTopicExists(ctx context.Context, topicName string) error {
+	resp, err := kp.admin.Metadata(ctx, &kafka.MetadataRequest{Topics: []string{topicName}})
+	if err != nil {
+		return fmt.Errorf("failed to look up topic %s: %w", topicName, err)
+	}
+	for _, topic := range resp.Topics {
+		if topic.Name == topicName && topic.Error == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("topic %s does not exist and auto topic creation is disabled", topicName)
+}
+
+// Ping checks that a broker is reachable by requesting its supported API versions
+func (kp *KafkaProducer) Ping(ctx context.Context) error {
+	if _, err := kp.admin.ApiVersions(ctx, &kafka.ApiVersionsRequest{}); err != nil {
+		return fmt.Errorf("kafka is unreachable: %w", err)
+	}
+	kp.started.Store(true)
+	return nil
+}
+
+// Started reports whether the producer has delivered a message or reached a broker since startup
+func (kp *KafkaProducer) Started() bool {
+	return kp.started.Load()
+}
+
+// parseRequiredAcks parses an ack level name: none, one or all
+func parseRequiredAcks(s string) (kafka.RequiredAcks, error) {
+	var acks kafka.RequiredAcks
+	err := acks.UnmarshalText([]byte(strings.ToLower(strings.TrimSpace(s))))
+	return acks, err
+}
+
+// writerFor returns the cached writer for the topic, ack level and mode, creating it on first use
+func (kp *KafkaProducer) writerFor(topicName string, acks kafka.RequiredAcks, sync bool) *topicWriter {
+	kp.writersMu.Lock()
+	defer kp.writersMu.Unlock()
+
+	key := writerKey{topic: topicName, acks: acks, sync: sync}
+	if tw, ok := kp.writers[key]; ok {
+		return tw
+	}
+
+	tw := kp.newTopicWriter(topicName, acks, sync)
+	kp.writers[key] = tw
+
+	return tw
+}
+
+// newTopicWriter creates an async writer for one topic and ack level, or a sync
+// one for X-Sync requests
+func (kp *KafkaProducer) newTopicWriter(topicName string, acks kafka.RequiredAcks, sync bool) *topicWriter {
+	tw := &topicWriter{limiter: kp.inFlight, drain: &kp.drain, sync: sync}
+	completion := func(messages []kafka.Message, err error) {
+		completeFlushes(messages, err)
+		if err != nil {
+			if tw.drain != nil {
+				tw.drain.failed.Add(int64(len(messages)))
+			}
+			if tw.sync {
+				// WriteMessages returns the error too; writeBatch handles it there
+				return
+			}
+			kp.recordDeliveryFailure(topicName, messages, err)
+			kp.deadLetter(topicName, messages, err)
+		} else {
+			if tw.drain != nil {
+				tw.drain.delivered.Add(int64(len(messages)))
+			}
+			kp.started.Store(true)
+			if kp.config.AuditLog != nil {
+				kp.config.AuditLog.Record(topicName, messages)
+			}
+		}
+		if !tw.sync {
+			tw.done(len(messages), batchBytes(messages))
+		}
+	}
+
+	newWriter := kp.config.NewWriter
+	if newWriter == nil {
+		newWriter = kp.newKafkaWriter
+	}
+	tw.MessageWriter = newWriter(topicName, acks, sync, completion)
+
+	return tw
+}
+
+// newKafkaWriter is the default WriterFactory, a kafka.Writer for the topic
+func (kp *KafkaProducer) newKafkaWriter(topicName string, acks kafka.RequiredAcks, sync bool, completion func(messages []kafka.Message, err error)) MessageWriter {
+	return &kafka.Writer{
+		Addr:                   kafka.TCP(kp.config.Brokers...),
+		Topic:                  topicName,
+		Balancer:               partitionBalancer{kp.config.NewBalancer()},
+		BatchSize:              writerBatchSize,
+		BatchBytes:             writerBatchBytes,
+		BatchTimeout:           kp.config.BatchTimeout,
+		MaxAttempts:            kp.config.WriteAttempts,
+		WriteTimeout:           kp.config.WriteTimeout,
+		ReadTimeout:            10 * time.Second, // 10 second read timeout
+		RequiredAcks:           acks,
+		Compression:            kp.config.Compression,
+		Async:                  !sync, // Enable async for better batching
+		AllowAutoTopicCreation: kp.config.AllowAutoTopicCreation,
+		Completion:             completion,
+		Transport:              kp.config.Transport,
+	}
+}
+
+// recordDeliveryFailure surfaces async delivery errors that happen after WriteMessages returned
+func (kp *KafkaProducer) recordDeliveryFailure(topicName string, messages []kafka.Message, err error) {
+	log.Printf("Failed to deliver %d messages to topic %s: %v", len(messages), topicName, err)
+	kp.recordErrors(topicName, "delivery", len(messages))
+
+	now := time.Now()
+	for _, msg := range messages {
+		kp.deliveryErrors.Add(DeliveryError{
+			Time:  now,
+			Topic: topicName,
+			Key:   string(msg.Key),
+			Error: err.Error(),
+			value: msg.Value,
+		})
+	}
+}
+
+// DeliveryErrors returns the most recent async delivery failures, newest first
+func (kp *KafkaProducer) DeliveryErrors() []DeliveryError {
+	return kp.deliveryErrors.List()
+}
+
+// write enqueues messages on the writer and tracks them until their batch completes
+func (tw *topicWriter) write(ctx context.Context, messages ...kafka.Message) error {
+	size := 0
+	if tw.limiter != nil {
+		size = batchBytes(messages)
+		if err := tw.limiter.acquire(ctx, len(messages), size); err != nil {
+			return err
+		}
+	}
+	tw.add(len(messages))
+	err := tw.WriteMessages(ctx, messages...)
+	// In async mode a returned error means nothing was enqueued; a sync write is
+	// complete once WriteMessages returns
+	if err != nil || tw.sync {
+		tw.done(len(messages), size)
+	}
+	return err
+}
+
+func (tw *topicWriter) add(n int) {
+	if tw.drain != nil {
+		tw.drain.pending.Add(int64(n))
+	}
+
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.pending == 0 {
+		tw.idle = make(chan struct{})
+	}
+	tw.pending += n
+}
+
+func (tw *topicWriter) done(n, size int) {
+	if tw.limiter != nil {
+		tw.limiter.release(n, size)
+	}
+	if tw.drain != nil {
+		tw.drain.pending.Add(-int64(n))
+	}
+
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	tw.pending -= n
+	if tw.pending <= 0 {
+		tw.pending = 0
+		if tw.idle != nil {
+			close(tw.idle)
+			tw.idle = nil
+		}
+	}
+}
+
+// pendingCount returns the messages written and not completed yet
+func (tw *topicWriter) pendingCount() int {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	return tw.pending
+}
+
+// wait blocks until every in-flight message has completed or ctx is done
+func (tw *topicWriter) wait(ctx context.Context) error {
+	tw.mu.Lock()
+	idle := tw.idle
+	tw.mu.Unlock()
+
+	if idle == nil {
+		return nil
+	}
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Pending returns the async messages handed to the writers and not completed yet
+func (kp *KafkaProducer) Pending() int64 {
+	return kp.drain.pending.Load()
+}
+
+// Flush waits for all cached writers to drain their in-flight async batches
+func (kp *KafkaProducer) Flush(ctx context.Context) FlushResult {
+	kp.writersMu.Lock()
+	writers := make(map[writerKey]*topicWriter, len(kp.writers))
+	for key, tw := range kp.writers {
+		writers[key] = tw
+	}
+	kp.writersMu.Unlock()
+	if kp.deadLetterWriter != nil {
+		writers[writerKey{topic: kp.config.DeadLetterTopic, acks: kafka.RequireAll}] = kp.deadLetterWriter
+	}
+
+	result := FlushResult{Errors: make(map[string]string)}
+	for key, tw := range writers {
+		if err := tw.wait(ctx); err != nil {
+			result.Errored++
+			result.Errors[key.String()] = err.Error()
+			continue
+		}
+		result.Flushed++
+	}
+
+	return result
+}
+
+// Close closes all cached topic writers, flushing buffered messages
+func (kp *KafkaProducer) Close() error {
+	// Stopped first, since exporting the stats takes writersMu
+	if kp.statsStop != nil {
+		close(kp.statsStop)
+		<-kp.statsDone
+	}
+
+	kp.writersMu.Lock()
+	defer kp.writersMu.Unlock()
+
+	var firstErr error
+	for key, tw := range kp.writers {
+		if err := tw.Close(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("failed to close writer for topic %s: %w", key, err)
+		}
+	}
+
+	// Closed last, since closing the other writers may still dead-letter their failed batches
+	if kp.deadLetterWriter != nil {
+		if err := kp.deadLetterWriter.Close(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("failed to close dead-letter writer: %w", err)
+		}
+	}
+
+	if kp.throttle != nil {
+		kp.throttle.Close()
+	}
+	kp.config.Transport.CloseIdleConnections()
+	return firstErr
+}
+
+// recordProduced adds n produced messages to the topic's count, registering the topic if new
+func (kp *KafkaProducer) recordProduced(topicName string, n int) {
+	if kp.config.LiteMode {
+		return
+	}
+
+	kp.producedMu.Lock()
+	kp.producedCounts[topicName] += int64(n)
+	kp.producedMu.Unlock()
+
+	if n > 0 {
+		producedMessagesTotal.WithLabelValues(topicName).Add(float64(n))
+	}
+}
+
+// recordErrors counts n messages that failed to be produced at the given stage
+func (kp *KafkaProducer) recordErrors(topicName, stage string, n int) {
+	if kp.config.LiteMode {
+		return
+	}
+	producerErrorsTotal.WithLabelValues(topicName, stage).Add(float64(n))
+}
+
+// recordDropped counts n messages dropped by the drop queue policy
+func (kp *KafkaProducer) recordDropped(topicName string, n int) {
+	log.Printf("Dropped %d messages for topic %s, async writer queue is full", n, topicName)
+	if kp.config.LiteMode {
+		return
+	}
+	droppedMessagesTotal.WithLabelValues(topicName).Add(float64(n))
+}
+
+// Topics returns every topic seen since startup with its produced message count, sorted by name
+func (kp *KafkaProducer) Topics() []TopicCount {
+	kp.producedMu.Lock()
+	defer kp.producedMu.Unlock()
+
+	topics := make([]TopicCount, 0, len(kp.producedCounts))
+	for topicName, count := range kp.producedCounts {
+		topics = append(topics, TopicCount{Topic: topicName, Messages: count})
+	}
+	sort.Slice(topics, func(i, j int) bool { return topics[i].Topic < topics[j].Topic })
+
+	return topics
+}
+
+// MessageKey returns the key of an event's message: the event ID, so hash-based
+// balancers can partition on it, or the KAFKA_KEY_TEMPLATE key. It is nil for
+// keyless messages.
+func (kp *KafkaProducer) MessageKey(event Event) []byte {
+	switch {
+	case kp.config.Keyless:
+		return nil
+	case kp.config.KeyTemplate != nil:
+		return kp.config.KeyTemplate.Key(event)
+	}
+	return []byte(event.ID)
+}
+
+// buildMessage creates the Kafka message for an event (without Topic since the writer has it)
+func (kp *KafkaProducer) buildMessage(event Event) (kafka.Message, error) {
+	message := kafka.Message{
+		Key:  kp.MessageKey(event),
+		Time: time.Now(),
+	}
+	if kp.config.MessageTimeSource == TimeSourceEvent {
+		if t, ok := eventTime(event); ok {
+			message.Time = t
+		} else if !kp.config.LiteMode {
+			// Counted rather than logged, a client sending milliseconds would flood the log
+			eventTimeFallbacksTotal.Inc()
+		}
+	}
+
+	if kp.config.ValueMode == ValueModePayload {
+		message.Value = []byte(event.Payload)
+		message.Headers = stripHeaders(eventHeaders(event), kp.config.StripFields)
+	} else {
+		// Convert event to JSON
+		eventBytes, err := marshalEvent(event, kp.config.StripFields)
+		if err != nil {
+			return kafka.Message{}, fmt.Errorf("failed to marshal event: %w", err)
+		}
+		message.Value = eventBytes
+	}
+
+	if event.batchID != "" {
+		message.Headers = append(message.Headers, kafka.Header{Key: "batch-id", Value: []byte(event.batchID)})
+	}
+	message.Headers = append(message.Headers, event.forwarded...)
+
+	// Events rerouted to the default topic keep their intended topic in a header
+	if topic, err := kp.topicFor(event); err == nil && kp.config.FixedTopic == "" && event.Topic == "" && hasRoutingFields(event) && kp.config.AllowedTopics != nil && !kp.config.AllowedTopics[topic] {
+		message.Headers = append(message.Headers, kafka.Header{Key: "original-topic", Value: []byte(topic)})
+	}
+
+	return message, nil
+}
+
+// Route returns the topic an event is produced to. Without an allow-list this is
+// always kp.topicFor(event); topics outside the allow-list go to the default topic,
+// or are rejected when no default topic is configured. Events missing a routing
+// field always go to the default topic. With a fixed topic every event goes there.
+// A topic set on the event itself is used as is, and rejected rather than
+// rerouted when it is not a valid or allowed topic name.
+func (kp *KafkaProducer) Route(event Event) (string, error) {
+	if kp.config.FixedTopic != "" {
+		return kp.config.FixedTopic, nil
+	}
+	if event.Topic != "" {
+		if !validTopicName.MatchString(event.Topic) {
+			return "", fmt.Errorf("topic name %q is not valid", event.Topic)
+		}
+		if kp.config.AllowedTopics != nil && !kp.config.AllowedTopics[event.Topic] {
+			return "", fmt.Errorf("topic %s is not allowed", event.Topic)
+		}
+		return event.Topic, nil
+	}
+	if !hasRoutingFields(event) {
+		if kp.config.DefaultTopic == "" {
+			return "", errors.New("domain, subdomain and code are required")
+		}
+		return kp.config.DefaultTopic, nil
+	}
+
+	topic, err := kp.topicFor(event)
+	if err != nil {
+		return "", err
+	}
+	// Fields with spaces or other characters Kafka does not allow would only fail at write time
+	if !validTopicName.MatchString(topic) {
+		if kp.config.DefaultTopic == "" {
+			return "", fmt.Errorf("topic name %q is not valid", topic)
+		}
+		return kp.config.DefaultTopic, nil
+	}
+	if kp.config.AllowedTopics == nil || kp.config.AllowedTopics[topic] {
+		return topic, nil
+	}
+	if kp.config.DefaultTopic == "" {
+		return "", fmt.Errorf("topic %s is not allowed", topic)
+	}
+	return kp.config.DefaultTopic, nil
+}
+
+// minEventTime rejects EventTimestamp values that are zero or clearly not in
+// nanoseconds (e.g. milliseconds, which would land in January 1970)
+var minEventTime = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// eventTime converts the event's nanosecond EventTimestamp, or its RFC3339 EventTime
+// when the timestamp is invalid, and reports false when neither is usable
+func eventTime(event Event) (time.Time, bool) {
+	if t := time.Unix(0, event.EventTimestamp); event.EventTimestamp > 0 && !t.Before(minEventTime) {
+		return t, true
+	}
+	if t, err := time.Parse(time.RFC3339Nano, event.EventTime); err == nil && !t.Before(minEventTime) {
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+// eventHeaders carries the event envelope as message headers, named after the JSON fields
+func eventHeaders(event Event) []kafka.Header {
+	headers := []kafka.Header{
+		{Key: "id", Value: []byte(event.ID)},
+		{Key: "domain", Value: []byte(event.Domain)},
+		{Key: "subdomain", Value: []byte(event.Subdomain)},
+		{Key: "code", Value: []byte(event.Code)},
+		{Key: "version", Value: []byte(event.Version)},
+		{Key: "eventtime", Value: []byte(event.EventTime)},
+		{Key: "eventtimestamp", Value: []byte(strconv.FormatInt(event.EventTimestamp, 10))},
+		{Key: "branchid", Value: []byte(event.BranchID.String())},
+		{Key: "channelid", Value: []byte(event.ChannelID.String())},
+		{Key: "customerid", Value: []byte(event.CustomerID.String())},
+		{Key: "userid", Value: []byte(event.UserID.String())},
+	}
+	if event.ReceivedAt != "" {
+		headers = append(headers, kafka.Header{Key: "receivedat", Value: []byte(event.ReceivedAt)})
+	}
+	if event.SourceInstance != "" {
+		headers = append(headers, kafka.Header{Key: "sourceinstance", Value: []byte(event.SourceInstance)})
+	}
+	return headers
+}
+
+// SendEvent sends an event to Kafka
+func (kp *KafkaProducer) SendEvent(event Event) error {
+	// Generate topic name from domain, subdomain, and code
+	topicName, err := kp.Route(event)
+	if err != nil {
+		return err
+	}
+
+	message, err := kp.buildMessage(event)
+	if err != nil {
+		return err
+	}
+
+	// Create context with timeout for write operation
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := kp.ensureTopic(ctx, topicName); err != nil {
+		return err
+	}
+
+	// Send message with timeout context
+	if err := kp.writerFor(topicName, kp.config.RequiredAcks, false).write(ctx, message); err != nil {
+		return err
+	}
+	kp.recordProduced(topicName, 1)
+
+	return nil
+}
+
+// SendEvents sends multiple events to Kafka in batches per topic. Topics are produced
+// to in parallel, bounded by ProduceConcurrency. The returned map is keyed by event ID
+// for per-event failures and by topic name for topic-level write failures.
+//
+// The enricher modifies the events of the caller's slice in place. Callers that
+// hold events for a retry (the spool and disk buffer) rely on this, so a retried
+// event keeps what was added on its first attempt, like its ReceivedAt.
+func (kp *KafkaProducer) SendEvents(events []Event, opts SendOptions) map[string]error {
+	errors := make(map[string]error)
+	if len(events) == 0 {
+		return errors
+	}
+
+	// Every event takes one token from the global EVENTS_PER_SECOND_LIMIT budget
+	if kp.throttle != nil {
+		if err := kp.throttle.Wait(len(events)); err != nil {
+			for _, event := range events {
+				errors[event.ID] = err
+			}
+			return errors
+		}
+	}
+
+	acks := kp.config.RequiredAcks
+	if opts.Acks != nil {
+		acks = *opts.Acks
+	}
+	// With acks=none the broker never answers, so a sync write waits for the leader
+	if opts.Sync && acks == kafka.RequireNone {
+		acks = kafka.RequireOne
+	}
+
+	// A sync write already waits for the broker
+	var flush *flushTracker
+	if opts.Flush && !opts.Sync {
+		flush = newFlushTracker()
+	}
+
+	// Enriched in place, so events held for a retry keep what was added
+	for i := range events {
+		kp.config.Enricher.Enrich(&events[i])
+	}
+
+	// Group events by topic
+	eventsByTopic := make(map[string][]Event)
+	var errorsMu sync.Mutex
+	recordError := func(key string, err error) {
+		errorsMu.Lock()
+		errors[key] = err
+		errorsMu.Unlock()
+	}
+
+	for _, event := range events {
+		topicName, err := kp.Route(event)
+		if err != nil {
+			recordError(event.ID, err)
+			continue
+		}
+		eventsByTopic[topicName] = append(eventsByTopic[topicName], event)
+	}
+
+	// Send events for each topic in batch, several topics at a time
+	var group errgroup.Group
+	group.SetLimit(kp.config.ProduceConcurrency)
+	for topicName, topicEvents := range eventsByTopic {
+		topicName, topicEvents := topicName, topicEvents
+		group.Go(func() error {
+			kp.sendTopic(topicName, topicEvents, acks, opts.Sync, opts.Partition, flush, recordError)
+			return nil
+		})
+	}
+	_ = group.Wait() // errors are collected per event/topic, never returned
+
+	if flush != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), flushWaitTimeout)
+		defer cancel()
+		for id, err := range flush.wait(ctx) {
+			if _, ok := errors[id]; !ok {
+				errors[id] = err
+			}
+		}
+	}
+
+	return errors
 }
 
-// EventResponse represents the response structure
-type EventResponse struct {
-	SuccessEventIds []string `json:"successEventIds"`
-	InvalidEventIds []string `json:"invalidEventIds"`
-	FailedEventIds  []string `json:"failedEventIds"`
-}
+// sendTopic produces one topic's events in sub-batches that fit the writer and the
+// in-flight limits, pinned to partition when it is non-nil, reporting failures
+// through recordError.
+// The messages written are tracked by flush when it is non-nil.
+func (kp *KafkaProducer) sendTopic(topicName string, topicEvents []Event, acks kafka.RequiredAcks, sync bool, partition *int, flush *flushTracker, recordError func(key string, err error)) {
+	// Reuse the cached writer for this specific topic, ack level and mode
+	writer := kp.writerFor(topicName, acks, sync)
+
+	// Prepare messages for this topic, with the ID of each message's event
+	messages := make([]kafka.Message, 0, len(topicEvents))
+	ids := make([]string, 0, len(topicEvents))
+	for _, event := range topicEvents {
+		message, err := kp.buildMessage(event)
+		if err != nil {
+			recordError(event.ID, err)
+			continue
+		}
+		if kp.config.AuditLog != nil || flush != nil {
+			message.WriterData = &messageData{eventID: event.ID, flush: flush}
+		}
+		messages = append(messages, message)
+		ids = append(ids, event.ID)
+	}
+
+	// Create context with timeout for write operation
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Create the topic with the configured layout before its first write
+	if err := kp.ensureTopic(ctx, topicName); err != nil {
+		recordError(topicName, err)
+		kp.recordProduced(topicName, 0)
+		return
+	}
+	if partition != nil {
+		if err := kp.pinPartition(ctx, topicName, messages, *partition); err != nil {
+			recordError(topicName, err)
+			kp.recordProduced(topicName, 0)
+			return
+		}
+	} else if kp.config.PreserveOrder {
+		// kafka-go writes a partition's batches one at a time, so messages written
+		// in order to one partition stay in order
+		if err := kp.pinOrdered(ctx, topicName, messages); err != nil {
+			recordError(topicName, err)
+			kp.recordProduced(topicName, 0)
+			return
+		}
+	}
+
+	// kafka-go fails a whole WriteMessages call for one message over BatchBytes, so
+	// the messages are written in sub-batches that fit and each fails on its own.
+	// A sub-batch also fits in ASYNC_MAX_IN_FLIGHT and ASYNC_MAX_BYTES, which could
+	// never admit a larger one.
+	maxBytes := writerBatchBytes
+	if kp.config.MaxInFlightBytes > 0 && kp.config.MaxInFlightBytes < maxBytes {
+		maxBytes = kp.config.MaxInFlightBytes
+	}
+	batches := splitBatches(messages, maxBytes, kp.config.MaxInFlight)
+	if len(batches) > 1 && !kp.config.LiteMode {
+		splitBatchesTotal.WithLabelValues(topicName).Inc()
+	}
+	produced := 0
+	timedOut := false
+	for _, batch := range batches {
+		if flush != nil {
+			flush.add(ids[batch.start:batch.end])
+		}
+		err := kp.writeBatch(ctx, writer, topicName, messages[batch.start:batch.end])
+		if err != nil && flush != nil {
+			// Nothing was enqueued, the failure is recorded below
+			completeFlushes(messages[batch.start:batch.end], nil)
+		}
+		var writeErrors kafka.WriteErrors
+		switch {
+		case err == nil:
+			produced += batch.end - batch.start
+			continue
+		case errors.As(err, &writeErrors) && len(writeErrors) == batch.end-batch.start:
+			// A sync write reports the broker's answer for each message
+			for i, werr := range writeErrors {
+				if werr == nil {
+					produced++
+					continue
+				}
+				recordError(ids[batch.start+i], &batchWriteError{err: werr})
+			}
+		case isMessageTooLarge(err):
+			// Only this event is too large; it is not a Kafka outage
+			for _, id := range ids[batch.start:batch.end] {
+				recordError(id, err)
+			}
+		case len(batches) == 1:
+			recordError(topicName, err)
+		default:
+			for _, id := range ids[batch.start:batch.end] {
+				recordError(id, &batchWriteError{err: err})
+			}
+		}
+		timedOut = timedOut || isTimeoutError(err)
+	}
+	if timedOut && kp.shedder != nil {
+		kp.shedder.RecordTimeout()
+	}
+	kp.recordProduced(topicName, produced)
+}
+
+// writeBatch writes one sub-batch of a topic's messages, counting and dead-lettering
+// the messages when it fails. When a sync write reports which messages failed, only
+// those are.
+func (kp *KafkaProducer) writeBatch(ctx context.Context, writer *topicWriter, topicName string, messages []kafka.Message) error {
+	err := writer.write(ctx, messages...)
+	if err == nil {
+		return nil
+	}
+	var writeErrors kafka.WriteErrors
+	if errors.As(err, &writeErrors) && len(writeErrors) == len(messages) {
+		failed := make([]kafka.Message, 0, writeErrors.Count())
+		for i, werr := range writeErrors {
+			if werr != nil {
+				failed = append(failed, messages[i])
+			}
+		}
+		messages = failed
+	}
+	if errors.Is(err, errQueueDropped) {
+		kp.recordDropped(topicName, len(messages))
+	} else {
+		kp.recordErrors(topicName, "write", len(messages))
+	}
+	// Backpressure rejections are not dead-lettered, the client is told to retry.
+	// With a spool or disk buffer the failed events are held by produceEvents instead.
+	// A message too large to write would be rejected by the dead-letter topic too.
+	if !errors.Is(err, errQueueFull) && !isMessageTooLarge(err) && kp.spool == nil && kp.diskBuffer == nil {
+		kp.deadLetter(topicName, messages, err)
+	}
+	return err
+}
+
+// topicFor returns the topic an event is produced to: {domain}_{subdomain}_{code}
+// unless TOPIC_TEMPLATE configured another template
+func (kp *KafkaProducer) topicFor(event Event) (string, error) {
+	if kp.config.TopicTemplate != nil {
+		return kp.config.TopicTemplate.Topic(event)
+	}
+	return fmt.Sprintf("%s_%s_%s", event.Domain, event.Subdomain, event.Code), nil
+}
+
+// hasRoutingFields reports whether the event has the fields every topic needs
+func hasRoutingFields(event Event) bool {
+	return event.Domain != "" && event.Subdomain != "" && event.Code != ""
+}
+
+// normalizeEvent trims surrounding whitespace from the fields that make up the topic
+// name, so " Banking " and "Banking" are validated and routed the same way
+func normalizeEvent(event Event) Event {
+	event.Domain = strings.TrimSpace(event.Domain)
+	event.Subdomain = strings.TrimSpace(event.Subdomain)
+	event.Code = strings.TrimSpace(event.Code)
+	event.Topic = strings.TrimSpace(event.Topic)
+	return event
+}
+
+// errPayloadNotJSON rejects events whose payload is not JSON when PAYLOAD_MUST_BE_JSON is set
+var errPayloadNotJSON = errors.New("payload is not valid JSON")
+
+// validateEvent validates the incoming event, returning the first rule it breaks.
+// With payloadMustBeJSON the payload must be a valid JSON document.
+func validateEvent(event Event, payloadMustBeJSON bool) error {
+	switch {
+	case event.ID == "":
+		return errors.New("id is required")
+	case payloadMustBeJSON && !json.Valid([]byte(event.Payload)):
+		return errPayloadNotJSON
+	case event.Domain == "":
+		return errors.New("domain is required")
+	case event.Subdomain == "":
+		return errors.New("subdomain is required")
+	case event.Code == "":
+		return errors.New("code is required")
+	}
+	return nil
+}
+
+func main() {
+	// Set by deferred shutdown steps; registered first so every other deferred call runs before the exit
+	exitCode := 0
+	defer func() {
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+	}()
+
+	// Settings come from the environment, falling back to CONFIG_FILE
+	configFile := os.Getenv("CONFIG_FILE")
+	settings, err := LoadSettings(configFile)
+	if err != nil {
+		log.Fatalf("Invalid CONFIG_FILE: %v", err)
+	}
+
+	// Get port from environment variable
+	port := settings.Get("PORT")
+	if port == "" {
+		port = "8080" // default value
+	}
+
+	// Get Kafka brokers from environment variable
+	brokersEnv := settings.Get("KAFKA_BROKERS")
+	if brokersEnv == "" {
+		brokersEnv = "localhost:9092" // default value
+	}
+	brokers := strings.Split(brokersEnv, ",")
+
+	// Get partition balancer from environment variable
+	balancerName := settings.Get("PARTITION_BALANCER")
+	if balancerName == "" {
+		balancerName = "leastbytes" // default value
+	}
+	newBalancer, err := balancerFactory(balancerName)
+	if err != nil {
+		log.Fatalf("Invalid PARTITION_BALANCER: %v", err)
+	}
+
+	// Get max request body size from environment variable
+	maxRequestBytes := int64(10 << 20) // default value: 10MB
+	if v := settings.Get("MAX_REQUEST_BYTES"); v != "" {
+		maxRequestBytes, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || maxRequestBytes <= 0 {
+			log.Fatalf("Invalid MAX_REQUEST_BYTES: %q", v)
+		}
+	}
+
+	// Gzip /events responses of at least this many bytes for clients that accept it; 0 disables
+	responseCompressionMinBytes := 8 << 10 // default value: 8KB
+	if v := settings.Get("RESPONSE_COMPRESSION_MIN_BYTES"); v != "" {
+		responseCompressionMinBytes, err = strconv.Atoi(v)
+		if err != nil || responseCompressionMinBytes < 0 {
+			log.Fatalf("Invalid RESPONSE_COMPRESSION_MIN_BYTES: %q", v)
+		}
+	}
+
+	// Reject unknown JSON fields unless STRICT_JSON is explicitly disabled
+	strictJSON := true // default value
+	if v := settings.Get("STRICT_JSON"); v != "" {
+		strictJSON, err = strconv.ParseBool(v)
+		if err != nil {
+			log.Fatalf("Invalid STRICT_JSON: %q", v)
+		}
+	}
+
+	// Answer an empty array with empty result lists; disable to reject it with 400
+	allowEmptyBatch := true // default value
+	if v := settings.Get("ALLOW_EMPTY_BATCH"); v != "" {
+		allowEmptyBatch, err = strconv.ParseBool(v)
+		if err != nil {
+			log.Fatalf("Invalid ALLOW_EMPTY_BATCH: %q", v)
+		}
+	}
+
+	// Include the decoder's message in invalid JSON responses; off so production
+	// does not expose internals
+	verboseErrors := false // default value
+	if v := settings.Get("VERBOSE_ERRORS"); v != "" {
+		verboseErrors, err = strconv.ParseBool(v)
+		if err != nil {
+			log.Fatalf("Invalid VERBOSE_ERRORS: %q", v)
+		}
+	}
+
+	// Answer 207 Multi-Status for partially failed batches unless PARTIAL_STATUS_CODE is disabled
+	partialStatus := true // default value
+	if v := settings.Get("PARTIAL_STATUS_CODE"); v != "" {
+		partialStatus, err = strconv.ParseBool(v)
+		if err != nil {
+			log.Fatalf("Invalid PARTIAL_STATUS_CODE: %q", v)
+		}
+	}
+
+	// JSON field holding the event ID, for upstreams that do not call it "id"
+	eventIDField := settings.Get("EVENT_ID_FIELD")
+	if eventIDField == "" {
+		eventIDField = "id" // default value
+	}
+
+	// Reject events whose payload is not JSON, for consumers that parse it
+	payloadMustBeJSON := false // default value
+	if v := settings.Get("PAYLOAD_MUST_BE_JSON"); v != "" {
+		payloadMustBeJSON, err = strconv.ParseBool(v)
+		if err != nil {
+			log.Fatalf("Invalid PAYLOAD_MUST_BE_JSON: %q", v)
+		}
+	}
+
+	// Numeric fields that must be greater than zero, since downstream treats 0 as unknown
+	var positiveFields []string // default value: none
+	if v := settings.Get("POSITIVE_INT_FIELDS"); v != "" {
+		positiveFields, err = ParsePositiveIntFields(v)
+		if err != nil {
+			log.Fatalf("Invalid POSITIVE_INT_FIELDS: %q: %v", v, err)
+		}
+	}
+
+	// Format EventTime is sent in; unset leaves it unvalidated
+	var eventTimeFormat *EventTimeFormat // default value: none
+	if v := settings.Get("EVENT_TIME_FORMAT"); v != "" {
+		eventTimeFormat, err = ParseEventTimeFormat(v)
+		if err != nil {
+			log.Fatalf("Invalid EVENT_TIME_FORMAT: %v", err)
+		}
+	}
+
+	// Get partition count and replication factor for topics created by the producer.
+	// The KAFKA_TOPIC_* names are aliases and win when both are set.
+	topicPartitions := 0 // default value: leave creation to broker auto-creation
+	for _, name := range []string{"DEFAULT_PARTITIONS", "KAFKA_TOPIC_PARTITIONS"} {
+		if v := settings.Get(name); v != "" {
+			topicPartitions, err = strconv.Atoi(v)
+			if err != nil || topicPartitions < 0 {
+				log.Fatalf("Invalid %s: %q", name, v)
+			}
+		}
+	}
+	replicationFactor := 1 // default value
+	for _, name := range []string{"DEFAULT_REPLICATION_FACTOR", "KAFKA_TOPIC_REPLICATION"} {
+		if v := settings.Get(name); v != "" {
+			replicationFactor, err = strconv.Atoi(v)
+			if err != nil || replicationFactor < 1 {
+				log.Fatalf("Invalid %s: %q", name, v)
+			}
+		}
+	}
+
+	// Stamp ReceivedAt and a default Version onto events when ENABLE_ENRICHMENT is set
+	enableEnrichment := false // default value
+	if v := settings.Get("ENABLE_ENRICHMENT"); v != "" {
+		enableEnrichment, err = strconv.ParseBool(v)
+		if err != nil {
+			log.Fatalf("Invalid ENABLE_ENRICHMENT: %q", v)
+		}
+	}
+
+	// Enrichers run on every event before it is produced
+	var enricherNames []string // default value: none
+	if v := settings.Get("EVENT_ENRICHERS"); v != "" {
+		enricherNames = ParseEnricherNames(v)
+	} else if enableEnrichment {
+		// ENABLE_ENRICHMENT=true is the same as EVENT_ENRICHERS=received_at,default_version
+		enricherNames = legacyEnrichers
+	}
+	sourceInstance := settings.Get("SOURCE_INSTANCE")
+	if sourceInstance == "" {
+		sourceInstance, _ = os.Hostname() // default value
+	}
+	enrichers, err := NewEnricherChain(enricherNames, sourceInstance)
+	if err != nil {
+		log.Fatalf("Invalid EVENT_ENRICHERS: %v", err)
+	}
+	var enricher EventEnricher
+	if len(enrichers) > 0 {
+		enricher = enrichers
+	}
+
+	// Durability profile, the defaults of the ack level and the writer retries and timeout
+	durabilityProfile := DurabilityBalanced // default value
+	if v := settings.Get("DURABILITY_PROFILE"); v != "" {
+		durabilityProfile = strings.ToLower(v)
+	}
+	profile, err := NewDurabilityProfile(durabilityProfile)
+	if err != nil {
+		log.Fatalf("Invalid DURABILITY_PROFILE: %v", err)
+	}
+
+	// Get default required acks from environment variable
+	requiredAcks := profile.RequiredAcks // default value: from DURABILITY_PROFILE
+	if v := settings.Get("REQUIRED_ACKS"); v != "" {
+		requiredAcks, err = parseRequiredAcks(v)
+		if err != nil {
+			log.Fatalf("Invalid REQUIRED_ACKS: %v", err)
+		}
+	}
+	writeAttempts := profile.WriteAttempts // default value: from DURABILITY_PROFILE
+	if v := settings.Get("KAFKA_WRITE_ATTEMPTS"); v != "" {
+		writeAttempts, err = strconv.Atoi(v)
+		if err != nil || writeAttempts <= 0 {
+			log.Fatalf("Invalid KAFKA_WRITE_ATTEMPTS: %q", v)
+		}
+	}
+	writeTimeout := profile.WriteTimeout // default value: from DURABILITY_PROFILE
+	if v := settings.Get("KAFKA_WRITE_TIMEOUT"); v != "" {
+		writeTimeout, err = time.ParseDuration(v)
+		if err != nil || writeTimeout <= 0 {
+			log.Fatalf("Invalid KAFKA_WRITE_TIMEOUT: %q", v)
+		}
+	}
+	syncWrites := profile.SyncWrites // default value: from DURABILITY_PROFILE
+	if v := settings.Get("KAFKA_SYNC_WRITES"); v != "" {
+		syncWrites, err = strconv.ParseBool(v)
+		if err != nil {
+			log.Fatalf("Invalid KAFKA_SYNC_WRITES: %q", v)
+		}
+	}
+
+	// Longest an async message waits in a writer's batch before it is sent
+	batchTimeout := writerBatchTimeout // default value
+	if v := settings.Get("WRITER_BATCH_TIMEOUT"); v != "" {
+		batchTimeout, err = time.ParseDuration(v)
+		if err != nil || batchTimeout <= 0 {
+			log.Fatalf("Invalid WRITER_BATCH_TIMEOUT: %q", v)
+		}
+	}
+
+	// Answer /events only once the batches holding the request's messages completed
+	flushOnBatchComplete := false // default value
+	if v := settings.Get("FLUSH_ON_BATCH_COMPLETE"); v != "" {
+		flushOnBatchComplete, err = strconv.ParseBool(v)
+		if err != nil {
+			log.Fatalf("Invalid FLUSH_ON_BATCH_COMPLETE: %q", v)
+		}
+	}
+
+	// Get message value mode from environment variable
+	valueMode := strings.ToLower(settings.Get("VALUE_MODE"))
+	if valueMode == "" {
+		valueMode = ValueModeFull // default value
+	}
+	if valueMode != ValueModeFull && valueMode != ValueModePayload {
+		log.Fatalf("Invalid VALUE_MODE: %q (expected %s or %s)", valueMode, ValueModeFull, ValueModePayload)
+	}
+
+	// Name topics from event fields, e.g. "{domain}-shard{customerid%16}"
+	topicTemplate := settings.Get("TOPIC_TEMPLATE")
+	if topicTemplate == "" {
+		topicTemplate = defaultTopicTemplate // default value
+	}
+	var parsedTopicTemplate *TopicTemplate
+	if topicTemplate != defaultTopicTemplate {
+		parsedTopicTemplate, err = ParseTopicTemplate(topicTemplate)
+		if err != nil {
+			log.Fatalf("Invalid TOPIC_TEMPLATE: %q: %v", topicTemplate, err)
+		}
+	}
+
+	// Produce every event to one topic instead of naming topics from event fields
+	fixedTopic := settings.Get("FIXED_TOPIC") // default value: empty, use TOPIC_TEMPLATE
+	if fixedTopic != "" {
+		if !validTopicName.MatchString(fixedTopic) {
+			log.Fatalf("Invalid FIXED_TOPIC: %q", fixedTopic)
+		}
+		if topicTemplate != defaultTopicTemplate {
+			log.Fatalf("FIXED_TOPIC cannot be combined with TOPIC_TEMPLATE")
+		}
+	}
+
+	// Build message keys from event fields, e.g. "{customerid}:{branchid}"
+	keyTemplate := settings.Get("KAFKA_KEY_TEMPLATE") // default value: empty, key by event ID
+	var parsedKeyTemplate *KeyTemplate
+	if keyTemplate != "" {
+		parsedKeyTemplate, err = ParseKeyTemplate(keyTemplate)
+		if err != nil {
+			log.Fatalf("Invalid KAFKA_KEY_TEMPLATE: %q: %v", keyTemplate, err)
+		}
+	}
+
+	// Event fields removed from produced messages, e.g. for PII redaction
+	var stripFields map[string]bool
+	if v := settings.Get("STRIP_FIELDS"); v != "" {
+		stripFields, err = ParseStripFields(v)
+		if err != nil {
+			log.Fatalf("Invalid STRIP_FIELDS: %q: %v", v, err)
+		}
+	}
+
+	// Request headers copied into every message of the request, e.g. X-Tenant-ID
+	var forwardHeaders []string // default value: none
+	if v := settings.Get("FORWARD_HEADERS"); v != "" {
+		forwardHeaders, err = ParseForwardHeaders(v)
+		if err != nil {
+			log.Fatalf("Invalid FORWARD_HEADERS: %q: %v", v, err)
+		}
+	}
+
+	// Partition key: the event ID (or KAFKA_KEY_TEMPLATE), or none for keyless messages
+	partitionKey := PartitionKeyID // default value
+	if v := settings.Get("PARTITION_KEY"); v != "" {
+		switch strings.ToLower(v) {
+		case PartitionKeyID, PartitionKeyNone:
+			partitionKey = strings.ToLower(v)
+		default:
+			log.Fatalf("Invalid PARTITION_KEY: %q", v)
+		}
+	}
+	if partitionKey == PartitionKeyNone && keyTemplate != "" {
+		log.Fatalf("PARTITION_KEY=none cannot be combined with KAFKA_KEY_TEMPLATE")
+	}
+
+	// Keep each topic's events in request order on one partition per topic
+	preserveOrder := false // default value
+	if v := settings.Get("PRESERVE_ORDER"); v != "" {
+		preserveOrder, err = strconv.ParseBool(v)
+		if err != nil {
+			log.Fatalf("Invalid PRESERVE_ORDER: %q", v)
+		}
+	}
+
+	// Get number of topics produced to in parallel per request
+	produceConcurrency := 8 // default value
+	if v := settings.Get("PRODUCE_CONCURRENCY"); v != "" {
+		produceConcurrency, err = strconv.Atoi(v)
+		if err != nil || produceConcurrency < 1 {
+			log.Fatalf("Invalid PRODUCE_CONCURRENCY: %q", v)
+		}
+	}
+
+	// Opt-in Idempotency-Key support for POST /events
+	var idempotency *IdempotencyCache
+	idempotencyEnabled := false // default value
+	if v := settings.Get("IDEMPOTENCY_ENABLED"); v != "" {
+		idempotencyEnabled, err = strconv.ParseBool(v)
+		if err != nil {
+			log.Fatalf("Invalid IDEMPOTENCY_ENABLED: %q", v)
+		}
+	}
+	idempotencyCacheSize := 10000 // default value
+	if v := settings.Get("IDEMPOTENCY_CACHE_SIZE"); v != "" {
+		idempotencyCacheSize, err = strconv.Atoi(v)
+		if err != nil || idempotencyCacheSize < 1 {
+			log.Fatalf("Invalid IDEMPOTENCY_CACHE_SIZE: %q", v)
+		}
+	}
+	idempotencyTTL := 10 * time.Minute // default value
+	if v := settings.Get("IDEMPOTENCY_TTL"); v != "" {
+		idempotencyTTL, err = time.ParseDuration(v)
+		if err != nil || idempotencyTTL <= 0 {
+			log.Fatalf("Invalid IDEMPOTENCY_TTL: %q", v)
+		}
+	}
+	if idempotencyEnabled {
+		idempotency = NewIdempotencyCache(idempotencyCacheSize, idempotencyTTL)
+	}
+
+	// Circuit breaker around Kafka writes
+	breakerThreshold := 5 // default value
+	if v := settings.Get("BREAKER_FAILURE_THRESHOLD"); v != "" {
+		breakerThreshold, err = strconv.Atoi(v)
+		if err != nil || breakerThreshold < 0 {
+			log.Fatalf("Invalid BREAKER_FAILURE_THRESHOLD: %q", v)
+		}
+	}
+	breakerCooldown := 30 * time.Second // default value
+	if v := settings.Get("BREAKER_COOLDOWN"); v != "" {
+		breakerCooldown, err = time.ParseDuration(v)
+		if err != nil || breakerCooldown <= 0 {
+			log.Fatalf("Invalid BREAKER_COOLDOWN: %q", v)
+		}
+	}
+	breaker := NewCircuitBreaker(breakerThreshold, breakerCooldown)
+
+	// Shed load after repeated Kafka write timeouts
+	timeoutBackpressureThreshold := 0 // default value, disabled
+	if v := settings.Get("TIMEOUT_BACKPRESSURE_THRESHOLD"); v != "" {
+		timeoutBackpressureThreshold, err = strconv.Atoi(v)
+		if err != nil || timeoutBackpressureThreshold < 0 {
+			log.Fatalf("Invalid TIMEOUT_BACKPRESSURE_THRESHOLD: %q", v)
+		}
+	}
+	timeoutBackpressureWindow := time.Minute // default value
+	if v := settings.Get("TIMEOUT_BACKPRESSURE_WINDOW"); v != "" {
+		timeoutBackpressureWindow, err = time.ParseDuration(v)
+		if err != nil || timeoutBackpressureWindow <= 0 {
+			log.Fatalf("Invalid TIMEOUT_BACKPRESSURE_WINDOW: %q", v)
+		}
+	}
+	timeoutBackpressureCooldown := 30 * time.Second // default value
+	if v := settings.Get("TIMEOUT_BACKPRESSURE_COOLDOWN"); v != "" {
+		timeoutBackpressureCooldown, err = time.ParseDuration(v)
+		if err != nil || timeoutBackpressureCooldown <= 0 {
+			log.Fatalf("Invalid TIMEOUT_BACKPRESSURE_COOLDOWN: %q", v)
+		}
+	}
 
-// KafkaProducer wraps the kafka writer
-type KafkaProducer struct {
-	writer  *kafka.Writer
-	brokers []string
-}
+	// Where the Kafka message time comes from
+	messageTimeSource := TimeSourceIngest // default value
+	if v := settings.Get("USE_EVENT_TIMESTAMP"); v != "" {
+		// Deprecated: USE_EVENT_TIMESTAMP=true is the same as MESSAGE_TIME_SOURCE=event
+		useEventTimestamp, err := strconv.ParseBool(v)
+		if err != nil {
+			log.Fatalf("Invalid USE_EVENT_TIMESTAMP: %q", v)
+		}
+		if useEventTimestamp {
+			messageTimeSource = TimeSourceEvent
+		}
+	}
+	if v := settings.Get("MESSAGE_TIME_SOURCE"); v != "" {
+		switch v {
+		case TimeSourceIngest, TimeSourceEvent:
+			messageTimeSource = v
+		default:
+			log.Fatalf("Invalid MESSAGE_TIME_SOURCE: %q", v)
+		}
+	}
 
-// NewKafkaProducer creates a new Kafka producer
-func NewKafkaProducer(brokers []string) *KafkaProducer {
-	writer := &kafka.Writer{
-		Addr:                   kafka.TCP(brokers...),
-		Balancer:               &kafka.LeastBytes{},
-		RequiredAcks:           kafka.RequireOne,
-		Async:                  true,                  // Enable async for better batching performance
-		BatchSize:              100,                   // Number of messages per batch
-		BatchBytes:             1048576,               // 1MB batch size
-		BatchTimeout:           10 * time.Millisecond, // 10ms batch timeout
-		WriteTimeout:           10 * time.Second,      // 10 second write timeout
-		ReadTimeout:            10 * time.Second,      // 10 second read timeout
-		AllowAutoTopicCreation: true,
+	// Compression codec for produced batches
+	var compression kafka.Compression // default value: none
+	if v := settings.Get("KAFKA_COMPRESSION"); v != "" {
+		if err := compression.UnmarshalText([]byte(strings.ToLower(v))); err != nil {
+			log.Fatalf("Invalid KAFKA_COMPRESSION: %q", v)
+		}
 	}
 
-	return &KafkaProducer{
-		writer:  writer,
-		brokers: brokers,
+	// Trained zstd dictionary for highly repetitive payloads
+	zstdDictFile := settings.Get("ZSTD_DICT_FILE")
+	if zstdDictFile != "" {
+		if compression != kafka.Zstd {
+			log.Fatalf("ZSTD_DICT_FILE requires KAFKA_COMPRESSION=zstd")
+		}
+		dict, err := os.ReadFile(zstdDictFile)
+		if err != nil {
+			log.Fatalf("Failed to read ZSTD_DICT_FILE: %v", err)
+		}
+		if err := installZstdDict(dict); err != nil {
+			log.Fatalf("Invalid zstd dictionary in %s: %v", zstdDictFile, err)
+		}
 	}
-}
 
-// Close closes the Kafka writer
-func (kp *KafkaProducer) Close() error {
-	return kp.writer.Close()
-}
+	// Connection settings of the transport shared by all writers
+	dialTimeout := 3 * time.Second // default value, as in kafka.DefaultTransport
+	if v := settings.Get("KAFKA_DIAL_TIMEOUT"); v != "" {
+		dialTimeout, err = time.ParseDuration(v)
+		if err != nil || dialTimeout <= 0 {
+			log.Fatalf("Invalid KAFKA_DIAL_TIMEOUT: %q", v)
+		}
+	}
+	idleTimeout := 30 * time.Second // default value
+	if v := settings.Get("KAFKA_IDLE_TIMEOUT"); v != "" {
+		idleTimeout, err = time.ParseDuration(v)
+		if err != nil || idleTimeout <= 0 {
+			log.Fatalf("Invalid KAFKA_IDLE_TIMEOUT: %q", v)
+		}
+	}
+	// kafka.Transport has no idle connection pool size, only KAFKA_IDLE_TIMEOUT
+	if v := settings.Get("KAFKA_MAX_IDLE_CONNS"); v != "" {
+		log.Printf("KAFKA_MAX_IDLE_CONNS=%s is ignored: the Kafka transport closes idle connections after KAFKA_IDLE_TIMEOUT, use KAFKA_MAX_CONNS_PER_BROKER to cap open connections", v)
+	}
+	maxConnsPerBroker := 0 // default value: unlimited
+	if v := settings.Get("KAFKA_MAX_CONNS_PER_BROKER"); v != "" {
+		maxConnsPerBroker, err = strconv.Atoi(v)
+		if err != nil || maxConnsPerBroker < 0 {
+			log.Fatalf("Invalid KAFKA_MAX_CONNS_PER_BROKER: %q", v)
+		}
+	}
+	// Optional rewrite of the broker addresses advertised in metadata
+	var brokerAddressMap map[string]string
+	if v := settings.Get("BROKER_ADDRESS_MAP"); v != "" {
+		brokerAddressMap, err = ParseBrokerAddressMap(v)
+		if err != nil {
+			log.Fatalf("Invalid BROKER_ADDRESS_MAP: %v", err)
+		}
+	}
+	dialer := &net.Dialer{Timeout: dialTimeout, KeepAlive: 30 * time.Second}
+	transport := &kafka.Transport{
+		Dial:        brokerDial(dialer.DialContext, brokerAddressMap, maxConnsPerBroker),
+		DialTimeout: dialTimeout,
+		IdleTimeout: idleTimeout,
+	}
 
-// SendEvent sends an event to Kafka
-func (kp *KafkaProducer) SendEvent(event Event) error {
-	// Generate topic name from domain, subdomain, and code
-	topicName := fmt.Sprintf("%s_%s_%s", event.Domain, event.Subdomain, event.Code)
+	// Broker auto-creation of topics that do not exist yet
+	allowAutoTopicCreation := true // default value
+	if v := settings.Get("ALLOW_AUTO_TOPIC_CREATION"); v != "" {
+		allowAutoTopicCreation, err = strconv.ParseBool(v)
+		if err != nil {
+			log.Fatalf("Invalid ALLOW_AUTO_TOPIC_CREATION: %q", v)
+		}
+	}
 
-	// Convert event to JSON
-	eventBytes, err := json.Marshal(event)
-	if err != nil {
-		return fmt.Errorf("failed to marshal event: %w", err)
+	// Optional topic allow-list, with a catch-all topic for everything else
+	var allowedTopics map[string]bool
+	if v := settings.Get("ALLOWED_TOPICS"); v != "" {
+		allowedTopics = make(map[string]bool)
+		for _, topic := range strings.Split(v, ",") {
+			if topic = strings.TrimSpace(topic); topic != "" {
+				allowedTopics[topic] = true
+			}
+		}
 	}
+	defaultTopic := settings.Get("DEFAULT_TOPIC")
 
-	// Create a writer for this specific topic with batch and timeout settings
-	writer := &kafka.Writer{
-		Addr:                   kafka.TCP(kp.brokers...),
-		Topic:                  topicName,
-		Balancer:               &kafka.LeastBytes{},
-		BatchSize:              100,                   // Number of messages per batch
-		BatchBytes:             1048576,               // 1MB batch size
-		BatchTimeout:           10 * time.Millisecond, // 10ms batch timeout
-		WriteTimeout:           10 * time.Second,      // 10 second write timeout
-		ReadTimeout:            10 * time.Second,      // 10 second read timeout
-		RequiredAcks:           kafka.RequireOne,
-		Async:                  true, // Enable async for better batching
-		AllowAutoTopicCreation: true,
-	}
-	defer writer.Close()
-
-	// Create message (without Topic since writer already has it)
-	message := kafka.Message{
-		Key:   []byte(event.ID),
-		Value: eventBytes,
-		Time:  time.Now(),
+	// Topics whose writers are created and connected before the server starts
+	var warmupTopics []string
+	if v := settings.Get("WARMUP_TOPICS"); v != "" {
+		for _, topic := range strings.Split(v, ",") {
+			if topic = strings.TrimSpace(topic); topic == "" {
+				continue
+			}
+			if !validTopicName.MatchString(topic) {
+				log.Fatalf("Invalid WARMUP_TOPICS: topic name %q is not valid", topic)
+			}
+			warmupTopics = append(warmupTopics, topic)
+		}
 	}
 
-	// Create context with timeout for write operation
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	// Topic that receives messages which could not be produced, replayable via /protected/replay
+	deadLetterTopic := settings.Get("DLQ_TOPIC")
 
-	// Send message with timeout context
-	return writer.WriteMessages(ctx, message)
-}
+	// Optional per-domain events-per-second limits
+	var rateLimiter *DomainRateLimiter
+	if v := settings.Get("DOMAIN_RATE_LIMITS"); v != "" {
+		limits, err := ParseDomainRateLimits(v)
+		if err != nil {
+			log.Fatalf("Invalid DOMAIN_RATE_LIMITS: %v", err)
+		}
+		rateLimiter = NewDomainRateLimiter(limits)
+	}
 
-// SendEvents sends multiple events to Kafka in batches per topic
-func (kp *KafkaProducer) SendEvents(events []Event) map[string]error {
-	// Group events by topic
-	eventsByTopic := make(map[string][]Event)
-	errors := make(map[string]error)
+	// Optional global events-per-second limit shared by every request
+	eventsPerSecondLimit := 0.0 // default value: unlimited
+	if v := settings.Get("EVENTS_PER_SECOND_LIMIT"); v != "" {
+		eventsPerSecondLimit, err = strconv.ParseFloat(v, 64)
+		if err != nil || eventsPerSecondLimit < 0 {
+			log.Fatalf("Invalid EVENTS_PER_SECOND_LIMIT: %q", v)
+		}
+	}
+	throttleMaxWait := time.Second // default value
+	if v := settings.Get("EVENTS_PER_SECOND_MAX_WAIT"); v != "" {
+		throttleMaxWait, err = time.ParseDuration(v)
+		if err != nil || throttleMaxWait < 0 {
+			log.Fatalf("Invalid EVENTS_PER_SECOND_MAX_WAIT: %q", v)
+		}
+	}
 
-	for _, event := range events {
-		topicName := fmt.Sprintf("%s_%s_%s", event.Domain, event.Subdomain, event.Code)
-		eventsByTopic[topicName] = append(eventsByTopic[topicName], event)
+	// How often the stats of the Kafka writers are exported to /metrics
+	writerStatsInterval := 15 * time.Second // default value
+	if v := settings.Get("WRITER_STATS_INTERVAL"); v != "" {
+		writerStatsInterval, err = time.ParseDuration(v)
+		if err != nil || writerStatsInterval < 0 {
+			log.Fatalf("Invalid WRITER_STATS_INTERVAL: %q", v)
+		}
 	}
 
-	// Send events for each topic in batch
-	for topicName, topicEvents := range eventsByTopic {
-		// Create a writer for this specific topic
-		writer := &kafka.Writer{
-			Addr:                   kafka.TCP(kp.brokers...),
-			Topic:                  topicName,
-			Balancer:               &kafka.LeastBytes{},
-			BatchSize:              100,                   // Number of messages per batch
-			BatchBytes:             1048576,               // 1MB batch size
-			BatchTimeout:           10 * time.Millisecond, // 10ms batch timeout
-			WriteTimeout:           10 * time.Second,      // 10 second write timeout
-			ReadTimeout:            10 * time.Second,      // 10 second read timeout
-			RequiredAcks:           kafka.RequireOne,
-			Async:                  true, // Enable async for better batching
-			AllowAutoTopicCreation: true,
-		}
-
-		// Prepare messages for this topic
-		messages := make([]kafka.Message, 0, len(topicEvents))
-		for _, event := range topicEvents {
-			eventBytes, err := json.Marshal(event)
-			if err != nil {
-				errors[event.ID] = fmt.Errorf("failed to marshal event: %w", err)
-				continue
-			}
+	// Accumulate events across requests and produce them from a background flusher
+	accumulateEvents := false // default value
+	if v := settings.Get("ACCUMULATE_EVENTS"); v != "" {
+		accumulateEvents, err = strconv.ParseBool(v)
+		if err != nil {
+			log.Fatalf("Invalid ACCUMULATE_EVENTS: %q", v)
+		}
+	}
+	flushMaxEvents := 1000 // default value
+	if v := settings.Get("FLUSH_MAX_EVENTS"); v != "" {
+		flushMaxEvents, err = strconv.Atoi(v)
+		if err != nil || flushMaxEvents <= 0 {
+			log.Fatalf("Invalid FLUSH_MAX_EVENTS: %q", v)
+		}
+	}
+	accumulateMaxPending := 10 * flushMaxEvents // default value
+	if v := settings.Get("ACCUMULATE_MAX_PENDING"); v != "" {
+		accumulateMaxPending, err = strconv.Atoi(v)
+		if err != nil || accumulateMaxPending < flushMaxEvents {
+			log.Fatalf("Invalid ACCUMULATE_MAX_PENDING: %q", v)
+		}
+	}
+	flushInterval := 100 * time.Millisecond // default value
+	if v := settings.Get("FLUSH_INTERVAL_MS"); v != "" {
+		ms, err := strconv.Atoi(v)
+		if err != nil || ms <= 0 {
+			log.Fatalf("Invalid FLUSH_INTERVAL_MS: %q", v)
+		}
+		flushInterval = time.Duration(ms) * time.Millisecond
+	}
 
-			messages = append(messages, kafka.Message{
-				Key:   []byte(event.ID),
-				Value: eventBytes,
-				Time:  time.Now(),
-			})
+	// Recently produced event IDs remembered by the accumulator to drop duplicates across requests
+	dedupCacheSize := 0 // default value: disabled
+	if v := settings.Get("DEDUP_CACHE_SIZE"); v != "" {
+		dedupCacheSize, err = strconv.Atoi(v)
+		if err != nil || dedupCacheSize < 0 {
+			log.Fatalf("Invalid DEDUP_CACHE_SIZE: %q", v)
+		}
+		if dedupCacheSize > 0 && !accumulateEvents {
+			log.Fatalf("DEDUP_CACHE_SIZE requires ACCUMULATE_EVENTS=true")
 		}
+	}
 
-		// Create context with timeout for write operation
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	// Backpressure for async writes: at most asyncMaxInFlight messages awaiting delivery
+	asyncMaxInFlight := 0 // default value: unlimited
+	if v := settings.Get("ASYNC_MAX_IN_FLIGHT"); v != "" {
+		asyncMaxInFlight, err = strconv.Atoi(v)
+		if err != nil || asyncMaxInFlight < 0 {
+			log.Fatalf("Invalid ASYNC_MAX_IN_FLIGHT: %q", v)
+		}
+		// A limit below the writer's batch size would keep its batches from ever filling up
+		if asyncMaxInFlight > 0 && asyncMaxInFlight < writerBatchSize {
+			log.Fatalf("Invalid ASYNC_MAX_IN_FLIGHT: %q, must be 0 or at least %d", v, writerBatchSize)
+		}
+	}
+	asyncQueuePolicy := QueuePolicyBlock // default value
+	if v := settings.Get("ASYNC_QUEUE_POLICY"); v != "" {
+		switch v {
+		case QueuePolicyBlock, QueuePolicyDrop, QueuePolicyError:
+			asyncQueuePolicy = v
+		default:
+			log.Fatalf("Invalid ASYNC_QUEUE_POLICY: %q", v)
+		}
+	}
+	asyncMaxBytes := 0 // default value: unlimited
+	if v := settings.Get("ASYNC_MAX_BYTES"); v != "" {
+		asyncMaxBytes, err = strconv.Atoi(v)
+		if err != nil || asyncMaxBytes < 0 {
+			log.Fatalf("Invalid ASYNC_MAX_BYTES: %q", v)
+		}
+	}
 
-		// Send all messages for this topic in batch
-		if err := writer.WriteMessages(ctx, messages...); err != nil {
-			errors[topicName] = err
+	// Bound the /events requests served at once; 0 means unlimited
+	maxConcurrentRequests := 0 // default value
+	if v := settings.Get("MAX_CONCURRENT_REQUESTS"); v != "" {
+		maxConcurrentRequests, err = strconv.Atoi(v)
+		if err != nil || maxConcurrentRequests < 0 {
+			log.Fatalf("Invalid MAX_CONCURRENT_REQUESTS: %q", v)
 		}
+	}
+	overloadPolicy := OverloadPolicyQueue // default value
+	if v := settings.Get("OVERLOAD_POLICY"); v != "" {
+		switch v {
+		case OverloadPolicyQueue, OverloadPolicyReject:
+			overloadPolicy = v
+		default:
+			log.Fatalf("Invalid OVERLOAD_POLICY: %q", v)
+		}
+	}
+	overloadQueueTimeout := time.Second // default value
+	if v := settings.Get("OVERLOAD_QUEUE_TIMEOUT"); v != "" {
+		overloadQueueTimeout, err = time.ParseDuration(v)
+		if err != nil || overloadQueueTimeout <= 0 {
+			log.Fatalf("Invalid OVERLOAD_QUEUE_TIMEOUT: %q", v)
+		}
+	}
 
-		cancel()
-		writer.Close()
+	// Replace Kafka with an in-memory writer, to measure the service itself with the load test
+	mockKafka := false // default value
+	if v := settings.Get("MOCK_KAFKA"); v != "" {
+		mockKafka, err = strconv.ParseBool(v)
+		if err != nil {
+			log.Fatalf("Invalid MOCK_KAFKA: %q", v)
+		}
+	}
+	var newWriter WriterFactory
+	var partitionCount PartitionCounter
+	if mockKafka {
+		newWriter = newMockWriter
+		partitionCount = mockPartitionCount
 	}
 
-	return errors
-}
+	// How long shutdown waits for in-flight requests before closing the producer
+	shutdownTimeout := 30 * time.Second // default value
+	if v := settings.Get("SHUTDOWN_TIMEOUT"); v != "" {
+		shutdownTimeout, err = time.ParseDuration(v)
+		if err != nil || shutdownTimeout <= 0 {
+			log.Fatalf("Invalid SHUTDOWN_TIMEOUT: %q", v)
+		}
+	}
+	// How long the producer then waits for its async messages to be delivered
+	drainTimeout := 10 * time.Second // default value
+	if v := settings.Get("DRAIN_TIMEOUT"); v != "" {
+		drainTimeout, err = time.ParseDuration(v)
+		if err != nil || drainTimeout <= 0 {
+			log.Fatalf("Invalid DRAIN_TIMEOUT: %q", v)
+		}
+	}
 
-// validateEvent validates the incoming event
-func validateEvent(event Event) bool {
-	if event.ID == "" || event.Domain == "" || event.Subdomain == "" || event.Code == "" {
-		return false
+	// Local write-ahead log for events that fail to reach Kafka, drained in the background
+	diskBufferDir := settings.Get("DISK_BUFFER_DIR") // default value: empty, disabled
+	diskBufferSegmentBytes := int64(16 << 20)        // default value: 16MB
+	if v := settings.Get("DISK_BUFFER_SEGMENT_BYTES"); v != "" {
+		diskBufferSegmentBytes, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || diskBufferSegmentBytes <= 0 {
+			log.Fatalf("Invalid DISK_BUFFER_SEGMENT_BYTES: %q", v)
+		}
+	}
+	diskBufferMaxBytes := int64(1 << 30) // default value: 1GB
+	if v := settings.Get("DISK_BUFFER_MAX_BYTES"); v != "" {
+		diskBufferMaxBytes, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || diskBufferMaxBytes <= 0 {
+			log.Fatalf("Invalid DISK_BUFFER_MAX_BYTES: %q", v)
+		}
+	}
+	diskBufferRetryInterval := 5 * time.Second // default value
+	if v := settings.Get("DISK_BUFFER_RETRY_INTERVAL"); v != "" {
+		diskBufferRetryInterval, err = time.ParseDuration(v)
+		if err != nil || diskBufferRetryInterval <= 0 {
+			log.Fatalf("Invalid DISK_BUFFER_RETRY_INTERVAL: %q", v)
+		}
 	}
-	return true
-}
 
-func main() {
-	// Get port from environment variable
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080" // default value
+	// In-memory spool retrying events that fail to reach Kafka
+	spoolMaxEvents := 0 // default value: disabled
+	if v := settings.Get("SPOOL_MAX_EVENTS"); v != "" {
+		spoolMaxEvents, err = strconv.Atoi(v)
+		if err != nil || spoolMaxEvents < 0 {
+			log.Fatalf("Invalid SPOOL_MAX_EVENTS: %q", v)
+		}
 	}
 
-	// Get Kafka brokers from environment variable
-	brokersEnv := os.Getenv("KAFKA_BROKERS")
-	if brokersEnv == "" {
-		brokersEnv = "localhost:9092" // default value
+	// File the recent delivery errors are written to on shutdown; empty disables the dump
+	failedEventsDumpFile := settings.Get("FAILED_EVENTS_DUMP_FILE") // default value: empty
+
+	// Append-only NDJSON record of every acknowledged event; empty disables it
+	auditLogFile := settings.Get("AUDIT_LOG_FILE") // default value: empty
+	auditLogMaxMB := 100                           // default value
+	if v := settings.Get("AUDIT_LOG_MAX_MB"); v != "" {
+		auditLogMaxMB, err = strconv.Atoi(v)
+		if err != nil || auditLogMaxMB < 0 {
+			log.Fatalf("Invalid AUDIT_LOG_MAX_MB: %q", v)
+		}
+	}
+
+	// Lite mode for resource-constrained deployments: no metrics or per-topic counts
+	liteMode := false // default value
+	if v := settings.Get("LITE_MODE"); v != "" {
+		liteMode, err = strconv.ParseBool(v)
+		if err != nil {
+			log.Fatalf("Invalid LITE_MODE: %q", v)
+		}
+	}
+	if !liteMode {
+		registerMetrics()
+	}
+
+	// Every setting was read above, so the config file's other keys are typos
+	if unused := settings.Unused(); len(unused) > 0 {
+		log.Fatalf("Unknown settings in CONFIG_FILE %s: %s", configFile, strings.Join(unused, ", "))
 	}
-	brokers := strings.Split(brokersEnv, ",")
 
 	// Create Kafka producer
-	producer := NewKafkaProducer(brokers)
-	defer producer.Close()
+	// Closed after the producer, whose last deliveries are still recorded
+	var auditLog *AuditLog
+	if auditLogFile != "" {
+		auditLog, err = OpenAuditLog(auditLogFile, int64(auditLogMaxMB)<<20)
+		if err != nil {
+			log.Fatalf("Failed to open AUDIT_LOG_FILE: %v", err)
+		}
+		defer func() {
+			if err := auditLog.Close(); err != nil {
+				log.Printf("Failed to close audit log: %v", err)
+			}
+		}()
+	}
+
+	producer := NewKafkaProducer(ProducerConfig{
+		Brokers:                brokers,
+		NewBalancer:            newBalancer,
+		MessageTimeSource:      messageTimeSource,
+		ValueMode:              valueMode,
+		TopicTemplate:          parsedTopicTemplate,
+		FixedTopic:             fixedTopic,
+		KeyTemplate:            parsedKeyTemplate,
+		Keyless:                partitionKey == PartitionKeyNone,
+		PreserveOrder:          preserveOrder,
+		StripFields:            stripFields,
+		ProduceConcurrency:     produceConcurrency,
+		RequiredAcks:           requiredAcks,
+		WriteAttempts:          writeAttempts,
+		WriteTimeout:           writeTimeout,
+		SyncWrites:             syncWrites,
+		BatchTimeout:           batchTimeout,
+		Compression:            compression,
+		Transport:              transport,
+		TopicPartitions:        topicPartitions,
+		ReplicationFactor:      replicationFactor,
+		AllowAutoTopicCreation: allowAutoTopicCreation,
+		AllowedTopics:          allowedTopics,
+		DefaultTopic:           defaultTopic,
+		DeadLetterTopic:        deadLetterTopic,
+		LiteMode:               liteMode,
+		NewWriter:              newWriter,
+		PartitionCount:         partitionCount,
+		MaxInFlight:            asyncMaxInFlight,
+		QueuePolicy:            asyncQueuePolicy,
+		MaxInFlightBytes:       asyncMaxBytes,
+		EventsPerSecond:        eventsPerSecondLimit,
+		ThrottleMaxWait:        throttleMaxWait,
+		WriterStatsInterval:    writerStatsInterval,
+		Enricher:               enricher,
+		AuditLog:               auditLog,
+	})
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+		report, err := producer.Shutdown(ctx)
+		cancel()
+		if err != nil {
+			log.Printf("Failed to close producer: %v", err)
+		}
+		fmt.Printf("Shutdown drain: %d messages in flight, %d delivered, %d failed, %d dropped\n",
+			report.InFlight, report.Delivered, report.Failed, report.Dropped)
+		if report.Dropped > 0 {
+			log.Printf("%d messages were dropped because the drain timed out, exiting with status 1", report.Dropped)
+			exitCode = 1
+		}
+		// Best effort: delivery failures reported while closing are included
+		if failedEventsDumpFile != "" {
+			n, err := producer.DumpDeliveryErrors(failedEventsDumpFile)
+			if err != nil {
+				log.Printf("Failed to dump delivery errors to %s: %v", failedEventsDumpFile, err)
+			} else if n > 0 {
+				log.Printf("Dumped %d delivery errors to %s", n, failedEventsDumpFile)
+			}
+		}
+	}()
+
+	if timeoutBackpressureThreshold > 0 {
+		producer.shedder = NewLoadShedder(timeoutBackpressureThreshold, timeoutBackpressureWindow, timeoutBackpressureCooldown, liteMode)
+	}
+
+	// Closed after the accumulator, whose last flush may still buffer events
+	if diskBufferDir != "" {
+		producer.diskBuffer, err = OpenDiskBuffer(diskBufferDir, diskBufferSegmentBytes, diskBufferMaxBytes, diskBufferRetryInterval, producer, breaker)
+		if err != nil {
+			log.Fatalf("Failed to open DISK_BUFFER_DIR: %v", err)
+		}
+		defer producer.diskBuffer.Close()
+	}
+
+	// Closed before the disk buffer, which takes the events left in it
+	if spoolMaxEvents > 0 {
+		producer.spool = NewSpool(producer, breaker, spoolMaxEvents)
+		defer producer.spool.Close()
+	}
+
+	var accumulator *Accumulator
+	if accumulateEvents {
+		var dedup *DedupCache
+		if dedupCacheSize > 0 {
+			dedup = NewDedupCache(dedupCacheSize)
+		}
+		accumulator = NewAccumulator(producer, breaker, flushMaxEvents, accumulateMaxPending, flushInterval, dedup)
+		defer accumulator.Close()
+	}
 
 	// Create gin router
-	r := gin.Default()
+	requests := &requestTracker{}
+	r := gin.New()
+	r.Use(gin.LoggerWithFormatter(accessLogFormatter), gin.Recovery(), requests.Middleware())
 
 	// Health check endpoint
 	r.GET("/protected/health", func(c *gin.Context) {
@@ -208,56 +2100,308 @@ func main() {
 		})
 	})
 
-	// Events endpoint
-	r.POST("/events", func(c *gin.Context) {
-		var events []Event
-		if err := c.ShouldBindJSON(&events); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"error": "Invalid JSON format",
+	// Liveness endpoint: the process is up and serving requests, Kafka is not checked
+	r.GET("/protected/live", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"live": true})
+	})
+
+	// Startup endpoint: succeeds once Kafka was reached or a message was delivered.
+	// With MOCK_KAFKA there is no Kafka to reach, so the service starts immediately.
+	r.GET("/protected/startup", func(c *gin.Context) {
+		if !mockKafka && !producer.Started() {
+			ctx, cancel := context.WithTimeout(c.Request.Context(), probeTimeout)
+			defer cancel()
+			if err := producer.Ping(ctx); err != nil {
+				c.JSON(http.StatusServiceUnavailable, gin.H{
+					"started": false,
+					"error":   err.Error(),
+				})
+				return
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{"started": true})
+	})
+
+	// Readiness endpoint: not ready while a broker is unreachable, the circuit
+	// breaker rejects Kafka writes or load is shed after write timeouts
+	r.GET("/protected/ready", func(c *gin.Context) {
+		status := http.StatusOK
+		body := gin.H{"breaker": breaker.State()}
+		ready := breaker.Ready()
+		if producer.shedder != nil {
+			shedding := producer.shedder.RetryAfter() > 0
+			body["shedding"] = shedding
+			ready = ready && !shedding
+		}
+		if !mockKafka {
+			ctx, cancel := context.WithTimeout(c.Request.Context(), probeTimeout)
+			defer cancel()
+			if err := producer.Ping(ctx); err != nil {
+				ready = false
+				body["error"] = err.Error()
+			}
+		}
+		if !ready {
+			status = http.StatusServiceUnavailable
+		}
+		body["ready"] = ready
+		c.JSON(status, body)
+	})
+
+	// Self-test endpoint: produces a probe message to __selftest and consumes it
+	// back. Rate limited, since every call writes to the cluster; with MOCK_KAFKA
+	// there is no cluster to test.
+	selfTestLimiter := rate.NewLimiter(rate.Every(selfTestInterval), 1)
+	r.GET("/protected/selftest", func(c *gin.Context) {
+		if reservation := selfTestLimiter.Reserve(); reservation.Delay() > 0 {
+			retryAfter := reservation.Delay()
+			reservation.Cancel()
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"error": fmt.Sprintf("Self-test can run once every %v", selfTestInterval),
 			})
 			return
 		}
+		if mockKafka {
+			c.JSON(http.StatusOK, gin.H{"ok": true, "mock": true})
+			return
+		}
 
-		response := EventResponse{
-			SuccessEventIds: []string{},
-			InvalidEventIds: []string{},
-			FailedEventIds:  []string{},
+		ctx, cancel := context.WithTimeout(c.Request.Context(), selfTestTimeout)
+		defer cancel()
+
+		result := producer.SelfTest(ctx)
+		status := http.StatusOK
+		if !result.OK {
+			status = http.StatusServiceUnavailable
 		}
+		c.JSON(status, result)
+	})
 
-		// Validate events first
-		validEvents := []Event{}
-		for _, event := range events {
-			if !validateEvent(event) {
-				response.InvalidEventIds = append(response.InvalidEventIds, event.ID)
-				continue
-			}
-			validEvents = append(validEvents, event)
-		}
-
-		// Send valid events in batch
-		if len(validEvents) > 0 {
-			errors := producer.SendEvents(validEvents)
-
-			// Process results
-			for _, event := range validEvents {
-				eventID := event.ID
-				topicName := fmt.Sprintf("%s_%s_%s", event.Domain, event.Subdomain, event.Code)
-
-				// Check for individual event errors (marshaling errors)
-				if err, exists := errors[eventID]; exists {
-					log.Printf("Error processing event with ID %s: %v", eventID, err)
-					response.FailedEventIds = append(response.FailedEventIds, eventID)
-				} else if err, exists := errors[topicName]; exists {
-					// Check for topic-level errors (sending errors)
-					log.Printf("Error sending event with ID %s to topic %s: %v", eventID, topicName, err)
-					response.FailedEventIds = append(response.FailedEventIds, eventID)
-				} else {
-					response.SuccessEventIds = append(response.SuccessEventIds, eventID)
-				}
+	// Prometheus metrics endpoint
+	if !liteMode {
+		r.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	}
+
+	// Effective configuration, to confirm what a deployment is actually running with
+	runtimeConfig := RuntimeConfig{
+		Port:                    port,
+		ConfigFile:              configFile,
+		LogLevel:                settings.LogLevel(),
+		Brokers:                 brokers,
+		PartitionBalancer:       balancerName,
+		DurabilityProfile:       durabilityProfile,
+		RequiredAcks:            requiredAcks.String(),
+		WriteAttempts:           writeAttempts,
+		WriteTimeout:            writeTimeout.String(),
+		SyncWrites:              syncWrites,
+		Compression:             compression.String(),
+		ZstdDictFile:            zstdDictFile,
+		DialTimeout:             dialTimeout.String(),
+		MaxConnsPerBroker:       maxConnsPerBroker,
+		BrokerAddressMap:        brokerAddressMap,
+		IdleTimeout:             idleTimeout.String(),
+		BatchSize:               writerBatchSize,
+		BatchBytes:              writerBatchBytes,
+		BatchTimeout:            batchTimeout.String(),
+		FlushOnBatchComplete:    flushOnBatchComplete,
+		TopicTemplate:           topicTemplate,
+		FixedTopic:              fixedTopic,
+		TopicPartitions:         topicPartitions,
+		ReplicationFactor:       replicationFactor,
+		AllowAutoTopicCreation:  allowAutoTopicCreation,
+		DefaultTopic:            defaultTopic,
+		WarmupTopics:            warmupTopics,
+		DeadLetterTopic:         deadLetterTopic,
+		ValueMode:               valueMode,
+		KeyTemplate:             keyTemplate,
+		PartitionKey:            partitionKey,
+		PreserveOrder:           preserveOrder,
+		MessageTimeSource:       messageTimeSource,
+		ProduceConcurrency:      produceConcurrency,
+		MaxRequestBytes:         maxRequestBytes,
+		GzipMinBytes:            responseCompressionMinBytes,
+		StrictJSON:              strictJSON,
+		VerboseErrors:           verboseErrors,
+		AllowEmptyBatch:         allowEmptyBatch,
+		PartialStatusCode:       partialStatus,
+		EventIDField:            eventIDField,
+		PayloadMustBeJSON:       payloadMustBeJSON,
+		PositiveIntFields:       positiveFields,
+		ForwardHeaders:          forwardHeaders,
+		EnableEnrichment:        len(enrichers) > 0,
+		EventEnrichers:          enricherNames,
+		DomainRateLimits:        settings.Get("DOMAIN_RATE_LIMITS"),
+		IdempotencyEnabled:      idempotencyEnabled,
+		IdempotencyCacheSize:    idempotencyCacheSize,
+		IdempotencyTTL:          idempotencyTTL.String(),
+		BreakerFailureThreshold: breakerThreshold,
+		BreakerCooldown:         breakerCooldown.String(),
+		AccumulateEvents:        accumulateEvents,
+		FlushMaxEvents:          flushMaxEvents,
+		AccumulateMaxPending:    accumulateMaxPending,
+		FlushInterval:           flushInterval.String(),
+		DedupCacheSize:          dedupCacheSize,
+		ShutdownTimeout:         shutdownTimeout.String(),
+		DrainTimeout:            drainTimeout.String(),
+		FailedEventsDumpFile:    failedEventsDumpFile,
+		SpoolMaxEvents:          spoolMaxEvents,
+		LiteMode:                liteMode,
+		WriterStatsInterval:     writerStatsInterval.String(),
+	}
+	if slices.Contains(enricherNames, EnricherSourceInstance) {
+		runtimeConfig.SourceInstance = sourceInstance
+	}
+	if eventTimeFormat != nil {
+		runtimeConfig.EventTimeFormat = eventTimeFormat.String()
+	}
+	if timeoutBackpressureThreshold > 0 {
+		runtimeConfig.TimeoutBackpressureThreshold = timeoutBackpressureThreshold
+		runtimeConfig.TimeoutBackpressureWindow = timeoutBackpressureWindow.String()
+		runtimeConfig.TimeoutBackpressureCooldown = timeoutBackpressureCooldown.String()
+	}
+	if maxConcurrentRequests > 0 {
+		runtimeConfig.MaxConcurrentRequests = maxConcurrentRequests
+		runtimeConfig.OverloadPolicy = overloadPolicy
+		if overloadPolicy == OverloadPolicyQueue {
+			runtimeConfig.OverloadQueueTimeout = overloadQueueTimeout.String()
+		}
+	}
+	if diskBufferDir != "" {
+		runtimeConfig.DiskBufferDir = diskBufferDir
+		runtimeConfig.DiskBufferSegmentBytes = diskBufferSegmentBytes
+		runtimeConfig.DiskBufferMaxBytes = diskBufferMaxBytes
+		runtimeConfig.DiskBufferRetryInterval = diskBufferRetryInterval.String()
+	}
+	if auditLogFile != "" {
+		runtimeConfig.AuditLogFile = auditLogFile
+		runtimeConfig.AuditLogMaxMB = auditLogMaxMB
+	}
+	if eventsPerSecondLimit > 0 {
+		runtimeConfig.EventsPerSecondLimit = eventsPerSecondLimit
+		runtimeConfig.EventsPerSecondMaxWait = throttleMaxWait.String()
+	}
+	for field := range stripFields {
+		runtimeConfig.StripFields = append(runtimeConfig.StripFields, field)
+	}
+	sort.Strings(runtimeConfig.StripFields)
+	for topic := range allowedTopics {
+		runtimeConfig.AllowedTopics = append(runtimeConfig.AllowedTopics, topic)
+	}
+	sort.Strings(runtimeConfig.AllowedTopics)
+	r.GET("/protected/config", func(c *gin.Context) {
+		c.JSON(http.StatusOK, runtimeConfig)
+	})
+
+	// Recent async delivery failures, newest first
+	r.GET("/protected/errors", func(c *gin.Context) {
+		deliveryErrors := producer.DeliveryErrors()
+		c.JSON(http.StatusOK, gin.H{
+			"count":  len(deliveryErrors),
+			"errors": deliveryErrors,
+		})
+	})
+
+	// Topics produced to since startup with per-topic message counts
+	if !liteMode {
+		r.GET("/protected/topics", func(c *gin.Context) {
+			c.JSON(http.StatusOK, gin.H{
+				"topics": producer.Topics(),
+			})
+		})
+	}
+
+	// Flush endpoint: waits for async writers to drain their buffered batches
+	r.POST("/protected/flush", func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
+		defer cancel()
+
+		result := producer.Flush(ctx)
+		status := http.StatusOK
+		if result.Errored > 0 {
+			status = http.StatusGatewayTimeout
+		}
+		c.JSON(status, result)
+	})
+
+	// Events endpoint
+	eventsHandler := &EventsHandler{
+		producer:         producer,
+		strictJSON:       strictJSON,
+		enableEnrichment: len(enrichers) > 0,
+		idempotency:      idempotency,
+		breaker:          breaker,
+		shedder:          producer.shedder,
+		accumulator:      accumulator,
+		rateLimiter:      rateLimiter,
+		partialStatus:    partialStatus,
+		payloadJSON:      payloadMustBeJSON,
+		positiveFields:   positiveFields,
+		eventTimeFormat:  eventTimeFormat,
+		verboseErrors:    verboseErrors,
+		allowEmptyBatch:  allowEmptyBatch,
+		forwardHeaders:   forwardHeaders,
+		stats:            NewServerStats(),
+
+		flushOnBatchComplete: flushOnBatchComplete,
+	}
+	if eventIDField != "id" {
+		eventsHandler.idField = eventIDField
+	}
+	eventsMiddleware := []gin.HandlerFunc{maxBodyBytes(maxRequestBytes)}
+	if maxConcurrentRequests > 0 {
+		limiter := newConcurrencyLimiter(maxConcurrentRequests, overloadPolicy, overloadQueueTimeout, liteMode)
+		eventsMiddleware = append(eventsMiddleware, limiter.Middleware())
+	}
+	if responseCompressionMinBytes > 0 {
+		eventsMiddleware = append(eventsMiddleware, gzipResponses(responseCompressionMinBytes))
+	}
+	r.POST("/events", append(eventsMiddleware, eventsHandler.Handle)...)
+	r.POST("/events/validate", append(eventsMiddleware, eventsHandler.Validate)...)
+	if accumulator != nil {
+		r.GET("/events/tickets/:ticket", eventsHandler.Ticket)
+	}
+
+	// Request and event totals of POST /events since startup
+	r.GET("/protected/stats", func(c *gin.Context) {
+		c.JSON(http.StatusOK, eventsHandler.stats.Snapshot())
+	})
+
+	// Replay endpoint: produces the events of an uploaded NDJSON file, or re-produces
+	// dead-lettered messages to their original topic
+	r.POST("/protected/replay", func(c *gin.Context) {
+		if c.ContentType() == "multipart/form-data" {
+			eventsHandler.ReplayFile(c)
+			return
+		}
+		if deadLetterTopic == "" {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "DLQ_TOPIC is not set, upload an NDJSON file as multipart/form-data to replay events",
+			})
+			return
+		}
+
+		max := 100 // default value
+		if v := c.Query("max"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": "Invalid max, expected a positive number",
+				})
+				return
 			}
+			max = n
 		}
 
-		c.JSON(http.StatusOK, response)
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 60*time.Second)
+		defer cancel()
+
+		result := producer.Replay(ctx, max)
+		status := http.StatusOK
+		if result.Failed > 0 {
+			status = http.StatusBadGateway
+		}
+		c.JSON(status, result)
 	})
 
 	// Convert port string to int for logging
@@ -268,10 +2412,187 @@ func main() {
 
 	// Log startup information
 	log.Printf("Starting server on port %d", portInt)
+	if configFile != "" {
+		log.Printf("Config file: %s (environment variables override it)", configFile)
+	}
+	log.Printf("Log level: %s", settings.LogLevel())
+	if liteMode {
+		log.Printf("Lite mode: metrics and topic counts disabled")
+	} else if writerStatsInterval > 0 {
+		log.Printf("Writer stats: exported every %v", writerStatsInterval)
+	}
+	if mockKafka {
+		log.Printf("MOCK_KAFKA: messages are discarded, nothing is written to Kafka")
+	}
 	log.Printf("Kafka brokers: %v", brokers)
+	log.Printf("Partition balancer: %s", balancerName)
+	if maxConnsPerBroker > 0 {
+		log.Printf("Kafka connections: dial timeout %v, idle timeout %v, at most %d per broker", dialTimeout, idleTimeout, maxConnsPerBroker)
+	} else {
+		log.Printf("Kafka connections: dial timeout %v, idle timeout %v, unlimited per broker", dialTimeout, idleTimeout)
+	}
+	if len(brokerAddressMap) > 0 {
+		log.Printf("Broker address map: %v", brokerAddressMap)
+	}
+	log.Printf("Durability profile: %s", durabilityProfile)
+	log.Printf("Required acks: %s", requiredAcks)
+	log.Printf("Kafka writes: %d attempts, %v timeout per attempt", writeAttempts, writeTimeout)
+	if syncWrites {
+		log.Printf("Sync writes: /events waits for the broker unless X-Sync: false")
+	}
+	log.Printf("Writer batch timeout: %v", batchTimeout)
+	log.Printf("Flush on batch complete: %t", flushOnBatchComplete)
+	if zstdDictFile != "" {
+		log.Printf("Compression: %s with dictionary %s", compression, zstdDictFile)
+	} else {
+		log.Printf("Compression: %s", compression)
+	}
+	log.Printf("Message value mode: %s", valueMode)
+	if fixedTopic != "" {
+		log.Printf("Fixed topic: %s", fixedTopic)
+	} else {
+		log.Printf("Topic template: %s", topicTemplate)
+	}
+	if keyTemplate != "" {
+		log.Printf("Message key template: %s", keyTemplate)
+	}
+	log.Printf("Partition key: %s", partitionKey)
+	log.Printf("Preserve order: %t", preserveOrder)
+	if len(stripFields) > 0 {
+		log.Printf("Stripped event fields: %s", settings.Get("STRIP_FIELDS"))
+	}
+	log.Printf("Message time source: %s", messageTimeSource)
+	log.Printf("Produce concurrency: %d topics", produceConcurrency)
+	if asyncMaxInFlight > 0 {
+		log.Printf("Async queue: %d messages in flight, policy %s", asyncMaxInFlight, asyncQueuePolicy)
+	}
+	if asyncMaxBytes > 0 {
+		log.Printf("Async queue: %d bytes in flight", asyncMaxBytes)
+	}
+	if maxConcurrentRequests == 0 {
+		log.Printf("Max concurrent requests: unlimited")
+	} else if overloadPolicy == OverloadPolicyQueue {
+		log.Printf("Max concurrent requests: %d, queued for up to %v", maxConcurrentRequests, overloadQueueTimeout)
+	} else {
+		log.Printf("Max concurrent requests: %d, rejected over the limit", maxConcurrentRequests)
+	}
+	log.Printf("Auto topic creation: %t", allowAutoTopicCreation)
+	if topicPartitions > 0 {
+		log.Printf("New topics: %d partitions, replication factor %d", topicPartitions, replicationFactor)
+	}
+	if allowedTopics != nil {
+		if defaultTopic != "" {
+			log.Printf("Allowed topics: %d, others go to %s", len(allowedTopics), defaultTopic)
+		} else {
+			log.Printf("Allowed topics: %d, others are rejected", len(allowedTopics))
+		}
+	}
+	if deadLetterTopic != "" {
+		log.Printf("Dead-letter topic: %s", deadLetterTopic)
+	}
+	if spoolMaxEvents > 0 {
+		log.Printf("Spool: holds up to %d events for retry", spoolMaxEvents)
+	}
+	if diskBufferDir != "" {
+		log.Printf("Disk buffer: %s (segments of %d bytes, at most %d bytes, retry every %v)",
+			diskBufferDir, diskBufferSegmentBytes, diskBufferMaxBytes, diskBufferRetryInterval)
+	}
+	log.Printf("Max request body: %d bytes", maxRequestBytes)
+	if responseCompressionMinBytes > 0 {
+		log.Printf("Response compression: gzip for bodies of at least %d bytes", responseCompressionMinBytes)
+	} else {
+		log.Printf("Response compression: disabled")
+	}
+	log.Printf("Strict JSON: %t", strictJSON)
+	log.Printf("Verbose errors: %t", verboseErrors)
+	log.Printf("Allow empty batch: %t", allowEmptyBatch)
+	log.Printf("Partial status code: %t", partialStatus)
+	log.Printf("Event ID field: %s", eventIDField)
+	log.Printf("Payload must be JSON: %t", payloadMustBeJSON)
+	if len(positiveFields) > 0 {
+		log.Printf("Positive int fields: %s", strings.Join(positiveFields, ","))
+	}
+	if len(forwardHeaders) > 0 {
+		log.Printf("Forwarded headers: %s", strings.Join(forwardHeaders, ","))
+	}
+	if eventTimeFormat != nil {
+		log.Printf("Event time format: %s", eventTimeFormat)
+	}
+	if len(enrichers) > 0 {
+		log.Printf("Event enrichment: %s", strings.Join(enricherNames, ","))
+	} else {
+		log.Printf("Event enrichment: disabled")
+	}
+	if breakerThreshold > 0 {
+		log.Printf("Circuit breaker: opens after %d consecutive failures for %v", breakerThreshold, breakerCooldown)
+	} else {
+		log.Printf("Circuit breaker: disabled")
+	}
+	if timeoutBackpressureThreshold > 0 {
+		log.Printf("Timeout backpressure: sheds load for %v after %d write timeouts within %v", timeoutBackpressureCooldown, timeoutBackpressureThreshold, timeoutBackpressureWindow)
+	}
+	if rateLimiter != nil {
+		log.Printf("Domain rate limits: %s", settings.Get("DOMAIN_RATE_LIMITS"))
+	}
+	if eventsPerSecondLimit > 0 {
+		log.Printf("Events per second limit: %g, waiting at most %v", eventsPerSecondLimit, throttleMaxWait)
+	}
+	if accumulateEvents {
+		log.Printf("Event accumulation: flush every %v or %d events, at most %d pending", flushInterval, flushMaxEvents, accumulateMaxPending)
+	}
+	if dedupCacheSize > 0 {
+		log.Printf("Dedup cache: last %d produced event IDs", dedupCacheSize)
+	}
+	if idempotencyEnabled {
+		log.Printf("Idempotency keys: up to %d keys for %v", idempotencyCacheSize, idempotencyTTL)
+	}
+
+	log.Printf("Shutdown timeout: %v, drain timeout: %v", shutdownTimeout, drainTimeout)
+	if failedEventsDumpFile != "" {
+		log.Printf("Delivery errors are dumped to %s on shutdown", failedEventsDumpFile)
+	}
+	if auditLogFile != "" {
+		log.Printf("Audit log: %s, rotated at %d MB", auditLogFile, auditLogMaxMB)
+	}
+
+	// Pay the first write's metadata and connection cost before taking requests
+	if len(warmupTopics) > 0 {
+		start := time.Now()
+		ctx, cancel := context.WithTimeout(context.Background(), warmupTimeout)
+		failed := producer.Warmup(ctx, warmupTopics)
+		cancel()
+		for topic, err := range failed {
+			log.Printf("Failed to warm up topic %s: %v", topic, err)
+		}
+		log.Printf("Warmup finished: %d of %d topics ready in %v", len(warmupTopics)-len(failed), len(warmupTopics), time.Since(start).Round(time.Millisecond))
+	}
 
 	// Start server
-	if err := r.Run(":" + port); err != nil {
+	srv := &http.Server{Addr: ":" + port, Handler: r}
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- srv.ListenAndServe()
+	}()
+
+	// On SIGINT or SIGTERM stop accepting requests and wait for the in-flight ones,
+	// then return so the accumulator and producer are flushed and closed
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	select {
+	case err := <-serverErr:
 		log.Fatal("Failed to start server:", err)
+	case sig := <-stop:
+		log.Printf("Received %v, draining %d in-flight requests", sig, requests.InFlight())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	go func() {
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Printf("HTTP server shutdown: %v", err)
+		}
+	}()
+	if requests.drain(ctx) {
+		log.Printf("All requests drained, closing the producer")
 	}
 }
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Prometheus metrics exposed on /metrics
+var (
+	// producerErrorsTotal counts messages that failed to reach Kafka. The stage label
+	// separates errors returned by WriteMessages ("write") from failures reported
+	// later by the async Completion callback ("delivery").
+	producerErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kafka_producer_errors_total",
+		Help: "Number of messages that failed to be produced to Kafka.",
+	}, []string{"topic", "stage"})
+
+	// producedMessagesTotal counts messages handed to the Kafka writers per topic
+	producedMessagesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kafka_producer_messages_total",
+		Help: "Number of messages produced to Kafka.",
+	}, []string{"topic"})
+
+	// splitBatchesTotal counts topic batches written in more than one sub-batch
+	// because they were larger than the writer's batch bytes or ASYNC_MAX_IN_FLIGHT
+	splitBatchesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kafka_producer_split_batches_total",
+		Help: "Number of topic batches split into sub-batches to fit the writer's batch bytes or the in-flight limit.",
+	}, []string{"topic"})
+
+	// droppedMessagesTotal counts messages dropped by ASYNC_QUEUE_POLICY=drop
+	droppedMessagesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kafka_producer_dropped_messages_total",
+		Help: "Number of messages dropped because the async writer queue was full.",
+	}, []string{"topic"})
+
+	// produceLatencySeconds times the SendEvents call of each request, from handing
+	// the events to the writers until they were produced or failed
+	produceLatencySeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "produce_latency_seconds",
+		Help:    "Time taken to produce the valid events of a request to Kafka.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"outcome"})
+
+	// validationLatencySeconds times normalizing, validating, rate limiting and
+	// enriching the events of a request
+	validationLatencySeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "validation_latency_seconds",
+		Help:    "Time taken to validate the events of a request.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"outcome"})
+
+	// diskBufferEventsTotal counts events stored in the disk buffer ("buffered"),
+	// produced from it ("drained") and given up on while draining ("dropped")
+	diskBufferEventsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "disk_buffer_events_total",
+		Help: "Number of events that passed through the disk buffer, by stage.",
+	}, []string{"stage"})
+
+	// diskBufferBytes is the size of the segments waiting in the disk buffer
+	diskBufferBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "disk_buffer_bytes",
+		Help: "Size of the events waiting in the disk buffer.",
+	})
+
+	// spoolDepth is the number of events held in the in-memory spool for retry
+	spoolDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "spool_depth",
+		Help: "Number of events waiting in the in-memory spool.",
+	})
+
+	// throttleEventsPerSecond is the rate of events admitted by EVENTS_PER_SECOND_LIMIT
+	// over the last second
+	throttleEventsPerSecond = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "throttle_events_per_second",
+		Help: "Events per second admitted by the global events per second limit.",
+	})
+
+	// throttledEventsTotal counts events rejected by EVENTS_PER_SECOND_LIMIT
+	throttledEventsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "throttled_events_total",
+		Help: "Number of events rejected by the global events per second limit.",
+	})
+
+	// eventTimeFallbacksTotal counts MESSAGE_TIME_SOURCE=event messages stamped with
+	// the current time because the event had no usable eventtimestamp or eventtime
+	eventTimeFallbacksTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "event_time_fallbacks_total",
+		Help: "Number of messages timestamped with the current time because the event time was not usable.",
+	})
+
+	// dedupDroppedEventsTotal counts accumulated events dropped as duplicates by DEDUP_CACHE_SIZE
+	dedupDroppedEventsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "dedup_dropped_events_total",
+		Help: "Number of accumulated events dropped because their ID was produced recently.",
+	})
+
+	// domainEventsTotal counts valid events per domain, by whether DOMAIN_RATE_LIMITS
+	// let them through ("allowed") or rejected them ("rate_limited")
+	domainEventsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "domain_events_total",
+		Help: "Number of valid events received per domain.",
+	}, []string{"domain", "outcome"})
+
+	// asyncQueueBytes is the size of the messages handed to async writers and not completed yet
+	asyncQueueBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "async_queue_bytes",
+		Help: "Bytes of async messages awaiting delivery, tracked when ASYNC_MAX_BYTES is set.",
+	})
+
+	// loadShedding is 1 while /events requests are shed after repeated Kafka write timeouts
+	loadShedding = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "load_shedding",
+		Help: "Whether requests are being shed because Kafka writes keep timing out (1) or not (0).",
+	})
+
+	// loadShedRequestsTotal counts /events requests rejected while shedding load
+	loadShedRequestsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "load_shed_requests_total",
+		Help: "Number of requests rejected with 503 because Kafka writes keep timing out.",
+	})
+
+	// concurrentRequests is the number of /events requests being served, tracked
+	// when MAX_CONCURRENT_REQUESTS is set
+	concurrentRequests = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "concurrent_requests",
+		Help: "Number of /events requests being served, tracked when MAX_CONCURRENT_REQUESTS is set.",
+	})
+
+	// overloadRejectedRequestsTotal counts /events requests rejected over MAX_CONCURRENT_REQUESTS
+	overloadRejectedRequestsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "overload_rejected_requests_total",
+		Help: "Number of requests rejected with 503 because MAX_CONCURRENT_REQUESTS were already being served.",
+	})
+
+	// kafka_writer_* metrics are read from kafka.Writer.Stats every WRITER_STATS_INTERVAL,
+	// labelled by the writer's topic and ack level. The gauges are averages over the
+	// last interval in which the writer wrote a batch.
+	writerWritesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kafka_writer_writes_total",
+		Help: "Number of produce requests sent by the Kafka writers.",
+	}, writerStatsLabels)
+	writerMessagesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kafka_writer_messages_total",
+		Help: "Number of messages written by the Kafka writers.",
+	}, writerStatsLabels)
+	writerBytesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kafka_writer_bytes_total",
+		Help: "Number of message bytes written by the Kafka writers.",
+	}, writerStatsLabels)
+	writerErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kafka_writer_errors_total",
+		Help: "Number of errors reported by the Kafka writers.",
+	}, writerStatsLabels)
+	writerRetriesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "kafka_writer_retries_total",
+		Help: "Number of produce requests retried by the Kafka writers.",
+	}, writerStatsLabels)
+	writerBatchSizeAvg = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kafka_writer_batch_size",
+		Help: "Average number of messages per batch.",
+	}, writerStatsLabels)
+	writerBatchBytesAvg = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kafka_writer_batch_bytes",
+		Help: "Average size of a batch in bytes.",
+	}, writerStatsLabels)
+	writerBatchSecondsAvg = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kafka_writer_batch_seconds",
+		Help: "Average time taken to fill a batch.",
+	}, writerStatsLabels)
+	writerBatchQueueSecondsAvg = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kafka_writer_batch_queue_seconds",
+		Help: "Average time a batch waited before being written.",
+	}, writerStatsLabels)
+	writerWriteSecondsAvg = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kafka_writer_write_seconds",
+		Help: "Average time taken to write a batch to Kafka.",
+	}, writerStatsLabels)
+	// Unlike the other kafka_writer_* metrics, counted by the producer itself
+	writerPendingMessages = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "kafka_writer_pending_messages",
+		Help: "Number of async messages handed to the writer whose batch has not completed yet.",
+	}, writerStatsLabels)
+)
+
+// writerStatsLabels are the labels of the kafka_writer_* metrics
+var writerStatsLabels = []string{"topic", "acks"}
+
+// Values of the outcome label of domain_events_total
+const (
+	domainOutcomeAllowed     = "allowed"
+	domainOutcomeRateLimited = "rate_limited"
+)
+
+// Values of the stage label of disk_buffer_events_total
+const (
+	diskBufferStageBuffered = "buffered"
+	diskBufferStageDrained  = "drained"
+	diskBufferStageDropped  = "dropped"
+)
+
+// Values of the outcome label of the latency histograms
+const (
+	latencyOutcomeSuccess = "success" // every event passed
+	latencyOutcomePartial = "partial" // at least one event was rejected or failed
+)
+
+// registerMetrics adds the metrics to the default Prometheus registry. It is
+// skipped in lite mode, where the metrics are never updated either.
+func registerMetrics() {
+	prometheus.MustRegister(
+		producerErrorsTotal,
+		producedMessagesTotal,
+		droppedMessagesTotal,
+		splitBatchesTotal,
+		produceLatencySeconds,
+		validationLatencySeconds,
+		diskBufferEventsTotal,
+		diskBufferBytes,
+		spoolDepth,
+		throttleEventsPerSecond,
+		throttledEventsTotal,
+		eventTimeFallbacksTotal,
+		dedupDroppedEventsTotal,
+		domainEventsTotal,
+		asyncQueueBytes,
+		loadShedding,
+		loadShedRequestsTotal,
+		concurrentRequests,
+		overloadRejectedRequestsTotal,
+		writerWritesTotal,
+		writerMessagesTotal,
+		writerBytesTotal,
+		writerErrorsTotal,
+		writerRetriesTotal,
+		writerBatchSizeAvg,
+		writerBatchBytesAvg,
+		writerBatchSecondsAvg,
+		writerBatchQueueSecondsAvg,
+		writerWriteSecondsAvg,
+		writerPendingMessages,
+	)
+}
+
+// observeLatency records the time since start in histogram, labelled by whether
+// every event of the request passed
+func observeLatency(histogram *prometheus.HistogramVec, start time.Time, partial bool) {
+	outcome := latencyOutcomeSuccess
+	if partial {
+		outcome = latencyOutcomePartial
+	}
+	histogram.WithLabelValues(outcome).Observe(time.Since(start).Seconds())
+}
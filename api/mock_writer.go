@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// mockWriter is an in-memory MessageWriter that accepts every message and discards
+// it, so the service can be benchmarked (e.g. with the load test) without a cluster
+type mockWriter struct {
+	completion func(messages []kafka.Message, err error)
+}
+
+// newMockWriter is a WriterFactory for mockWriter
+func newMockWriter(topicName string, acks kafka.RequiredAcks, sync bool, completion func(messages []kafka.Message, err error)) MessageWriter {
+	return &mockWriter{completion: completion}
+}
+
+// WriteMessages reports the messages as delivered right away, like an async writer would once acked
+func (w *mockWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	w.completion(msgs, nil)
+	return nil
+}
+
+func (w *mockWriter) Close() error {
+	return nil
+}
+
+// mockTopicPartitions is the partition count mockPartitionCount reports for every topic
+const mockTopicPartitions = 3
+
+// mockPartitionCount is the PartitionCounter used with mockWriter, which has no topics to look up
+func mockPartitionCount(ctx context.Context, topicName string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, fmt.Errorf("failed to look up topic %s: %w", topicName, err)
+	}
+	return mockTopicPartitions, nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// NumericID is a 64-bit integer event field such as customerid. Besides JSON
+// numbers it accepts string-encoded integers like "100537117", which clients send
+// for IDs beyond the range JavaScript numbers hold exactly. It is always produced
+// as a JSON number.
+type NumericID int64
+
+// UnmarshalJSON accepts a JSON integer or a string holding one; anything else is
+// a numericIDError, which invalidNumericID turns into the reason the event holding
+// the value is rejected
+func (n *NumericID) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		return nil
+	}
+	text := data
+	kind := "number " + string(data)
+	if len(data) > 0 && data[0] == '"' {
+		var s string
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		text = bytes.TrimSpace([]byte(s))
+		kind = "string " + string(data)
+	}
+	v, err := strconv.ParseInt(string(text), 10, 64)
+	if err != nil {
+		return &numericIDError{value: kind}
+	}
+	*n = NumericID(v)
+	return nil
+}
+
+// String formats the ID in base 10, as it appears in headers and keys
+func (n NumericID) String() string {
+	return strconv.FormatInt(int64(n), 10)
+}
+
+// numericIDError reports a NumericID value that is not a 64-bit integer.
+// encoding/json does not say which field a custom unmarshaler failed on.
+type numericIDError struct {
+	value string // the JSON type and text, like `string "abc"`
+}
+
+func (e *numericIDError) Error() string {
+	return "json: cannot unmarshal " + e.value + " into Go value of type int64"
+}
+
+// numericIDFields are the JSON names of the Event fields of type NumericID
+var numericIDFields = []string{"branchid", "channelid", "customerid", "userid"}
+
+// invalidNumericID turns a decode error of rawEvent caused by a NumericID field
+// that is not an integer into the reason the event is invalid, or returns nil for
+// any other error. Like encoding/json, field names match case-insensitively.
+func invalidNumericID(err error, rawEvent []byte) error {
+	var idErr *numericIDError
+	if !errors.As(err, &idErr) {
+		return nil
+	}
+	var fields map[string]json.RawMessage
+	_ = json.Unmarshal(rawEvent, &fields)
+	for _, field := range numericIDFields {
+		for key, value := range fields {
+			var id NumericID
+			if strings.EqualFold(key, field) && errors.As(id.UnmarshalJSON(value), &idErr) {
+				return fmt.Errorf("%s must be a 64-bit integer, got %s", field, idErr.value)
+			}
+		}
+	}
+	return fmt.Errorf("IDs must be 64-bit integers, got %s", idErr.value)
+}
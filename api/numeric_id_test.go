@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestNumericIDUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    NumericID
+		wantErr bool
+	}{
+		{name: "number", input: `100537117`, want: 100537117},
+		{name: "string", input: `"100537117"`, want: 100537117},
+		{name: "string with spaces", input: `" 42 "`, want: 42},
+		{name: "beyond float64 precision", input: `"9007199254740993"`, want: 9007199254740993},
+		{name: "max int64", input: `9223372036854775807`, want: 9223372036854775807},
+		{name: "null", input: `null`, want: 0},
+		{name: "overflow", input: `"9223372036854775808"`, wantErr: true},
+		{name: "non-numeric string", input: `"abc"`, wantErr: true},
+		{name: "fraction", input: `1.5`, wantErr: true},
+		{name: "exponent", input: `1e3`, wantErr: true},
+		{name: "bool", input: `true`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got NumericID
+			err := json.Unmarshal([]byte(tt.input), &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Unmarshal(%s) error = %v, want error: %v", tt.input, err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("Unmarshal(%s) = %d, want %d", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeEventsRejectsOnlyEventWithInvalidNumericID(t *testing.T) {
+	body := `[{"id": "good", "domain": "orders", "subdomain": "checkout", "code": "created", "customerid": "42"},
+		{"id": "bad", "domain": "orders", "subdomain": "checkout", "code": "created", "customerid": "abc"},
+		{"domain": "orders", "userid": 1.5, "id": "fraction"}]`
+
+	for _, strict := range []bool{false, true} {
+		events, err := decodeEvents(strings.NewReader(body), strict, "")
+		if err != nil {
+			t.Fatalf("decodeEvents(strict=%v) = %v, want no error", strict, err)
+		}
+		if len(events) != 3 {
+			t.Fatalf("decodeEvents(strict=%v) returned %d events, want 3", strict, len(events))
+		}
+		if events[0].invalid != nil || events[0].CustomerID != 42 {
+			t.Errorf("event good = %+v, want customerid 42 and valid", events[0])
+		}
+		for i, want := range map[int]string{1: "customerid must be a 64-bit integer", 2: "userid must be a 64-bit integer"} {
+			if events[i].invalid == nil || !strings.HasPrefix(events[i].invalid.Error(), want) {
+				t.Errorf("event %s invalid = %v, want %q", events[i].ID, events[i].invalid, want)
+			}
+		}
+		if events[1].ID != "bad" || events[2].ID != "fraction" {
+			t.Errorf("invalid event IDs = %q, %q, want bad, fraction", events[1].ID, events[2].ID)
+		}
+	}
+}
+
+func TestDecodeEventsKeepsOtherTypeErrors(t *testing.T) {
+	body := `[{"id": "bad", "customerid": "abc"}, {"id": "other", "eventtimestamp": "soon"}]`
+	if _, err := decodeEvents(strings.NewReader(body), false, ""); err == nil {
+		t.Error("decodeEvents() succeeded, want the eventtimestamp type error")
+	}
+}
+
+func TestDecodeEventRejectsOnlyEventWithInvalidNumericID(t *testing.T) {
+	body := `{"customerid": "abc", "id": "bad"}
+{"id": "good", "customerid": 42}
+{"id": "mapped", "UserId": "1.5"}
+`
+	for _, idField := range []string{"", "id"} {
+		decoder := json.NewDecoder(strings.NewReader(body))
+		var events []Event
+		for {
+			event, err := decodeEvent(decoder, true, idField)
+			if err != nil {
+				break
+			}
+			events = append(events, event)
+		}
+		if len(events) != 3 {
+			t.Fatalf("decodeEvent(idField=%q) returned %d events, want 3", idField, len(events))
+		}
+		wants := []string{`customerid must be a 64-bit integer, got string "abc"`, "", `userid must be a 64-bit integer, got string "1.5"`}
+		for i, want := range wants {
+			got := ""
+			if events[i].invalid != nil {
+				got = events[i].invalid.Error()
+			}
+			if got != want {
+				t.Errorf("decodeEvent(idField=%q) event %q invalid = %q, want %q", idField, events[i].ID, got, want)
+			}
+		}
+		if events[0].ID != "bad" || events[1].CustomerID != 42 {
+			t.Errorf("decodeEvent(idField=%q) = %+v, want ID bad, then customerid 42", idField, events)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// errPartitionOutOfRange fails a topic's events when X-Kafka-Partition names a
+// partition the topic does not have; the request is answered with 400
+var errPartitionOutOfRange = errors.New("partition out of range")
+
+// messageData travels in kafka.Message.WriterData from SendEvents to the balancer
+// and the writer's Completion callback. It is only set on messages that need it.
+type messageData struct {
+	eventID string // for AUDIT_LOG_FILE and flush
+
+	// flush is the tracker of a FLUSH_ON_BATCH_COMPLETE request
+	flush *flushTracker
+
+	// partition the message is pinned to with X-Kafka-Partition. kafka-go leaves
+	// Message.Partition for reads, and its zero value could not be told apart
+	// from a pin to partition 0.
+	partition int
+	pinned    bool
+}
+
+// partitionBalancer writes pinned messages to their partition and balances the
+// others with the configured PARTITION_BALANCER
+type partitionBalancer struct {
+	kafka.Balancer
+}
+
+func (b partitionBalancer) Balance(msg kafka.Message, partitions ...int) int {
+	if data, ok := msg.WriterData.(*messageData); ok && data.pinned && data.partition < len(partitions) {
+		return data.partition
+	}
+	return b.Balancer.Balance(msg, partitions...)
+}
+
+// PartitionCounter returns the number of partitions of a topic
+type PartitionCounter func(ctx context.Context, topicName string) (int, error)
+
+// partitionCount is the default PartitionCounter, reading the topic's metadata
+func (kp *KafkaProducer) partitionCount(ctx context.Context, topicName string) (int, error) {
+	resp, err := kp.admin.Metadata(ctx, &kafka.MetadataRequest{Topics: []string{topicName}})
+	if err != nil {
+		return 0, fmt.Errorf("failed to look up topic %s: %w", topicName, err)
+	}
+	for _, topic := range resp.Topics {
+		if topic.Name == topicName && topic.Error == nil {
+			return len(topic.Partitions), nil
+		}
+	}
+	return 0, fmt.Errorf("topic %s does not exist", topicName)
+}
+
+// pinPartition pins messages to partition after checking the topic has it
+func (kp *KafkaProducer) pinPartition(ctx context.Context, topicName string, messages []kafka.Message, partition int) error {
+	count, err := kp.config.PartitionCount(ctx, topicName)
+	if err != nil {
+		return err
+	}
+	if partition >= count {
+		return fmt.Errorf("%w: topic %s has %d partitions, got %d", errPartitionOutOfRange, topicName, count, partition)
+	}
+	pinMessages(messages, partition)
+	return nil
+}
+
+// pinOrdered pins messages to the topic's orderedPartition for PRESERVE_ORDER
+func (kp *KafkaProducer) pinOrdered(ctx context.Context, topicName string, messages []kafka.Message) error {
+	count, err := kp.config.PartitionCount(ctx, topicName)
+	if err != nil {
+		return err
+	}
+	if count <= 0 {
+		return fmt.Errorf("topic %s has no partitions", topicName)
+	}
+	pinMessages(messages, orderedPartition(topicName, count))
+	return nil
+}
+
+// orderedPartition is the partition PRESERVE_ORDER writes a topic's events to. It
+// only depends on the topic, so every request's events for the topic share it.
+func orderedPartition(topicName string, count int) int {
+	h := fnv.New32a()
+	h.Write([]byte(topicName))
+	return int(h.Sum32() % uint32(count))
+}
+
+// pinMessages sets the partition the balancer sends each message to
+func pinMessages(messages []kafka.Message, partition int) {
+	for i := range messages {
+		data, ok := messages[i].WriterData.(*messageData)
+		if !ok {
+			data = &messageData{}
+			messages[i].WriterData = data
+		}
+		data.partition, data.pinned = partition, true
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+)
+
+func TestPreserveOrderWritesInOrderToOnePartition(t *testing.T) {
+	recorder := newMessageRecorder()
+	kp := newTestProducer(ProducerConfig{NewWriter: recorder.NewWriter, PreserveOrder: true})
+	defer kp.Close()
+
+	// Two requests, so the second one's events must land behind the first one's
+	events := testEvents(20)
+	for _, batch := range [][]Event{events[:10], events[10:]} {
+		if errs := kp.SendEvents(batch, SendOptions{}); len(errs) > 0 {
+			t.Fatalf("SendEvents() = %v, want no errors", errs)
+		}
+	}
+
+	const topicName = "orders_checkout_created"
+	messages := recorder.Messages(topicName)
+	if len(messages) != len(events) {
+		t.Fatalf("messages written = %d, want %d", len(messages), len(events))
+	}
+
+	partitions := make([]int, mockTopicPartitions)
+	for i := range partitions {
+		partitions[i] = i
+	}
+	balancer := partitionBalancer{&kafka.RoundRobin{}}
+	want := orderedPartition(topicName, mockTopicPartitions)
+	for i, msg := range messages {
+		if id := messageEvent(t, msg).ID; id != strconv.Itoa(i) {
+			t.Errorf("message %d is event %s, want %d", i, id, i)
+		}
+		if got := balancer.Balance(msg, partitions...); got != want {
+			t.Errorf("message %d balanced to partition %d, want %d", i, got, want)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// positiveIntFields are the numeric event fields POSITIVE_INT_FIELDS can require
+// to be greater than zero, by their JSON name
+var positiveIntFields = map[string]func(Event) NumericID{
+	"branchid":   func(e Event) NumericID { return e.BranchID },
+	"channelid":  func(e Event) NumericID { return e.ChannelID },
+	"customerid": func(e Event) NumericID { return e.CustomerID },
+	"userid":     func(e Event) NumericID { return e.UserID },
+}
+
+// ParsePositiveIntFields parses the comma-separated POSITIVE_INT_FIELDS list,
+// matching names case-insensitively. The fields are returned in the order given,
+// which is the order events are checked in.
+func ParsePositiveIntFields(list string) ([]string, error) {
+	var fields []string
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || seen[name] {
+			continue
+		}
+		if _, ok := positiveIntFields[name]; !ok {
+			return nil, fmt.Errorf("unknown field %q, expected branchid, channelid, customerid or userid", name)
+		}
+		seen[name] = true
+		fields = append(fields, name)
+	}
+	return fields, nil
+}
+
+// checkPositiveInts returns an error for the first of fields that is zero or negative
+func checkPositiveInts(event Event, fields []string) error {
+	for _, name := range fields {
+		if v := positiveIntFields[name](event); v <= 0 {
+			return fmt.Errorf("%s must be positive, got %d", name, v)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// newTestProducer returns a producer for config that writes to mockWriter
+func newTestProducer(config ProducerConfig) *KafkaProducer {
+	config.ProduceConcurrency = 1
+	config.AllowAutoTopicCreation = true
+	config.PartitionCount = mockPartitionCount
+	if config.NewWriter == nil {
+		config.NewWriter = newMockWriter
+	}
+	return NewKafkaProducer(config)
+}
+
+// messageRecorder keeps the messages written by the writers of its NewWriter
+// factory, by topic, and reports them as delivered like mockWriter
+type messageRecorder struct {
+	mu       sync.Mutex
+	messages map[string][]kafka.Message
+	batches  []int // size of each WriteMessages call
+}
+
+func newMessageRecorder() *messageRecorder {
+	return &messageRecorder{messages: make(map[string][]kafka.Message)}
+}
+
+// NewWriter is a WriterFactory for writers recording into r
+func (r *messageRecorder) NewWriter(topicName string, acks kafka.RequiredAcks, sync bool, completion func(messages []kafka.Message, err error)) MessageWriter {
+	return &recordingWriter{recorder: r, topic: topicName, completion: completion}
+}
+
+// Messages returns the messages written to topicName so far
+func (r *messageRecorder) Messages(topicName string) []kafka.Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]kafka.Message(nil), r.messages[topicName]...)
+}
+
+type recordingWriter struct {
+	recorder   *messageRecorder
+	topic      string
+	completion func(messages []kafka.Message, err error)
+}
+
+func (w *recordingWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	w.recorder.mu.Lock()
+	w.recorder.messages[w.topic] = append(w.recorder.messages[w.topic], msgs...)
+	w.recorder.batches = append(w.recorder.batches, len(msgs))
+	w.recorder.mu.Unlock()
+	w.completion(msgs, nil)
+	return nil
+}
+
+func (w *recordingWriter) Close() error {
+	return nil
+}
+
+// testEvents returns n valid events for the orders_checkout_created topic
+func testEvents(n int) []Event {
+	events := make([]Event, n)
+	for i := range events {
+		events[i] = Event{ID: strconv.Itoa(i), Domain: "orders", Subdomain: "checkout", Code: "created", Version: "1"}
+	}
+	return events
+}
+
+func BenchmarkSendEvents(b *testing.B) {
+	for _, mode := range []struct {
+		name string
+		lite bool
+	}{
+		{name: "lite", lite: true},
+		{name: "full", lite: false},
+	} {
+		for _, batch := range []struct {
+			name   string
+			topics int
+		}{
+			{name: "single-topic", topics: 1},
+			{name: "multi-topic", topics: 10},
+		} {
+			b.Run(mode.name+"/"+batch.name, func(b *testing.B) {
+				kp := newTestProducer(ProducerConfig{LiteMode: mode.lite})
+				defer kp.Close()
+				events := testEvents(100)
+				for i := range events {
+					events[i].Code = "created" + strconv.Itoa(i%batch.topics)
+				}
+
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if errs := kp.SendEvents(events, SendOptions{}); len(errs) > 0 {
+						b.Fatal(errs)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestSendEventsGroupsByTopic(t *testing.T) {
+	recorder := newMessageRecorder()
+	kp := newTestProducer(ProducerConfig{NewWriter: recorder.NewWriter})
+	defer kp.Close()
+
+	events := testEvents(5)
+	events[1].Code = "cancelled"
+	events[3].Code = "cancelled"
+	if errs := kp.SendEvents(events, SendOptions{}); len(errs) > 0 {
+		t.Fatalf("SendEvents() = %v, want no errors", errs)
+	}
+
+	for topicName, want := range map[string][]string{
+		"orders_checkout_created":   {"0", "2", "4"},
+		"orders_checkout_cancelled": {"1", "3"},
+	} {
+		var got []string
+		for _, msg := range recorder.Messages(topicName) {
+			got = append(got, messageEvent(t, msg).ID)
+		}
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("events written to %s = %v, want %v", topicName, got, want)
+		}
+	}
+}
+
+// Event has no field json.Marshal can fail on, so an event whose message cannot
+// be built is covered by one with an invalid topic, which fails the same way: on
+// its own, keyed by event ID, while the rest of the batch is still produced
+func TestSendEventsFailsInvalidEventOnly(t *testing.T) {
+	recorder := newMessageRecorder()
+	kp := newTestProducer(ProducerConfig{NewWriter: recorder.NewWriter})
+	defer kp.Close()
+
+	events := testEvents(3)
+	events[1].Topic = "not a topic"
+	errs := kp.SendEvents(events, SendOptions{})
+
+	if len(errs) != 1 || errs["1"] == nil {
+		t.Fatalf("SendEvents() = %v, want only an error for event 1", errs)
+	}
+	if got := len(recorder.Messages("orders_checkout_created")); got != 2 {
+		t.Errorf("messages written = %d, want 2", got)
+	}
+}
+
+func TestSendEventsEmptyBatch(t *testing.T) {
+	recorder := newMessageRecorder()
+	kp := newTestProducer(ProducerConfig{NewWriter: recorder.NewWriter})
+	defer kp.Close()
+
+	if errs := kp.SendEvents(nil, SendOptions{}); len(errs) != 0 {
+		t.Errorf("SendEvents(nil) = %v, want no errors", errs)
+	}
+	if len(recorder.messages) != 0 {
+		t.Errorf("messages written for an empty batch: %v", recorder.messages)
+	}
+}
+
+// messageEvent decodes the event a JSON message value was built from
+func messageEvent(t testing.TB, msg kafka.Message) Event {
+	t.Helper()
+	var event Event
+	if err := json.Unmarshal(msg.Value, &event); err != nil {
+		t.Fatalf("message value %q is not an event: %v", msg.Value, err)
+	}
+	return event
+}
+
+func TestSendEventsEnrichesProducedMessages(t *testing.T) {
+	enricher, err := NewEnricherChain([]string{EnricherReceivedAt, EnricherDefaultVersion, EnricherSourceInstance}, "api-1")
+	if err != nil {
+		t.Fatalf("NewEnricherChain() = %v", err)
+	}
+	recorder := newMessageRecorder()
+	kp := newTestProducer(ProducerConfig{NewWriter: recorder.NewWriter, Enricher: enricher})
+	defer kp.Close()
+
+	events := testEvents(1)
+	events[0].Version = ""
+	if errs := kp.SendEvents(events, SendOptions{}); len(errs) > 0 {
+		t.Fatalf("SendEvents() = %v, want no errors", errs)
+	}
+
+	messages := recorder.Messages("orders_checkout_created")
+	if len(messages) != 1 {
+		t.Fatalf("messages written = %d, want 1", len(messages))
+	}
+	produced := messageEvent(t, messages[0])
+	if produced.ReceivedAt == "" {
+		t.Error("produced event has no receivedat")
+	}
+	if produced.Version != defaultEventVersion {
+		t.Errorf("produced version = %q, want %q", produced.Version, defaultEventVersion)
+	}
+	if produced.SourceInstance != "api-1" {
+		t.Errorf("produced sourceinstance = %q, want %q", produced.SourceInstance, "api-1")
+	}
+
+	// A retry of the same events keeps the ReceivedAt of the first attempt
+	if errs := kp.SendEvents(events, SendOptions{}); len(errs) > 0 {
+		t.Fatalf("SendEvents() retry = %v, want no errors", errs)
+	}
+	if retried := messageEvent(t, recorder.Messages("orders_checkout_created")[1]); retried.ReceivedAt != produced.ReceivedAt {
+		t.Errorf("retried receivedat = %q, want %q", retried.ReceivedAt, produced.ReceivedAt)
+	}
+}
+
+func TestEventTime(t *testing.T) {
+	nanos := time.Date(2024, 5, 9, 14, 2, 16, 758000000, time.UTC)
+
+	tests := []struct {
+		name   string
+		event  Event
+		want   time.Time
+		wantOK bool
+	}{
+		{
+			name:   "nanosecond timestamp",
+			event:  Event{EventTimestamp: nanos.UnixNano()},
+			want:   nanos,
+			wantOK: true,
+		},
+		{
+			name:   "millisecond timestamp falls back to eventtime",
+			event:  Event{EventTimestamp: nanos.UnixMilli(), EventTime: "2024-05-09T14:02:16Z"},
+			want:   time.Date(2024, 5, 9, 14, 2, 16, 0, time.UTC),
+			wantOK: true,
+		},
+		{
+			name:   "rfc3339 eventtime",
+			event:  Event{EventTime: "2024-05-09T16:02:16.5+02:00"},
+			want:   time.Date(2024, 5, 9, 14, 2, 16, 500000000, time.UTC),
+			wantOK: true,
+		},
+		{
+			name:  "millisecond timestamp without eventtime",
+			event: Event{EventTimestamp: nanos.UnixMilli()},
+		},
+		{
+			name:  "garbage",
+			event: Event{EventTimestamp: -1, EventTime: "yesterday"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := eventTime(tt.event)
+			if ok != tt.wantOK || !got.Equal(tt.want) {
+				t.Errorf("eventTime() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestBuildMessageTime(t *testing.T) {
+	eventAt := time.Date(2024, 5, 9, 14, 2, 16, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		source    string
+		timestamp int64
+		fromEvent bool
+	}{
+		{name: "ingest", source: TimeSourceIngest, timestamp: eventAt.UnixNano()},
+		{name: "event", source: TimeSourceEvent, timestamp: eventAt.UnixNano(), fromEvent: true},
+		{name: "event without a usable time", source: TimeSourceEvent, timestamp: eventAt.UnixMilli()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := newMessageRecorder()
+			kp := newTestProducer(ProducerConfig{NewWriter: recorder.NewWriter, MessageTimeSource: tt.source})
+			defer kp.Close()
+
+			events := testEvents(1)
+			events[0].EventTimestamp = tt.timestamp
+			before := time.Now()
+			if errs := kp.SendEvents(events, SendOptions{}); len(errs) > 0 {
+				t.Fatalf("SendEvents() = %v, want no errors", errs)
+			}
+			after := time.Now()
+
+			messages := recorder.Messages("orders_checkout_created")
+			if len(messages) != 1 {
+				t.Fatalf("messages written = %d, want 1", len(messages))
+			}
+			got := messages[0].Time
+			if tt.fromEvent {
+				if !got.Equal(eventAt) {
+					t.Errorf("message time = %v, want %v", got, eventAt)
+				}
+			} else if got.Before(before) || got.After(after) {
+				t.Errorf("message time = %v, want the time it was built", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/segmentio/kafka-go"
+	"golang.org/x/sync/semaphore"
+)
+
+// Async queue policies selected with ASYNC_QUEUE_POLICY. They decide what happens
+// when a write would take the number of in-flight async messages over the limit.
+const (
+	QueuePolicyBlock = "block" // wait until earlier batches complete
+	QueuePolicyDrop  = "drop"  // drop the batch and count it as dropped
+	QueuePolicyError = "error" // fail the batch so /events answers 503
+)
+
+// errQueueFull is returned for batches rejected by the error policy
+var errQueueFull = errors.New("async writer queue is full")
+
+// errQueueDropped is returned for batches dropped by the drop policy
+var errQueueDropped = fmt.Errorf("%w, events dropped", errQueueFull)
+
+// errQueueBytesFull is returned for batches that would take the in-flight bytes
+// over ASYNC_MAX_BYTES. It is rejected whatever the policy, so /events answers 429.
+var errQueueBytesFull = fmt.Errorf("%w, in-flight bytes limit reached", errQueueFull)
+
+// inFlightLimiter bounds the messages, and their bytes, handed to async writers
+// whose Completion callback has not run yet, across all writers
+type inFlightLimiter struct {
+	sem    *semaphore.Weighted // nil when only bytes are limited
+	max    int
+	policy string
+
+	bytesSem *semaphore.Weighted // nil when only messages are limited
+	maxBytes int
+	bytes    atomic.Int64
+	lite     bool
+}
+
+// newInFlightLimiter limits messages to max and their bytes to maxBytes; 0 leaves either unlimited
+func newInFlightLimiter(max, maxBytes int, policy string, lite bool) *inFlightLimiter {
+	l := &inFlightLimiter{max: max, policy: policy, maxBytes: maxBytes, lite: lite}
+	if max > 0 {
+		l.sem = semaphore.NewWeighted(int64(max))
+	}
+	if maxBytes > 0 {
+		l.bytesSem = semaphore.NewWeighted(int64(maxBytes))
+	}
+	return l
+}
+
+// acquire reserves room for n messages of size bytes, rejecting the batch when the
+// bytes do not fit and applying the policy to the message count
+func (l *inFlightLimiter) acquire(ctx context.Context, n, size int) error {
+	if l.bytesSem != nil {
+		// sendTopic splits batches to fit, so only a single message can be larger
+		if size > l.maxBytes {
+			return fmt.Errorf("%w, batch of %d bytes exceeds the limit of %d bytes", errQueueBytesFull, size, l.maxBytes)
+		}
+		if !l.bytesSem.TryAcquire(int64(size)) {
+			return errQueueBytesFull
+		}
+		l.addBytes(size)
+	}
+	if err := l.acquireMessages(ctx, n); err != nil {
+		l.releaseBytes(size)
+		return err
+	}
+	return nil
+}
+
+// acquireMessages reserves room for n messages according to the policy
+func (l *inFlightLimiter) acquireMessages(ctx context.Context, n int) error {
+	if l.sem == nil {
+		return nil
+	}
+	if n > l.max {
+		// sendTopic splits batches to fit, so this is backpressure rather than an outage
+		return fmt.Errorf("%w, batch of %d messages exceeds the in-flight limit of %d", errQueueFull, n, l.max)
+	}
+	switch l.policy {
+	case QueuePolicyDrop:
+		if !l.sem.TryAcquire(int64(n)) {
+			return errQueueDropped
+		}
+	case QueuePolicyError:
+		if !l.sem.TryAcquire(int64(n)) {
+			return errQueueFull
+		}
+	default:
+		return l.sem.Acquire(ctx, int64(n))
+	}
+	return nil
+}
+
+// release frees room for n completed messages of size bytes
+func (l *inFlightLimiter) release(n, size int) {
+	if l.sem != nil {
+		l.sem.Release(int64(n))
+	}
+	l.releaseBytes(size)
+}
+
+func (l *inFlightLimiter) releaseBytes(size int) {
+	if l.bytesSem != nil {
+		l.bytesSem.Release(int64(size))
+		l.addBytes(-size)
+	}
+}
+
+// addBytes tracks the in-flight bytes for the async_queue_bytes gauge
+func (l *inFlightLimiter) addBytes(size int) {
+	current := l.bytes.Add(int64(size))
+	if !l.lite {
+		asyncQueueBytes.Set(float64(current))
+	}
+}
+
+// batchBytes is the size of messages as counted against ASYNC_MAX_BYTES
+func batchBytes(messages []kafka.Message) int {
+	size := 0
+	for _, m := range messages {
+		size += messageBytes(m)
+	}
+	return size
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestInFlightLimiterRejectsOversizedBatchAsBackpressure(t *testing.T) {
+	l := newInFlightLimiter(100, 0, QueuePolicyBlock, true)
+	if err := l.acquire(context.Background(), 101, 0); !errors.Is(err, errQueueFull) {
+		t.Errorf("acquire() = %v, want %v", err, errQueueFull)
+	}
+}
+
+func TestSendEventsSplitsBatchesOverMaxInFlight(t *testing.T) {
+	recorder := newMessageRecorder()
+	kp := newTestProducer(ProducerConfig{NewWriter: recorder.NewWriter, MaxInFlight: 100})
+	defer kp.Close()
+
+	if errs := kp.SendEvents(testEvents(250), SendOptions{}); len(errs) > 0 {
+		t.Fatalf("SendEvents() = %v, want no errors", errs)
+	}
+	if got := len(recorder.Messages("orders_checkout_created")); got != 250 {
+		t.Errorf("messages written = %d, want 250", got)
+	}
+	if got := len(recorder.batches); got != 3 {
+		t.Errorf("WriteMessages calls = %d, want 3", got)
+	}
+}
+
+func TestInFlightLimiterRejectsOversizedMessageAsBytesBackpressure(t *testing.T) {
+	l := newInFlightLimiter(0, 1000, QueuePolicyBlock, true)
+	if err := l.acquire(context.Background(), 1, 1001); !errors.Is(err, errQueueBytesFull) {
+		t.Errorf("acquire() = %v, want %v", err, errQueueBytesFull)
+	}
+}
+
+func TestSendEventsSplitsBatchesOverMaxInFlightBytes(t *testing.T) {
+	recorder := newMessageRecorder()
+	kp := newTestProducer(ProducerConfig{NewWriter: recorder.NewWriter, MaxInFlightBytes: 4096})
+	defer kp.Close()
+
+	events := testEvents(100)
+	if errs := kp.SendEvents(events, SendOptions{}); len(errs) > 0 {
+		t.Fatalf("SendEvents() = %v, want no errors", errs)
+	}
+	if got := len(recorder.Messages("orders_checkout_created")); got != len(events) {
+		t.Errorf("messages written = %d, want %d", got, len(events))
+	}
+	if len(recorder.batches) < 2 {
+		t.Errorf("WriteMessages calls = %d, want the batch split to fit 4096 bytes", len(recorder.batches))
+	}
+}
+
+func TestSendEventsRateLimitsMessageOverMaxInFlightBytes(t *testing.T) {
+	recorder := newMessageRecorder()
+	kp := newTestProducer(ProducerConfig{NewWriter: recorder.NewWriter, MaxInFlightBytes: 4096})
+	defer kp.Close()
+
+	events := testEvents(2)
+	events[1].Payload = strings.Repeat("x", 5000)
+	errs := kp.SendEvents(events, SendOptions{})
+
+	if len(errs) != 1 || !errors.Is(errs["1"], errQueueBytesFull) {
+		t.Fatalf("SendEvents() = %v, want only %v for event 1", errs, errQueueBytesFull)
+	}
+	if got := outcomeFor(events[1], errs["1"]).Status; got != OutcomeRateLimited {
+		t.Errorf("outcome = %s, want %s", got, OutcomeRateLimited)
+	}
+}
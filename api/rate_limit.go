@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DomainRateLimiter limits how many events per second each domain may produce,
+// so one noisy domain cannot starve the others. Domains without a configured
+// limit are unlimited.
+type DomainRateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+// tokenBucket refills at rate tokens per second up to a burst of one second's worth
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewDomainRateLimiter creates a limiter from events-per-second limits keyed by domain
+func NewDomainRateLimiter(limits map[string]float64) *DomainRateLimiter {
+	now := time.Now()
+	buckets := make(map[string]*tokenBucket, len(limits))
+	for domain, rate := range limits {
+		burst := math.Max(rate, 1)
+		buckets[domain] = &tokenBucket{rate: rate, burst: burst, tokens: burst, last: now}
+	}
+	return &DomainRateLimiter{buckets: buckets}
+}
+
+// ParseDomainRateLimits parses limits like "Banking=1000,Retail=500", or a JSON
+// object like {"Banking": 1000, "Retail": 500}
+func ParseDomainRateLimits(s string) (map[string]float64, error) {
+	if strings.HasPrefix(strings.TrimSpace(s), "{") {
+		return parseDomainRateLimitsJSON(s)
+	}
+
+	limits := make(map[string]float64)
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		domain, value, ok := strings.Cut(entry, "=")
+		domain = strings.TrimSpace(domain)
+		if !ok || domain == "" {
+			return nil, fmt.Errorf("invalid entry %q, expected domain=limit", entry)
+		}
+		rate, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || rate <= 0 {
+			return nil, fmt.Errorf("invalid limit for domain %s: %q", domain, value)
+		}
+		limits[domain] = rate
+	}
+	return limits, nil
+}
+
+// parseDomainRateLimitsJSON parses limits given as a JSON object of domain to limit
+func parseDomainRateLimitsJSON(s string) (map[string]float64, error) {
+	var limits map[string]float64
+	if err := json.Unmarshal([]byte(s), &limits); err != nil {
+		return nil, fmt.Errorf("invalid JSON object: %v", err)
+	}
+	for domain, rate := range limits {
+		if strings.TrimSpace(domain) == "" {
+			return nil, errors.New("empty domain name")
+		}
+		if rate <= 0 {
+			return nil, fmt.Errorf("invalid limit for domain %s: %v", domain, rate)
+		}
+	}
+	return limits, nil
+}
+
+// Allow takes a token from the domain's bucket, reporting false when it is empty
+func (l *DomainRateLimiter) Allow(domain string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	bucket, ok := l.buckets[domain]
+	if !ok {
+		return true
+	}
+
+	now := time.Now()
+	bucket.tokens += now.Sub(bucket.last).Seconds() * bucket.rate
+	if bucket.tokens > bucket.burst {
+		bucket.tokens = bucket.burst
+	}
+	bucket.last = now
+
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// gzipResponses compresses response bodies of at least minBytes with gzip when the
+// client's Accept-Encoding allows it; smaller bodies and clients that do not ask
+// for gzip get the body unchanged. The body is held until the handler returns,
+// except for streamed responses: the first Flush sends everything as is.
+func gzipResponses(minBytes int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Vary", "Accept-Encoding")
+		if !acceptsGzip(c.GetHeader("Accept-Encoding")) {
+			c.Next()
+			return
+		}
+
+		w := &gzipResponseWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Next()
+		c.Writer = w.ResponseWriter
+
+		if w.streaming || w.body.Len() == 0 {
+			return
+		}
+		if w.body.Len() < minBytes || w.Header().Get("Content-Encoding") != "" {
+			_, _ = w.ResponseWriter.Write(w.body.Bytes())
+			return
+		}
+
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Del("Content-Length")
+		gz := gzip.NewWriter(w.ResponseWriter)
+		if _, err := gz.Write(w.body.Bytes()); err != nil {
+			log.Printf("Failed to write compressed response: %v", err)
+			return
+		}
+		if err := gz.Close(); err != nil {
+			log.Printf("Failed to write compressed response: %v", err)
+		}
+	}
+}
+
+// gzipResponseWriter holds the body so its size is known before it is sent
+type gzipResponseWriter struct {
+	gin.ResponseWriter
+	body      bytes.Buffer
+	streaming bool // Flush was called, writes go straight to the client
+}
+
+func (w *gzipResponseWriter) Write(data []byte) (int, error) {
+	if w.streaming {
+		return w.ResponseWriter.Write(data)
+	}
+	return w.body.Write(data)
+}
+
+func (w *gzipResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Flush sends what was held uncompressed and stops holding the body
+func (w *gzipResponseWriter) Flush() {
+	if !w.streaming {
+		w.streaming = true
+		if w.body.Len() > 0 {
+			_, _ = w.ResponseWriter.Write(w.body.Bytes())
+			w.body.Reset()
+		}
+	}
+	w.ResponseWriter.Flush()
+}
+
+// acceptsGzip reports whether an Accept-Encoding header value allows gzip
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.TrimSpace(coding)
+		if !strings.EqualFold(coding, "gzip") && coding != "*" {
+			continue
+		}
+		// q=0 means the coding is not acceptable
+		for _, param := range strings.Split(params, ";") {
+			name, value, _ := strings.Cut(param, "=")
+			if strings.TrimSpace(name) != "q" {
+				continue
+			}
+			if q, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err != nil || q == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
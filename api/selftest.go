@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// selfTestTopic receives the probe messages of GET /protected/selftest
+const selfTestTopic = "__selftest"
+
+// selfTestTimeout bounds a whole self-test, from the write until the probe is read back
+const selfTestTimeout = 10 * time.Second
+
+// selfTestInterval is the least time between two self-tests, which write to the cluster
+const selfTestInterval = 5 * time.Second
+
+// SelfTestResult reports the outcome of GET /protected/selftest
+type SelfTestResult struct {
+	OK        bool    `json:"ok"`
+	LatencyMs float64 `json:"latencyMs"` // from the write until the probe was read back
+	Partition int     `json:"partition"`
+	Offset    int64   `json:"offset"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// SelfTest produces a probe message to selfTestTopic through the writers events
+// use, synchronously with acks=all, and consumes it back. The probe is pinned to
+// partition 0 and found by its key, reading from where the partition ended
+// before the write, so probes of other instances do not confuse it.
+func (kp *KafkaProducer) SelfTest(ctx context.Context) SelfTestResult {
+	result := SelfTestResult{Offset: -1}
+	fail := func(err error) SelfTestResult {
+		result.Error = err.Error()
+		return result
+	}
+
+	if err := kp.ensureTopic(ctx, selfTestTopic); err != nil {
+		return fail(err)
+	}
+
+	// A topic auto-created by the probe itself starts at offset 0
+	var start int64
+	resp, err := kp.admin.ListOffsets(ctx, &kafka.ListOffsetsRequest{
+		Topics: map[string][]kafka.OffsetRequest{selfTestTopic: {kafka.LastOffsetOf(0)}},
+	})
+	if err != nil {
+		return fail(fmt.Errorf("failed to look up offsets of topic %s: %w", selfTestTopic, err))
+	}
+	if offsets := resp.Topics[selfTestTopic]; len(offsets) > 0 {
+		switch err := offsets[0].Error; {
+		case err == nil:
+			start = offsets[0].LastOffset
+		case !errors.Is(err, kafka.UnknownTopicOrPartition):
+			return fail(fmt.Errorf("failed to look up offsets of topic %s: %w", selfTestTopic, err))
+		}
+	}
+
+	probeID := newBatchID()
+	probe := kafka.Message{
+		Key:        []byte(probeID),
+		Value:      []byte(time.Now().UTC().Format(time.RFC3339Nano)),
+		WriterData: &messageData{partition: 0, pinned: true},
+	}
+	began := time.Now()
+	if err := kp.writerFor(selfTestTopic, kafka.RequireAll, true).write(ctx, probe); err != nil {
+		return fail(fmt.Errorf("failed to produce probe: %w", err))
+	}
+
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:   kp.config.Brokers,
+		Topic:     selfTestTopic,
+		Partition: 0,
+		MaxWait:   100 * time.Millisecond,
+	})
+	defer reader.Close()
+	if err := reader.SetOffset(start); err != nil {
+		return fail(err)
+	}
+
+	for {
+		msg, err := reader.ReadMessage(ctx)
+		if err != nil {
+			return fail(fmt.Errorf("probe was produced but not consumed: %w", err))
+		}
+		if string(msg.Key) == probeID {
+			result.OK = true
+			result.LatencyMs = float64(time.Since(began).Microseconds()) / 1000
+			result.Partition, result.Offset = msg.Partition, msg.Offset
+			return result
+		}
+	}
+}
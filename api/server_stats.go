@@ -0,0 +1,103 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// ServerStats counts POST /events requests and their event outcomes since startup,
+// for a quick look at GET /protected/stats without a Prometheus scraper
+type ServerStats struct {
+	start time.Time
+
+	requests        atomic.Int64
+	successRequests atomic.Int64 // answered with a 2xx status
+	failedRequests  atomic.Int64
+
+	events            atomic.Int64
+	successEvents     atomic.Int64
+	failedEvents      atomic.Int64
+	invalidEvents     atomic.Int64
+	rateLimitedEvents atomic.Int64
+	acceptedEvents    atomic.Int64
+	bufferedEvents    atomic.Int64
+	spooledEvents     atomic.Int64
+}
+
+// ServerStatsSnapshot is the response of GET /protected/stats, shaped like the
+// load test's LoadTestStats
+type ServerStatsSnapshot struct {
+	TotalRequests   int64 `json:"totalRequests"`
+	SuccessRequests int64 `json:"successRequests"`
+	FailedRequests  int64 `json:"failedRequests"`
+
+	TotalEvents       int64 `json:"totalEvents"`
+	SuccessEvents     int64 `json:"successEvents"`
+	FailedEvents      int64 `json:"failedEvents"`
+	InvalidEvents     int64 `json:"invalidEvents"`
+	RateLimitedEvents int64 `json:"rateLimitedEvents"`
+	AcceptedEvents    int64 `json:"acceptedEvents"`
+	BufferedEvents    int64 `json:"bufferedEvents"`
+	SpooledEvents     int64 `json:"spooledEvents"`
+
+	StartTime     time.Time `json:"startTime"`
+	UptimeSeconds float64   `json:"uptimeSeconds"`
+}
+
+// NewServerStats starts counting from now
+func NewServerStats() *ServerStats {
+	return &ServerStats{start: time.Now()}
+}
+
+// RecordRequest counts an answered request by its status code
+func (s *ServerStats) RecordRequest(status int) {
+	s.requests.Add(1)
+	if status >= http.StatusOK && status < http.StatusMultipleChoices {
+		s.successRequests.Add(1)
+	} else {
+		s.failedRequests.Add(1)
+	}
+}
+
+// RecordEvents counts the outcomes of a request's events. Events answered from the
+// Idempotency-Key cache were counted when they were first produced.
+func (s *ServerStats) RecordEvents(response EventResponse) {
+	counts := []struct {
+		counter *atomic.Int64
+		n       int
+	}{
+		{&s.successEvents, len(response.SuccessEventIds)},
+		{&s.failedEvents, len(response.FailedEventIds)},
+		{&s.invalidEvents, len(response.InvalidEventIds)},
+		{&s.rateLimitedEvents, len(response.RateLimitedEventIds)},
+		{&s.acceptedEvents, len(response.AcceptedEventIds)},
+		{&s.bufferedEvents, len(response.BufferedEventIds)},
+		{&s.spooledEvents, len(response.SpooledEventIds)},
+	}
+	for _, c := range counts {
+		if c.n > 0 {
+			c.counter.Add(int64(c.n))
+			s.events.Add(int64(c.n))
+		}
+	}
+}
+
+// Snapshot returns the current totals
+func (s *ServerStats) Snapshot() ServerStatsSnapshot {
+	return ServerStatsSnapshot{
+		TotalRequests:     s.requests.Load(),
+		SuccessRequests:   s.successRequests.Load(),
+		FailedRequests:    s.failedRequests.Load(),
+		TotalEvents:       s.events.Load(),
+		SuccessEvents:     s.successEvents.Load(),
+		FailedEvents:      s.failedEvents.Load(),
+		InvalidEvents:     s.invalidEvents.Load(),
+		RateLimitedEvents: s.rateLimitedEvents.Load(),
+		AcceptedEvents:    s.acceptedEvents.Load(),
+		BufferedEvents:    s.bufferedEvents.Load(),
+		SpooledEvents:     s.spooledEvents.Load(),
+		StartTime:         s.start,
+		UptimeSeconds:     time.Since(s.start).Seconds(),
+	}
+}
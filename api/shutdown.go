@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requestTracker counts the HTTP requests currently being served, so shutdown
+// can wait for them and report how many are left
+type requestTracker struct {
+	inFlight atomic.Int64
+}
+
+// Middleware counts a request as in flight until its handlers return
+func (t *requestTracker) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		t.inFlight.Add(1)
+		defer t.inFlight.Add(-1)
+		c.Next()
+	}
+}
+
+// InFlight returns the number of requests being served
+func (t *requestTracker) InFlight() int64 {
+	return t.inFlight.Load()
+}
+
+// drain waits until no request is in flight, logging the count every second.
+// It returns false if ctx ends first.
+func (t *requestTracker) drain(ctx context.Context) bool {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	poll := time.NewTicker(10 * time.Millisecond)
+	defer poll.Stop()
+
+	for {
+		n := t.InFlight()
+		if n == 0 {
+			return true
+		}
+		select {
+		case <-ctx.Done():
+			log.Printf("Shutdown timeout reached with %d requests still in flight", n)
+			return false
+		case <-ticker.C:
+			log.Printf("Draining: %d requests in flight", n)
+		case <-poll.C:
+		}
+	}
+}
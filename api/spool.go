@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// Backoff between spool retries while Kafka keeps failing
+const (
+	spoolMinBackoff = 100 * time.Millisecond
+	spoolMaxBackoff = 10 * time.Second
+)
+
+// errSpooled is the outcome of events that could not reach Kafka and are held
+// in the in-memory spool to be retried
+var errSpooled = errors.New("spooled for retry")
+
+// Spool holds up to maxEvents events in memory that failed to reach Kafka, and
+// retries them from a background goroutine with exponential backoff, so short
+// broker outages do not fail requests. Unlike DiskBuffer nothing survives a crash.
+type Spool struct {
+	producer  *KafkaProducer
+	breaker   *CircuitBreaker
+	maxEvents int
+
+	mu     sync.Mutex
+	events []Event // oldest first
+	closed bool
+
+	wake chan struct{} // signals that events were added
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewSpool creates a spool and starts its retry loop
+func NewSpool(producer *KafkaProducer, breaker *CircuitBreaker, maxEvents int) *Spool {
+	s := &Spool{
+		producer:  producer,
+		breaker:   breaker,
+		maxEvents: maxEvents,
+		wake:      make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// Add holds events for retry. It takes all of them or, when they do not fit, none.
+func (s *Spool) Add(events []Event) bool {
+	s.mu.Lock()
+	if s.closed || len(s.events)+len(events) > s.maxEvents {
+		s.mu.Unlock()
+		return false
+	}
+	s.events = append(s.events, events...)
+	s.recordDepth()
+	s.mu.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// Depth returns the number of events waiting in the spool
+func (s *Spool) Depth() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.events)
+}
+
+// Close stops the retry loop after a last attempt. Events still held are moved
+// to the disk buffer when one is configured, otherwise they are lost.
+func (s *Spool) Close() {
+	close(s.stop)
+	<-s.done
+
+	for s.Depth() > 0 && s.retry() {
+	}
+
+	s.mu.Lock()
+	s.closed = true
+	left := s.events
+	s.events = nil
+	s.recordDepth()
+	s.mu.Unlock()
+
+	if len(left) == 0 {
+		return
+	}
+	if s.producer.diskBuffer != nil {
+		err := s.producer.diskBuffer.Append(left)
+		if err == nil {
+			s.producer.diskBuffer.recordEvents(diskBufferStageBuffered, len(left))
+			log.Printf("Spool: moved %d events to the disk buffer", len(left))
+			return
+		}
+		log.Printf("Spool: failed to move %d events to the disk buffer: %v", len(left), err)
+	}
+	log.Printf("Spool: %d events were never produced", len(left))
+}
+
+// run retries the spooled events, backing off while Kafka keeps failing
+func (s *Spool) run() {
+	defer close(s.done)
+
+	backoff := spoolMinBackoff
+	for {
+		if s.Depth() == 0 {
+			backoff = spoolMinBackoff
+			select {
+			case <-s.wake:
+			case <-s.stop:
+				return
+			}
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-s.stop:
+			return
+		}
+
+		if s.retry() {
+			backoff = spoolMinBackoff
+		} else {
+			backoff = min(backoff*2, spoolMaxBackoff)
+		}
+	}
+}
+
+// retry produces the oldest chunk of spooled events and reports whether all of
+// them left the spool
+func (s *Spool) retry() bool {
+	s.mu.Lock()
+	chunk := make([]Event, min(len(s.events), ndjsonChunkSize))
+	copy(chunk, s.events)
+	s.mu.Unlock()
+
+	if len(chunk) == 0 || !s.breaker.Allow() {
+		return false
+	}
+	errs := s.producer.SendEvents(chunk, SendOptions{})
+	s.breaker.Record(!hasTopicErrors(errs, chunk))
+
+	var failed []Event
+	for _, event := range chunk {
+		topicName, _ := s.producer.Route(event)
+		if err, ok := errs[event.ID]; ok && errors.Is(err, errThrottled) {
+			failed = append(failed, event) // retried once the events per second budget allows
+		} else if ok {
+			log.Printf("Spool: dropping event %s: %v", event.ID, err)
+		} else if _, ok := errs[topicName]; ok {
+			failed = append(failed, event)
+		}
+	}
+
+	// Only the retry loop removes events, so the chunk is still at the front
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(failed, s.events[len(chunk):]...)
+	s.recordDepth()
+	return len(failed) == 0
+}
+
+// recordDepth publishes the number of spooled events. Callers hold mu.
+func (s *Spool) recordDepth() {
+	if s.producer.config.LiteMode {
+		return
+	}
+	spoolDepth.Set(float64(len(s.events)))
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// eventJSONField is an exported Event field with its JSON name
+type eventJSONField struct {
+	name      string
+	index     int
+	omitEmpty bool
+}
+
+// eventJSONFields lists the Event fields in the order encoding/json writes them
+var eventJSONFields = func() []eventJSONField {
+	var fields []eventJSONField
+	t := reflect.TypeOf(Event{})
+	for i := 0; i < t.NumField(); i++ {
+		tag, ok := t.Field(i).Tag.Lookup("json")
+		if !ok || !t.Field(i).IsExported() {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		fields = append(fields, eventJSONField{name: name, index: i, omitEmpty: options == "omitempty"})
+	}
+	return fields
+}()
+
+// ParseStripFields parses the comma-separated STRIP_FIELDS list. Names are
+// matched case-insensitively against the event's JSON fields, so both userid
+// and UserID are accepted.
+func ParseStripFields(list string) (map[string]bool, error) {
+	strip := make(map[string]bool)
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		found := false
+		for _, field := range eventJSONFields {
+			if field.name == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown event field %q", name)
+		}
+		strip[name] = true
+	}
+	return strip, nil
+}
+
+// marshalEvent encodes an event like json.Marshal, leaving out the fields in
+// strip. The event itself is not modified.
+func marshalEvent(event Event, strip map[string]bool) ([]byte, error) {
+	if len(strip) == 0 {
+		return json.Marshal(event)
+	}
+
+	var b bytes.Buffer
+	b.WriteByte('{')
+	v := reflect.ValueOf(event)
+	for _, field := range eventJSONFields {
+		value := v.Field(field.index)
+		if strip[field.name] || (field.omitEmpty && value.IsZero()) {
+			continue
+		}
+		encoded, err := json.Marshal(value.Interface())
+		if err != nil {
+			return nil, err
+		}
+		if b.Len() > 1 {
+			b.WriteByte(',')
+		}
+		b.WriteString(`"` + field.name + `":`)
+		b.Write(encoded)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// stripHeaders drops the payload-mode metadata headers of stripped fields
+func stripHeaders(headers []kafka.Header, strip map[string]bool) []kafka.Header {
+	if len(strip) == 0 {
+		return headers
+	}
+	kept := headers[:0]
+	for _, header := range headers {
+		if !strip[header.Key] {
+			kept = append(kept, header)
+		}
+	}
+	return kept
+}
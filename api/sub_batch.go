@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// subBatch is the range [start, end) of a topic's messages written with one
+// WriteMessages call
+type subBatch struct {
+	start, end int
+}
+
+// splitBatches splits messages into consecutive sub-batches of at most maxBytes,
+// counted the way kafka-go checks messages against Writer.BatchBytes, and of at
+// most maxMessages when it is above 0. A message larger than maxBytes gets a
+// sub-batch of its own, so only that message fails.
+func splitBatches(messages []kafka.Message, maxBytes, maxMessages int) []subBatch {
+	var batches []subBatch
+	start, size := 0, 0
+	for i, message := range messages {
+		n := messageBytes(message)
+		if i > start && (size+n > maxBytes || (maxMessages > 0 && i-start >= maxMessages)) {
+			batches = append(batches, subBatch{start: start, end: i})
+			start, size = i, 0
+		}
+		size += n
+	}
+	if start < len(messages) {
+		batches = append(batches, subBatch{start: start, end: len(messages)})
+	}
+	return batches
+}
+
+// messageBytes is the size of a message as kafka-go's Message.totalSize counts it
+func messageBytes(m kafka.Message) int {
+	const fixed = 4 + 1 + 1 + 8 // length, magic byte, attributes, timestamp
+	size := fixed + 4 + len(m.Key) + 4 + len(m.Value)
+
+	size += varintBytes(len(m.Headers))
+	for _, h := range m.Headers {
+		size += varintBytes(len(h.Key)) + len(h.Key) + varintBytes(len(h.Value)) + len(h.Value)
+	}
+	return size
+}
+
+// varintBytes is the length of n as a zigzag-encoded varint
+func varintBytes(n int) int {
+	u := uint64(n<<1) ^ uint64(n>>63)
+	size := 1
+	for u >= 0x80 {
+		u >>= 7
+		size++
+	}
+	return size
+}
+
+// batchWriteError is recorded under each event ID of a sub-batch that failed to be
+// written while other sub-batches of the topic succeeded. Like a topic-level error
+// it means Kafka could not take the events, so they count against the breaker and
+// are held for a retry.
+type batchWriteError struct {
+	err error
+}
+
+func (e *batchWriteError) Error() string {
+	return e.err.Error()
+}
+
+func (e *batchWriteError) Unwrap() error {
+	return e.err
+}
+
+// isMessageTooLarge reports whether kafka-go rejected a message larger than BatchBytes
+func isMessageTooLarge(err error) bool {
+	var tooLarge kafka.MessageTooLargeError
+	return errors.As(err, &tooLarge)
+}
@@ -88,7 +88,7 @@ response=$(curl -s -w "\n%{http_code}" -X POST \
 http_code=$(echo "$response" | tail -n1)
 body=$(echo "$response" | head -n1)
 
-if [ "$http_code" -eq 200 ]; then
+if [ "$http_code" -eq 207 ]; then
     echo -e "${GREEN}✓ Invalid events test passed${NC}"
     echo "Response: $body"
     
@@ -99,7 +99,7 @@ if [ "$http_code" -eq 200 ]; then
         echo -e "${RED}✗ Invalid event not correctly identified${NC}"
     fi
 else
-    echo -e "${RED}✗ Invalid events test failed (HTTP $http_code)${NC}"
+    echo -e "${RED}✗ Invalid events test failed (HTTP $http_code, expected 207)${NC}"
     echo "Response: $body"
 fi
 
@@ -143,12 +143,558 @@ response=$(curl -s -w "\n%{http_code}" -X POST \
 http_code=$(echo "$response" | tail -n1)
 body=$(echo "$response" | head -n1)
 
-if [ "$http_code" -eq 200 ]; then
+if [ "$http_code" -eq 207 ] && echo "$body" | grep -q '"successEventIds":\["valid-event-1"\]'; then
     echo -e "${GREEN}✓ Mixed events test passed${NC}"
     echo "Response: $body"
 else
-    echo -e "${RED}✗ Mixed events test failed (HTTP $http_code)${NC}"
+    echo -e "${RED}✗ Mixed events test failed (HTTP $http_code, expected 207)${NC}"
+    echo "Response: $body"
+fi
+
+echo ""
+
+# Test 5: Send events with an unknown (misspelled) field
+echo -e "${YELLOW}5. Testing unknown fields...${NC}"
+unknown_json='[{
+    "id": "unknown-field-event",
+    "domain": "TestDomain",
+    "subdomain": "TestSubdomain",
+    "code": "TestCode",
+    "channel_id": 37
+}]'
+
+response=$(curl -s -w "\n%{http_code}" -X POST \
+  -H "Content-Type: application/json" \
+  -d "$unknown_json" \
+  "$API_URL/events")
+
+http_code=$(echo "$response" | tail -n1)
+body=$(echo "$response" | head -n1)
+
+if [ "$http_code" -eq 400 ] && echo "$body" | grep -q "channel_id"; then
+    echo -e "${GREEN}✓ Unknown field correctly rejected${NC}"
+    echo "Response: $body"
+else
+    echo -e "${RED}✗ Unknown fields test failed (HTTP $http_code, expected 400 with STRICT_JSON enabled)${NC}"
+    echo "Response: $body"
+fi
+
+# Test 6: Validate events without producing them
+echo -e "${YELLOW}6. Testing validate endpoint...${NC}"
+validate_json='[{
+    "id": "validate-ok",
+    "domain": "TestDomain",
+    "subdomain": "TestSubdomain",
+    "code": "TestCode"
+}, {
+    "id": "validate-missing-code",
+    "domain": "TestDomain",
+    "subdomain": "TestSubdomain"
+}]'
+
+response=$(curl -s -w "\n%{http_code}" -X POST \
+  -H "Content-Type: application/json" \
+  -d "$validate_json" \
+  "$API_URL/events/validate")
+
+http_code=$(echo "$response" | tail -n1)
+body=$(echo "$response" | head -n1)
+
+if [ "$http_code" -eq 200 ] && echo "$body" | grep -q '"valid":1,"invalid":1' && echo "$body" | grep -q "code is required"; then
+    echo -e "${GREEN}✓ Validation report returned${NC}"
+    echo "Response: $body"
+else
+    echo -e "${RED}✗ Validate test failed (HTTP $http_code)${NC}"
+    echo "Response: $body"
+fi
+
+echo ""
+
+# Test 7: Empty array and empty body
+echo -e "${YELLOW}7. Testing empty requests...${NC}"
+response=$(curl -s -w "\n%{http_code}" -X POST \
+  -H "Content-Type: application/json" \
+  -d '[]' \
+  "$API_URL/events")
+
+http_code=$(echo "$response" | tail -n1)
+body=$(echo "$response" | head -n1)
+
+# With ALLOW_EMPTY_BATCH=false the API must have been started with the same setting
+if [ "$ALLOW_EMPTY_BATCH" = "false" ]; then
+    if [ "$http_code" -eq 400 ] && echo "$body" | grep -q "Request contains no events"; then
+        echo -e "${GREEN}✓ Empty array correctly rejected${NC}"
+        echo "Response: $body"
+    else
+        echo -e "${RED}✗ Empty array test failed (HTTP $http_code, expected 400)${NC}"
+        echo "Response: $body"
+    fi
+elif [ "$http_code" -eq 200 ] && echo "$body" | grep -q '"successEventIds":\[\],"invalidEventIds":\[\]'; then
+    echo -e "${GREEN}✓ Empty array returns empty results${NC}"
+    echo "Response: $body"
+else
+    echo -e "${RED}✗ Empty array test failed (HTTP $http_code, expected 200)${NC}"
+    echo "Response: $body"
+fi
+
+response=$(curl -s -w "\n%{http_code}" -X POST \
+  -H "Content-Type: application/json" \
+  "$API_URL/events")
+
+http_code=$(echo "$response" | tail -n1)
+body=$(echo "$response" | head -n1)
+
+if [ "$http_code" -eq 400 ] && echo "$body" | grep -q "Request body is empty"; then
+    echo -e "${GREEN}✓ Empty body correctly rejected${NC}"
+    echo "Response: $body"
+else
+    echo -e "${RED}✗ Empty body test failed (HTTP $http_code, expected 400)${NC}"
+    echo "Response: $body"
+fi
+
+echo ""
+
+# Test 8: Per-request ack level
+echo -e "${YELLOW}8. Testing ack level headers...${NC}"
+response=$(curl -s -w "\n%{http_code}" -X POST \
+  -H "Content-Type: application/json" \
+  -H "X-Required-Acks: maybe" \
+  -d "$valid_json" \
+  "$API_URL/events")
+
+http_code=$(echo "$response" | tail -n1)
+body=$(echo "$response" | head -n1)
+
+if [ "$http_code" -eq 200 ]; then
+    echo -e "${GREEN}✓ Invalid X-Required-Acks falls back to the default${NC}"
+    echo "Response: $body"
+else
+    echo -e "${RED}✗ X-Required-Acks test failed (HTTP $http_code, expected 200)${NC}"
+    echo "Response: $body"
+fi
+
+response=$(curl -s -w "\n%{http_code}" -X POST \
+  -H "Content-Type: application/json" \
+  -H "X-Kafka-Acks: maybe" \
+  -d "$valid_json" \
+  "$API_URL/events")
+
+http_code=$(echo "$response" | tail -n1)
+body=$(echo "$response" | head -n1)
+
+if [ "$http_code" -eq 400 ]; then
+    echo -e "${GREEN}✓ Invalid X-Kafka-Acks correctly rejected${NC}"
+    echo "Response: $body"
+else
+    echo -e "${RED}✗ X-Kafka-Acks test failed (HTTP $http_code, expected 400)${NC}"
     echo "Response: $body"
 fi
 
+# Test 9: Invalid JSON; the parse detail is only checked when the API was started
+# with the same VERBOSE_ERRORS
+echo -e "${YELLOW}9. Testing invalid JSON responses...${NC}"
+check_invalid_json() {
+    name=$1
+    body_json=$2
+    detail=$3
+    response=$(curl -s -w "\n%{http_code}" -X POST \
+      -H "Content-Type: application/json" \
+      -d "$body_json" \
+      "$API_URL/events")
+
+    http_code=$(echo "$response" | tail -n1)
+    body=$(echo "$response" | head -n1)
+
+    ok=false
+    if [ "$http_code" -eq 400 ] && echo "$body" | grep -q '"error":"Invalid JSON format"'; then
+        if [ "$VERBOSE_ERRORS" = "true" ]; then
+            echo "$body" | grep -q "\"detail\":\"[^\"]*$detail[^\"]*(line 2, column" && ok=true
+        elif ! echo "$body" | grep -q '"detail"'; then
+            ok=true
+        fi
+    fi
+    if [ "$ok" = true ]; then
+        echo -e "${GREEN}✓ ${name} error correctly reported${NC}"
+        echo "Response: $body"
+    else
+        echo -e "${RED}✗ Invalid JSON test failed for ${name} error (HTTP $http_code)${NC}"
+        echo "Response: $body"
+    fi
+}
+check_invalid_json "Syntax" '[{"id": "syntax-1",
+  "domain": TestDomain}]' "invalid character"
+check_invalid_json "Type" '[{"id": "type-1",
+  "eventtimestamp": "1746788536758340000"}]' "cannot unmarshal string"
+check_invalid_json "Object followed by array" '{"id": "mix-1", "domain": "TestDomain", "subdomain": "TestSubdomain", "code": "TestCode"}
+  [{"id": "mix-2"}]' "after top-level value"
+check_invalid_json "Array followed by object" '[{"id": "mix-3", "domain": "TestDomain", "subdomain": "TestSubdomain", "code": "TestCode"}]
+  {"id": "mix-4"}' "after top-level value"
+
+echo ""
+
+# Test 10: Fixed topic, only when the API was started with the same FIXED_TOPIC
+if [ -n "$FIXED_TOPIC" ]; then
+    echo ""
+    echo -e "${YELLOW}10. Testing fixed topic $FIXED_TOPIC...${NC}"
+    fixed_json='[
+      {"id": "fixed-1", "domain": "Banking", "subdomain": "Domestic", "code": "Created"},
+      {"id": "fixed-2", "domain": "Retail", "subdomain": "Orders", "code": "Shipped"}
+    ]'
+    response=$(curl -s -w "\n%{http_code}" -X POST \
+      -H "Content-Type: application/json" \
+      -H "X-Return-Topics: true" \
+      -d "$fixed_json" \
+      "$API_URL/events")
+
+    http_code=$(echo "$response" | tail -n1)
+    body=$(echo "$response" | head -n1)
+
+    if [ "$http_code" -eq 200 ] && echo "$body" | grep -q "\"fixed-1\":\"$FIXED_TOPIC\"" && echo "$body" | grep -q "\"fixed-2\":\"$FIXED_TOPIC\""; then
+        echo -e "${GREEN}✓ Events of different domains produced to $FIXED_TOPIC${NC}"
+        echo "Response: $body"
+    else
+        echo -e "${RED}✗ Fixed topic test failed (HTTP $http_code)${NC}"
+        echo "Response: $body"
+    fi
+fi
+
+# Test 11: Positive numeric fields, only when the API was started with the same
+# POSITIVE_INT_FIELDS. Each field is sent as 0 with the others positive.
+if [ -n "$POSITIVE_INT_FIELDS" ]; then
+    echo ""
+    echo -e "${YELLOW}11. Testing positive int fields $POSITIVE_INT_FIELDS...${NC}"
+    for field in branchid channelid customerid userid; do
+        positive_json="[{\"id\": \"positive-$field\", \"domain\": \"TestDomain\", \"subdomain\": \"TestSubdomain\", \"code\": \"TestCode\",
+          \"branchid\": 1, \"channelid\": 1, \"customerid\": 1, \"userid\": 1, \"$field\": 0}]"
+        response=$(curl -s -w "\n%{http_code}" -X POST \
+          -H "Content-Type: application/json" \
+          -d "$positive_json" \
+          "$API_URL/events/validate")
+
+        http_code=$(echo "$response" | tail -n1)
+        body=$(echo "$response" | head -n1)
+
+        if echo ",$(echo "$POSITIVE_INT_FIELDS" | tr 'A-Z' 'a-z' | tr -d ' ')," | grep -q ",$field,"; then
+            expected='"valid":0,"invalid":1'
+            reason="$field must be positive, got 0"
+        else
+            expected='"valid":1,"invalid":0'
+            reason=""
+        fi
+        if [ "$http_code" -eq 200 ] && echo "$body" | grep -q "$expected" && echo "$body" | grep -q "$reason"; then
+            echo -e "${GREEN}✓ $field: ${expected}${NC}"
+        else
+            echo -e "${RED}✗ Positive int field test failed for $field (HTTP $http_code)${NC}"
+            echo "Response: $body"
+        fi
+    done
+fi
+
+# Test 12: Duplicates across requests, only when the API was started with
+# ACCUMULATE_EVENTS=true and the same DEDUP_CACHE_SIZE
+if [ -n "$DEDUP_CACHE_SIZE" ]; then
+    echo ""
+    echo -e "${YELLOW}12. Testing dedup of events sent in separate requests...${NC}"
+    dedup_id="dedup-$(date +%s%N)"
+    dedup_json="[{\"id\": \"$dedup_id\", \"domain\": \"TestDomain\", \"subdomain\": \"TestSubdomain\", \"code\": \"TestCode\"}]"
+    tickets=()
+    for attempt in 1 2; do
+        body=$(curl -s -X POST -H "Content-Type: application/json" -d "$dedup_json" "$API_URL/events")
+        tickets+=("$(echo "$body" | sed -n 's/.*"ticket":"\([^"]*\)".*/\1/p')")
+        # Let the first submission be flushed before the retry arrives
+        sleep 1
+    done
+
+    first=$(curl -s "$API_URL/events/tickets/${tickets[0]}")
+    second=$(curl -s "$API_URL/events/tickets/${tickets[1]}")
+    if echo "$first" | grep -q "\"successEventIds\":\[\"$dedup_id\"\]" && echo "$second" | grep -q "\"duplicateEventIds\":\[\"$dedup_id\"\]"; then
+        echo -e "${GREEN}✓ Second submission of $dedup_id dropped as a duplicate${NC}"
+        echo "Response: $second"
+    else
+        echo -e "${RED}✗ Dedup test failed${NC}"
+        echo "First: $first"
+        echo "Second: $second"
+    fi
+fi
+
+# Test 13: Per-domain rate limits, only when the API was started with a limit of
+# 1 event per second for RATE_LIMITED_DOMAIN in DOMAIN_RATE_LIMITS. Only that
+# domain's events beyond the limit are rejected; another domain's event goes through.
+if [ -n "$RATE_LIMITED_DOMAIN" ]; then
+    echo ""
+    echo -e "${YELLOW}13. Testing the rate limit of domain $RATE_LIMITED_DOMAIN...${NC}"
+    limited_json="["
+    for i in 1 2 3; do
+        limited_json="$limited_json{\"id\": \"limited-$i\", \"domain\": \"$RATE_LIMITED_DOMAIN\", \"subdomain\": \"TestSubdomain\", \"code\": \"TestCode\"},"
+    done
+    limited_json="$limited_json{\"id\": \"unlimited-1\", \"domain\": \"TestDomain\", \"subdomain\": \"TestSubdomain\", \"code\": \"TestCode\"}]"
+    response=$(curl -s -w "\n%{http_code}" -X POST \
+      -H "Content-Type: application/json" \
+      -d "$limited_json" \
+      "$API_URL/events")
+
+    http_code=$(echo "$response" | tail -n1)
+    body=$(echo "$response" | head -n1)
+
+    if echo "$body" | grep -q '"rateLimitedEventIds":\["limited-2","limited-3"\]' && echo "$body" | grep -q '"unlimited-1"' && \
+       ! echo "$body" | grep -q '"rateLimitedEventIds":\[[^]]*unlimited-1'; then
+        echo -e "${GREEN}✓ Events beyond the limit of $RATE_LIMITED_DOMAIN rate limited (HTTP $http_code)${NC}"
+        echo "Response: $body"
+    else
+        echo -e "${RED}✗ Domain rate limit test failed (HTTP $http_code)${NC}"
+        echo "Response: $body"
+    fi
+
+    if curl -s "$API_URL/metrics" | grep -q "domain_events_total{domain=\"$RATE_LIMITED_DOMAIN\",outcome=\"rate_limited\"}"; then
+        echo -e "${GREEN}✓ domain_events_total reports the rate limited events${NC}"
+    else
+        echo -e "${RED}✗ domain_events_total has no rate_limited series for $RATE_LIMITED_DOMAIN${NC}"
+    fi
+fi
+
+# Test 14: Event time format, only when the API was started with the same
+# EVENT_TIME_FORMAT. An eventtime in no known format must be rejected. The
+# other tests send RFC3339 eventtime values, so they expect rfc3339.
+if [ -n "$EVENT_TIME_FORMAT" ]; then
+    echo ""
+    echo -e "${YELLOW}14. Testing eventtime format $EVENT_TIME_FORMAT...${NC}"
+    time_json='[{"id": "bad-time", "domain": "TestDomain", "subdomain": "TestSubdomain", "code": "TestCode", "eventtime": "not-a-time"}]'
+    response=$(curl -s -w "\n%{http_code}" -X POST \
+      -H "Content-Type: application/json" \
+      -d "$time_json" \
+      "$API_URL/events/validate")
+
+    http_code=$(echo "$response" | tail -n1)
+    body=$(echo "$response" | head -n1)
+
+    if [ "$http_code" -eq 200 ] && echo "$body" | grep -q '"valid":0,"invalid":1' && echo "$body" | grep -q 'eventtime'; then
+        echo -e "${GREEN}✓ Unparseable eventtime rejected${NC}"
+        echo "Response: $body"
+    else
+        echo -e "${RED}✗ Event time format test failed (HTTP $http_code)${NC}"
+        echo "Response: $body"
+    fi
+fi
+
+# Test 15: Server stats, which count at least the requests sent above
+echo ""
+echo -e "${YELLOW}15. Testing server stats...${NC}"
+response=$(curl -s -w "\n%{http_code}" "$API_URL/protected/stats")
+
+http_code=$(echo "$response" | tail -n1)
+body=$(echo "$response" | head -n1)
+
+if [ "$http_code" -eq 200 ] && echo "$body" | grep -q '"totalRequests":[1-9]' && echo "$body" | grep -q '"uptimeSeconds"'; then
+    echo -e "${GREEN}✓ Server stats returned${NC}"
+    echo "Response: $body"
+else
+    echo -e "${RED}✗ Server stats test failed (HTTP $http_code)${NC}"
+    echo "Response: $body"
+fi
+
+# Test 16: Numeric IDs may be sent as strings, but must hold an integer
+echo ""
+echo -e "${YELLOW}16. Testing string-encoded numeric IDs...${NC}"
+ids_json='[{"id": "string-ids-1", "domain": "TestDomain", "subdomain": "TestSubdomain", "code": "TestCode", "customerid": "9007199254740993", "userid": 78942}]'
+response=$(curl -s -w "\n%{http_code}" -X POST \
+  -H "Content-Type: application/json" \
+  -d "$ids_json" \
+  "$API_URL/events/validate")
+
+http_code=$(echo "$response" | tail -n1)
+body=$(echo "$response" | head -n1)
+
+if [ "$http_code" -eq 200 ] && echo "$body" | grep -q '"valid":1'; then
+    echo -e "${GREEN}✓ String-encoded customerid accepted${NC}"
+    echo "Response: $body"
+else
+    echo -e "${RED}✗ String-encoded customerid test failed (HTTP $http_code)${NC}"
+    echo "Response: $body"
+fi
+
+response=$(curl -s -w "\n%{http_code}" -X POST \
+  -H "Content-Type: application/json" \
+  -d '[{"id": "string-ids-2", "domain": "TestDomain", "subdomain": "TestSubdomain", "code": "TestCode", "customerid": "abc"}, {"id": "string-ids-3", "domain": "TestDomain", "subdomain": "TestSubdomain", "code": "TestCode", "customerid": "42"}]' \
+  "$API_URL/events/validate")
+
+http_code=$(echo "$response" | tail -n1)
+body=$(echo "$response" | head -n1)
+
+if [ "$http_code" -eq 200 ] && echo "$body" | grep -q '"valid":1,"invalid":1' && echo "$body" | grep -q 'customerid must be a 64-bit integer'; then
+    echo -e "${GREEN}✓ Non-numeric customerid rejects only its event${NC}"
+    echo "Response: $body"
+else
+    echo -e "${RED}✗ Non-numeric customerid test failed (HTTP $http_code)${NC}"
+    echo "Response: $body"
+fi
+
+# Test 17: X-Kafka-Partition pins a request to partition 0, which every topic has;
+# a partition no test topic has and a non-numeric value are rejected
+echo ""
+echo -e "${YELLOW}17. Testing X-Kafka-Partition...${NC}"
+partition_json='[{"id": "partition-1", "domain": "TestDomain", "subdomain": "TestSubdomain", "code": "TestCode"}]'
+check_partition() {
+    partition=$1
+    expected=$2
+    http_code=$(curl -s -o /dev/null -w "%{http_code}" -X POST \
+      -H "Content-Type: application/json" \
+      -H "X-Kafka-Partition: $partition" \
+      -d "$partition_json" \
+      "$API_URL/events")
+
+    if [ "$http_code" -eq "$expected" ]; then
+        echo -e "${GREEN}✓ X-Kafka-Partition $partition answered with HTTP $http_code${NC}"
+    else
+        echo -e "${RED}✗ X-Kafka-Partition $partition answered with HTTP $http_code, expected $expected${NC}"
+    fi
+}
+check_partition 0 200
+check_partition 10000 400
+check_partition abc 400
+
+# Test 18: A topic set on the event replaces the computed one and must be a valid
+# topic name; skipped with FIXED_TOPIC, which sends every event to one topic
+if [ -z "$FIXED_TOPIC" ]; then
+    echo ""
+    echo -e "${YELLOW}18. Testing per-event topic override...${NC}"
+    topic_json='[{"id": "topic-override-1", "domain": "TestDomain", "subdomain": "TestSubdomain", "code": "TestCode", "topic": "Test.Override-Topic"}, {"id": "topic-override-2", "domain": "TestDomain", "subdomain": "TestSubdomain", "code": "TestCode", "topic": "not a topic"}]'
+    response=$(curl -s -w "\n%{http_code}" -X POST \
+      -H "Content-Type: application/json" \
+      -d "$topic_json" \
+      "$API_URL/events/validate")
+
+    http_code=$(echo "$response" | tail -n1)
+    body=$(echo "$response" | head -n1)
+
+    if [ "$http_code" -eq 200 ] && echo "$body" | grep -q '"id":"topic-override-1","valid":true,"topic":"Test.Override-Topic"' \
+        && echo "$body" | grep -q '"id":"topic-override-2","valid":false'; then
+        echo -e "${GREEN}✓ Event topic used, invalid event topic rejected${NC}"
+        echo "Response: $body"
+    else
+        echo -e "${RED}✗ Per-event topic override test failed (HTTP $http_code)${NC}"
+        echo "Response: $body"
+    fi
+fi
+
+# Test 19: X-Sync waits for the broker and reports the time it took; an invalid
+# value is rejected
+echo ""
+echo -e "${YELLOW}19. Testing X-Sync...${NC}"
+sync_json='[{"id": "sync-1", "domain": "TestDomain", "subdomain": "TestSubdomain", "code": "TestCode"}]'
+response=$(curl -s -D - -o /dev/null -X POST \
+  -H "Content-Type: application/json" \
+  -H "X-Sync: true" \
+  -d "$sync_json" \
+  "$API_URL/events")
+
+if echo "$response" | head -n1 | grep -q " 200" && echo "$response" | grep -qi '^Server-Timing: produce;desc="sync"'; then
+    echo -e "${GREEN}✓ X-Sync request produced with Server-Timing${NC}"
+else
+    echo -e "${RED}✗ X-Sync request failed${NC}"
+    echo "Response headers: $response"
+fi
+
+http_code=$(curl -s -o /dev/null -w "%{http_code}" -X POST \
+  -H "Content-Type: application/json" \
+  -H "X-Sync: maybe" \
+  -d "$sync_json" \
+  "$API_URL/events")
+
+if [ "$http_code" -eq 400 ]; then
+    echo -e "${GREEN}✓ Invalid X-Sync rejected (HTTP $http_code)${NC}"
+else
+    echo -e "${RED}✗ Invalid X-Sync not rejected (HTTP $http_code)${NC}"
+fi
+
+# Test 20: Large responses are gzipped for clients that accept it and sent as is
+# otherwise; skipped when RESPONSE_COMPRESSION_MIN_BYTES=0 disabled compression
+if [ "$RESPONSE_COMPRESSION_MIN_BYTES" != "0" ]; then
+    echo ""
+    echo -e "${YELLOW}20. Testing response compression...${NC}"
+    compression_json="[$(for i in $(seq 1 300); do printf '{"id": "compression-%d", "domain": "TestDomain", "subdomain": "TestSubdomain", "code": "TestCode"},' "$i"; done | sed 's/,$//')]"
+    gzip_headers=$(curl -s -D - -o /dev/null -X POST \
+      -H "Content-Type: application/json" \
+      -H "Accept-Encoding: gzip" \
+      -d "$compression_json" \
+      "$API_URL/events/validate")
+    plain_headers=$(curl -s -D - -o /dev/null -X POST \
+      -H "Content-Type: application/json" \
+      -d "$compression_json" \
+      "$API_URL/events/validate")
+
+    if echo "$gzip_headers" | grep -qi '^Content-Encoding: gzip' && ! echo "$plain_headers" | grep -qi '^Content-Encoding'; then
+        echo -e "${GREEN}✓ Large response gzipped only when Accept-Encoding allows it${NC}"
+    else
+        echo -e "${RED}✗ Response compression test failed${NC}"
+        echo "Headers with gzip: $gzip_headers"
+        echo "Headers without: $plain_headers"
+    fi
+fi
+
+# Test 21: The self-test round trip succeeds, and a second call right after it is rate limited
+echo ""
+echo -e "${YELLOW}21. Testing self-test...${NC}"
+response=$(curl -s -w "\n%{http_code}" "$API_URL/protected/selftest")
+http_code=$(echo "$response" | tail -n1)
+body=$(echo "$response" | head -n1)
+second_code=$(curl -s -o /dev/null -w "%{http_code}" "$API_URL/protected/selftest")
+
+if [ "$http_code" -eq 200 ] && echo "$body" | grep -q '"ok":true' && [ "$second_code" -eq 429 ]; then
+    echo -e "${GREEN}✓ Self-test succeeded, repeated call rate limited${NC}"
+    echo "Response: $body"
+else
+    echo -e "${RED}✗ Self-test failed (HTTP $http_code, then HTTP $second_code)${NC}"
+    echo "Response: $body"
+fi
+
+# Test 22: A single event object is answered exactly like a one-element array
+echo ""
+echo -e "${YELLOW}22. Testing single event object...${NC}"
+single_event='{"id": "single-1", "domain": "TestDomain", "subdomain": "TestSubdomain", "code": "TestCode"}'
+object_response=$(curl -s -w "\n%{http_code}" -X POST \
+  -H "Content-Type: application/json" \
+  -d "$single_event" \
+  "$API_URL/events")
+array_response=$(curl -s -w "\n%{http_code}" -X POST \
+  -H "Content-Type: application/json" \
+  -d "[$single_event]" \
+  "$API_URL/events")
+
+if [ "$object_response" = "$array_response" ] && echo "$object_response" | head -n1 | grep -q '"successEventIds":\["single-1"\]' \
+    && [ "$(echo "$object_response" | tail -n1)" -eq 200 ]; then
+    echo -e "${GREEN}✓ Single object and array answered the same${NC}"
+    echo "Response: $(echo "$object_response" | head -n1)"
+else
+    echo -e "${RED}✗ Single event object test failed${NC}"
+    echo "Object response: $object_response"
+    echo "Array response: $array_response"
+fi
+
+# Test 23: Every /events response reports the async messages still waiting in
+# the writers; with FLUSH_ON_BATCH_COMPLETE=true it also reports the flush wait
+echo ""
+echo -e "${YELLOW}23. Testing X-Pending-Messages...${NC}"
+pending_json='[{"id": "pending-1", "domain": "TestDomain", "subdomain": "TestSubdomain", "code": "TestCode"}]'
+response=$(curl -s -D - -o /dev/null -X POST \
+  -H "Content-Type: application/json" \
+  -d "$pending_json" \
+  "$API_URL/events")
+
+if echo "$response" | head -n1 | grep -q " 200" && echo "$response" | grep -qiE '^X-Pending-Messages: [0-9]+'; then
+    echo -e "${GREEN}✓ Pending message count reported${NC}"
+    echo "$response" | grep -i '^X-Pending-Messages'
+else
+    echo -e "${RED}✗ X-Pending-Messages missing${NC}"
+    echo "Response headers: $response"
+fi
+
+if [ "$FLUSH_ON_BATCH_COMPLETE" = "true" ]; then
+    if echo "$response" | grep -qi '^Server-Timing: produce;desc="flush"'; then
+        echo -e "${GREEN}✓ Flush wait reported in Server-Timing${NC}"
+    else
+        echo -e "${RED}✗ Flush wait not reported${NC}"
+        echo "Response headers: $response"
+    fi
+fi
+
 echo -e "\n${YELLOW}Testing completed!${NC}"
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// errThrottled is returned for events that did not get a token from the global
+// EVENTS_PER_SECOND_LIMIT budget within the allowed wait
+var errThrottled = errors.New("events per second limit exceeded")
+
+// Throttle caps the events produced per second across all requests. Each event
+// takes one token; a batch waits up to maxWait for its tokens or is rejected.
+type Throttle struct {
+	limiter *rate.Limiter
+	maxWait time.Duration
+	lite    bool
+
+	// consumed counts admitted events since the last rate update
+	consumed atomic.Int64
+
+	stopOnce sync.Once
+	stop     chan struct{}
+}
+
+// NewThrottle creates a throttle allowing limit events per second, with a burst
+// of one second's worth. Unless lite is set, the consumed rate is published
+// every second.
+func NewThrottle(limit float64, maxWait time.Duration, lite bool) *Throttle {
+	t := &Throttle{
+		limiter: rate.NewLimiter(rate.Limit(limit), max(int(limit), 1)),
+		maxWait: maxWait,
+		lite:    lite,
+		stop:    make(chan struct{}),
+	}
+	if !lite {
+		go t.publishRate()
+	}
+	return t
+}
+
+// Wait takes n tokens, sleeping until they are available. It returns errThrottled
+// without taking any when that would take longer than maxWait. Batches larger than
+// the burst are reserved in burst-sized parts.
+func (t *Throttle) Wait(n int) error {
+	now := time.Now()
+	var reservations []*rate.Reservation
+	var delay time.Duration
+	for remaining := n; remaining > 0; {
+		part := min(remaining, t.limiter.Burst())
+		r := t.limiter.ReserveN(now, part)
+		reservations = append(reservations, r)
+		delay = r.DelayFrom(now)
+		remaining -= part
+	}
+
+	if delay > t.maxWait {
+		// Cancelled newest first, so each one can hand its tokens back
+		for i := len(reservations) - 1; i >= 0; i-- {
+			reservations[i].CancelAt(now)
+		}
+		if !t.lite {
+			throttledEventsTotal.Add(float64(n))
+		}
+		return errThrottled
+	}
+	time.Sleep(delay)
+	t.consumed.Add(int64(n))
+	return nil
+}
+
+// Close stops publishing the consumed rate
+func (t *Throttle) Close() {
+	t.stopOnce.Do(func() { close(t.stop) })
+}
+
+// publishRate sets the throttle_events_per_second gauge to the events admitted
+// during the last second
+func (t *Throttle) publishRate() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	last := time.Now()
+	for {
+		select {
+		case now := <-ticker.C:
+			throttleEventsPerSecond.Set(float64(t.consumed.Swap(0)) / now.Sub(last).Seconds())
+			last = now
+		case <-t.stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// defaultTopicTemplate is the topic naming used when TOPIC_TEMPLATE is not set
+const defaultTopicTemplate = "{domain}_{subdomain}_{code}"
+
+// TopicTemplate names topics from any event field. It accepts the {field}
+// placeholders of defaultTopicTemplate, optionally with one integer operation
+// such as {customerid%16}, or a text/template using the same field names
+// (e.g. {{.domain}}-shard{{mod .customerid 16}}).
+type TopicTemplate struct {
+	tmpl *template.Template
+}
+
+// topicPlaceholder matches {field} and {field<op>number} placeholders
+var topicPlaceholder = regexp.MustCompile(`\{\s*([a-z]+)\s*(?:([-+*/%])\s*(\d+)\s*)?\}`)
+
+// topicPlaceholderFuncs maps placeholder operators to template functions
+var topicPlaceholderFuncs = map[string]string{
+	"+": "add",
+	"-": "sub",
+	"*": "mul",
+	"/": "div",
+	"%": "mod",
+}
+
+// topicTemplateFuncs is the integer arithmetic available to topic templates
+var topicTemplateFuncs = template.FuncMap{
+	"add": func(a, b int64) int64 { return a + b },
+	"sub": func(a, b int64) int64 { return a - b },
+	"mul": func(a, b int64) int64 { return a * b },
+	"div": func(a, b int64) (int64, error) {
+		if b == 0 {
+			return 0, errors.New("division by zero")
+		}
+		return a / b, nil
+	},
+	"mod": func(a, b int64) (int64, error) {
+		if b == 0 {
+			return 0, errors.New("modulo by zero")
+		}
+		return a % b, nil
+	},
+}
+
+// validTopicName matches the characters Kafka allows in topic names
+var validTopicName = regexp.MustCompile(`^[a-zA-Z0-9._-]{1,249}$`)
+
+// sampleEvent fills every field, to check a template at startup
+var sampleEvent = Event{
+	EventTimestamp: 1746788536758340000,
+	EventTime:      "2025-05-09T14:02:16.75834+03:00",
+	ID:             "sample",
+	Domain:         "Domain",
+	Subdomain:      "Subdomain",
+	Code:           "Code",
+	Version:        "1.0",
+	BranchID:       8000,
+	ChannelID:      37,
+	CustomerID:     100537117,
+	UserID:         78942,
+}
+
+// ParseTopicTemplate parses a topic template and renders it for a sample event,
+// so unknown fields and templates producing invalid topic names fail at startup
+func ParseTopicTemplate(text string) (*TopicTemplate, error) {
+	if !strings.Contains(text, "{{") {
+		var unknown error
+		text = topicPlaceholder.ReplaceAllStringFunc(text, func(placeholder string) string {
+			m := topicPlaceholder.FindStringSubmatch(placeholder)
+			if _, ok := keyFields[m[1]]; !ok && unknown == nil {
+				unknown = fmt.Errorf("unknown placeholder %s", placeholder)
+			}
+			if m[2] == "" {
+				return "{{." + m[1] + "}}"
+			}
+			return fmt.Sprintf("{{%s .%s %s}}", topicPlaceholderFuncs[m[2]], m[1], m[3])
+		})
+		if unknown != nil {
+			return nil, unknown
+		}
+	}
+
+	tmpl, err := template.New("topic").Funcs(topicTemplateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	t := &TopicTemplate{tmpl: tmpl}
+
+	topic, err := t.Topic(sampleEvent)
+	if err != nil {
+		return nil, err
+	}
+	if !validTopicName.MatchString(topic) {
+		return nil, fmt.Errorf("sample event renders invalid topic name %q", topic)
+	}
+	return t, nil
+}
+
+// Topic renders the template for an event
+func (t *TopicTemplate) Topic(event Event) (string, error) {
+	var b strings.Builder
+	if err := t.tmpl.Execute(&b, topicTemplateData(event)); err != nil {
+		return "", fmt.Errorf("failed to render topic: %w", err)
+	}
+	return b.String(), nil
+}
+
+// topicTemplateData exposes the event fields by JSON name, with integers as
+// int64 so they can be used in arithmetic
+func topicTemplateData(event Event) map[string]any {
+	return map[string]any{
+		"id":             event.ID,
+		"domain":         event.Domain,
+		"subdomain":      event.Subdomain,
+		"code":           event.Code,
+		"version":        event.Version,
+		"eventtime":      event.EventTime,
+		"eventtimestamp": event.EventTimestamp,
+		"branchid":       int64(event.BranchID),
+		"channelid":      int64(event.ChannelID),
+		"customerid":     int64(event.CustomerID),
+		"userid":         int64(event.UserID),
+	}
+}
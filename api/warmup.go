@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// warmupTimeout bounds the WARMUP_TOPICS warmup, which holds back the start of the server
+const warmupTimeout = 10 * time.Second
+
+// Warmup prepares topics before the first request would: it creates each topic's
+// writer for the default ack level, makes sure the topic exists and looks up its
+// partitions, which connects the shared transport and caches the topic's
+// metadata. Topics are warmed in parallel, bounded by ProduceConcurrency. The
+// returned map has the topics that failed; their first write pays the cost again.
+func (kp *KafkaProducer) Warmup(ctx context.Context, topics []string) map[string]error {
+	var mu sync.Mutex
+	errors := make(map[string]error)
+
+	var group errgroup.Group
+	group.SetLimit(kp.config.ProduceConcurrency)
+	for _, topicName := range topics {
+		topicName := topicName
+		group.Go(func() error {
+			kp.writerFor(topicName, kp.config.RequiredAcks, false)
+			err := kp.ensureTopic(ctx, topicName)
+			if err == nil {
+				_, err = kp.config.PartitionCount(ctx, topicName)
+			}
+			if err != nil {
+				mu.Lock()
+				errors[topicName] = err
+				mu.Unlock()
+			}
+			return nil
+		})
+	}
+	_ = group.Wait() // errors are collected per topic, never returned
+
+	return errors
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// statsWriter is implemented by kafka.Writer. Other writers, like mockWriter,
+// have no stats to export.
+type statsWriter interface {
+	Stats() kafka.WriterStats
+}
+
+// runWriterStats exports the stats of every cached writer each interval until
+// stop is closed. kafka.Writer.Stats resets its counters on every call, so each
+// read returns what happened since the previous one.
+func (kp *KafkaProducer) runWriterStats(interval time.Duration, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			kp.exportWriterStats()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// exportWriterStats reads the stats of the cached writers into the kafka_writer_* metrics
+func (kp *KafkaProducer) exportWriterStats() {
+	kp.writersMu.Lock()
+	writers := make(map[writerKey]statsWriter, len(kp.writers))
+	pending := make(map[[2]string]int, len(kp.writers)) // X-Sync writers add to the labels of the async one
+	for key, tw := range kp.writers {
+		if sw, ok := tw.MessageWriter.(statsWriter); ok {
+			writers[key] = sw
+		}
+		pending[[2]string{key.topic, key.acks.String()}] += tw.pendingCount()
+	}
+	kp.writersMu.Unlock()
+	for labels, n := range pending {
+		writerPendingMessages.WithLabelValues(labels[0], labels[1]).Set(float64(n))
+	}
+	if kp.deadLetterWriter != nil {
+		if sw, ok := kp.deadLetterWriter.MessageWriter.(statsWriter); ok {
+			writers[writerKey{topic: kp.config.DeadLetterTopic, acks: kafka.RequireAll}] = sw
+		}
+	}
+
+	for key, sw := range writers {
+		stats := sw.Stats()
+		labels := []string{key.topic, key.acks.String()}
+
+		writerWritesTotal.WithLabelValues(labels...).Add(float64(stats.Writes))
+		writerMessagesTotal.WithLabelValues(labels...).Add(float64(stats.Messages))
+		writerBytesTotal.WithLabelValues(labels...).Add(float64(stats.Bytes))
+		writerErrorsTotal.WithLabelValues(labels...).Add(float64(stats.Errors))
+		writerRetriesTotal.WithLabelValues(labels...).Add(float64(stats.Retries))
+
+		// X-Sync writers share the labels of the async writer, so only their counters are added
+		if key.sync {
+			continue
+		}
+
+		// Averages are only meaningful for intervals in which batches were written
+		if stats.BatchSize.Count > 0 {
+			writerBatchSizeAvg.WithLabelValues(labels...).Set(float64(stats.BatchSize.Avg))
+			writerBatchBytesAvg.WithLabelValues(labels...).Set(float64(stats.BatchBytes.Avg))
+		}
+		if stats.BatchTime.Count > 0 {
+			writerBatchSecondsAvg.WithLabelValues(labels...).Set(stats.BatchTime.Avg.Seconds())
+		}
+		if stats.BatchQueueTime.Count > 0 {
+			writerBatchQueueSecondsAvg.WithLabelValues(labels...).Set(stats.BatchQueueTime.Avg.Seconds())
+		}
+		if stats.WriteTime.Count > 0 {
+			writerWriteSecondsAvg.WithLabelValues(labels...).Set(stats.WriteTime.Avg.Seconds())
+		}
+	}
+}
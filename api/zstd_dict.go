@@ -0,0 +1,90 @@
+package main
+
+import (
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/segmentio/kafka-go/compress"
+)
+
+// zstdDictCodec is a zstd codec that compresses with a trained dictionary. It replaces
+// kafka-go's zstd codec, so consumers must decompress with the same dictionary.
+// Frames written without a dictionary can still be read.
+type zstdDictCodec struct {
+	dict     []byte
+	encoders sync.Pool
+}
+
+// newZstdDictCodec checks that dict is a valid zstd dictionary and creates the codec
+func newZstdDictCodec(dict []byte) (*zstdDictCodec, error) {
+	encoder, err := zstd.NewWriter(nil, zstd.WithEncoderDict(dict), zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	c := &zstdDictCodec{dict: dict}
+	c.encoders.Put(encoder)
+	return c, nil
+}
+
+// installZstdDict makes every writer and reader using zstd compression use dict
+func installZstdDict(dict []byte) error {
+	codec, err := newZstdDictCodec(dict)
+	if err != nil {
+		return err
+	}
+	compress.Codecs[compress.Zstd] = codec
+	return nil
+}
+
+func (c *zstdDictCodec) Code() int8 { return int8(compress.Zstd) }
+
+func (c *zstdDictCodec) Name() string { return "zstd" }
+
+func (c *zstdDictCodec) NewReader(r io.Reader) io.ReadCloser {
+	decoder, err := zstd.NewReader(r, zstd.WithDecoderDicts(c.dict), zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return io.NopCloser(errorReader{err})
+	}
+	return decoder.IOReadCloser()
+}
+
+func (c *zstdDictCodec) NewWriter(w io.Writer) io.WriteCloser {
+	encoder, ok := c.encoders.Get().(*zstd.Encoder)
+	if !ok {
+		var err error
+		encoder, err = zstd.NewWriter(w, zstd.WithEncoderDict(c.dict), zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return errorWriter{err}
+		}
+	} else {
+		encoder.Reset(w)
+	}
+	return &zstdDictWriter{Encoder: encoder, codec: c}
+}
+
+// zstdDictWriter returns its encoder to the codec's pool once the frame is closed
+type zstdDictWriter struct {
+	*zstd.Encoder
+	codec *zstdDictCodec
+}
+
+func (w *zstdDictWriter) Close() error {
+	if w.Encoder == nil {
+		return nil
+	}
+	err := w.Encoder.Close()
+	w.codec.encoders.Put(w.Encoder)
+	w.Encoder = nil
+	return err
+}
+
+type errorReader struct{ err error }
+
+func (r errorReader) Read([]byte) (int, error) { return 0, r.err }
+
+type errorWriter struct{ err error }
+
+func (w errorWriter) Write([]byte) (int, error) { return 0, w.err }
+
+func (w errorWriter) Close() error { return w.err }
@@ -2,16 +2,25 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"math/rand"
 	"net/http"
+	"os"
+	"os/signal"
+	"runtime"
+	"sort"
 	"strings"
 	"sync"
-	"sync/atomic"
+	"syscall"
 	"time"
 )
 
@@ -36,6 +45,11 @@ type EventResponse struct {
 	SuccessEventIds []string `json:"successEventIds"`
 	InvalidEventIds []string `json:"invalidEventIds"`
 	FailedEventIds  []string `json:"failedEventIds"`
+
+	// Events the API took for a later write and answered with 202 Accepted
+	AcceptedEventIds []string `json:"acceptedEventIds"`
+	BufferedEventIds []string `json:"bufferedEventIds"`
+	SpooledEventIds  []string `json:"spooledEventIds"`
 }
 
 // Statistics for load test results
@@ -44,6 +58,7 @@ type LoadTestStats struct {
 	SuccessRequests int64
 	FailedRequests  int64
 	TimeoutRequests int64
+	WarmupRequests  int64 // completed during -warmup, excluded from all other stats
 	TotalEvents     int64
 	SuccessEvents   int64
 	FailedEvents    int64
@@ -53,24 +68,200 @@ type LoadTestStats struct {
 	MaxLatency      time.Duration
 	StartTime       time.Time
 	EndTime         time.Time
+
+	// Pauses taken by workers after consecutive failed requests
+	Backoffs    int64
+	BackoffTime time.Duration
+
+	// Retries of timed out or 5xx requests with -retries; a request counts once
+	// in the request stats, with the outcome of its last attempt
+	Retries          int64 // retry attempts
+	RetriedRequests  int64 // requests that needed at least one retry
+	RetriedSucceeded int64 // of those, requests whose last attempt succeeded
+
+	// Per-batch outcomes keyed by events per request, only tracked with -batch-stats
+	Batches map[int]*BatchStats
+
+	// Requests since the last -soak report, reset by every report
+	Window SoakWindow
+
+	// Achieved spacing between the requests of each worker, indexed by worker ID - 1
+	Spacing []WorkerSpacing
+
+	// Request outcomes keyed by -endpoint path
+	Endpoints map[string]*EndpointStats
+}
+
+// Requests sent to one -endpoint path; latencies are of successful requests
+type EndpointStats struct {
+	Path            string
+	Requests        int64
+	SuccessRequests int64
+	FailedRequests  int64
+	TimeoutRequests int64
+	SuccessEvents   int64
+	TotalLatency    time.Duration
+	MinLatency      time.Duration
+	MaxLatency      time.Duration
+}
+
+// Intervals between the starts of consecutive requests of one worker. They
+// include the request latency on top of -delay; intervals spanning a failure
+// backoff pause are left out.
+type WorkerSpacing struct {
+	Intervals  int64
+	Total      time.Duration
+	SumSquares float64 // of the intervals in seconds, for the standard deviation
+}
+
+// Mean and standard deviation of the intervals
+func (w WorkerSpacing) meanStddev() (time.Duration, time.Duration) {
+	if w.Intervals == 0 {
+		return 0, 0
+	}
+	n := float64(w.Intervals)
+	mean := w.Total.Seconds() / n
+	variance := math.Max(w.SumSquares/n-mean*mean, 0)
+	return time.Duration(mean * float64(time.Second)), time.Duration(math.Sqrt(variance) * float64(time.Second))
+}
+
+// Requests of one -soak reporting interval; latencies are kept only for this
+// window so percentiles reflect the interval and memory stays bounded
+type SoakWindow struct {
+	Requests  int64
+	Failed    int64 // failed and timed out requests
+	Events    int64
+	Latencies []time.Duration // successful requests only
+}
+
+// Outcomes of requests carrying the same number of events
+type BatchStats struct {
+	Size          int
+	Requests      int64
+	AllSucceeded  int64
+	Partial       int64
+	NoneSucceeded int64
+	Errored       int64         // request-level failures (HTTP errors, timeouts)
+	SuccessCounts map[int]int64 // successful events per request -> number of requests
 }
 
 var (
 	// Command line flags
-	duration     = flag.Int("duration", 30, "Test duration in seconds")
-	goroutines   = flag.Int("goroutines", 10, "Number of concurrent goroutines")
-	apiURL       = flag.String("url", "http://localhost:8080", "API base URL")
-	eventsPerReq = flag.Int("events", 1, "Number of events per request")
-	requestDelay = flag.Int("delay", 100, "Delay between requests in milliseconds")
-	verbose      = flag.Bool("verbose", false, "Verbose output")
+	duration        = flag.Int("duration", 30, "Test duration in seconds")
+	goroutines      = flag.Int("goroutines", 10, "Number of concurrent goroutines")
+	apiURL          = flag.String("url", "http://localhost:8080", "API base URL")
+	endpointList    = flag.String("endpoint", "/events", "Path to POST events to; a comma-separated list is round-robined by every worker")
+	eventsPerReq    = flag.Int("events", 1, "Number of events per request")
+	requestDelay    = flag.Int("delay", 100, "Delay between requests in milliseconds")
+	verbose         = flag.Bool("verbose", false, "Verbose output")
+	warmup          = flag.Int("warmup", 0, "Warmup period in seconds before measurement starts; warmup requests are excluded from stats")
+	connectRetries  = flag.Int("connect-retries", 0, "Number of times to retry the initial API health check before giving up")
+	connectInterval = flag.Int("connect-interval", 1, "Seconds to wait between health check retries")
+	batchStats      = flag.Bool("batch-stats", false, "Report per-batch success/failure and a histogram of successful events per request")
+	idPoolSize      = flag.Int("id-pool", 0, "Pick event IDs from a fixed pool of this many IDs instead of a unique ID per event, to produce duplicates (0 = always unique)")
+	failureBackoff  = flag.Int("failure-backoff", 100, "Initial pause in milliseconds after consecutive failed requests, doubled per further failure (0 disables)")
+	requestTimeout  = flag.Int("timeout", 1000, "Timeout of a single request attempt in milliseconds")
+	retries         = flag.Int("retries", 0, "Number of times a timed out or 5xx request is retried before it counts as failed")
+
+	// Soak test flags for long stability runs
+	soak           = flag.Bool("soak", false, "Run until interrupted (ignores -duration), printing a report with interval percentiles and memory usage every -report-interval")
+	reportInterval = flag.Int("report-interval", 60, "Seconds between -soak reports")
+
+	// HTTP transport flags
+	maxIdleConnsPerHost = flag.Int("max-idle-conns-per-host", 100, "Maximum idle (keep-alive) connections kept per host")
+	maxConnsPerHost     = flag.Int("max-conns-per-host", 0, "Maximum total connections per host (0 = unlimited)")
+	forceHTTP2          = flag.Bool("http2", true, "Negotiate HTTP/2 over TLS (https:// URLs) like Go's default client; -http2=false forces HTTP/1.1")
+
+	// Auth and TLS flags for secured deployments
+	token    = flag.String("token", "", "Bearer token sent in the Authorization header of every request")
+	apiKey   = flag.String("api-key", "", "API key sent in the X-API-Key header of every request")
+	insecure = flag.Bool("insecure", false, "Skip TLS certificate verification for https:// URLs")
+	caCert   = flag.String("cacert", "", "PEM file with CA certificates to trust for https:// URLs")
 
 	// Statistics
 	stats = &LoadTestStats{
 		MinLatency: time.Hour, // Start with a high value
+		Batches:    make(map[int]*BatchStats),
+		Endpoints:  make(map[string]*EndpointStats),
 	}
 	statsMutex sync.Mutex
+
+	// Shared transport so all workers draw from the same connection pool
+	transport *http.Transport
+
+	// Event IDs reused by generateRandomEvent when -id-pool is set
+	idPool []string
+
+	// Paths parsed from -endpoint, in the order workers take turns on them
+	endpoints []string
 )
 
+// Limits for worker goroutines and their backoff after failed requests
+const (
+	maxGoroutines         = 10000                  // more workers than this is almost certainly a typo
+	failuresBeforeBackoff = 3                      // consecutive failures before a worker starts pausing
+	maxFailureBackoff     = 5 * time.Second        // upper bound of a single pause
+	retryBackoff          = 50 * time.Millisecond  // pause before the first retry, doubled per further retry
+	statsMergeInterval    = 100 * time.Millisecond // how often a worker merges its own stats into the shared ones
+)
+
+// Whether the named flag was given on the command line
+func flagPassed(name string) bool {
+	passed := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			passed = true
+		}
+	})
+	return passed
+}
+
+// Build the shared HTTP transport from the command line flags
+func newTransport() (*http.Transport, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.MaxIdleConns = *maxIdleConnsPerHost
+	t.MaxIdleConnsPerHost = *maxIdleConnsPerHost
+	t.MaxConnsPerHost = *maxConnsPerHost
+	// True in the DefaultTransport it is cloned from, so https:// targets
+	// negotiate HTTP/2 unless -http2=false
+	t.ForceAttemptHTTP2 = *forceHTTP2
+
+	if *insecure || *caCert != "" {
+		tlsConfig := &tls.Config{InsecureSkipVerify: *insecure}
+		if *caCert != "" {
+			pem, err := os.ReadFile(*caCert)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read -cacert: %w", err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no PEM certificates found in %s", *caCert)
+			}
+			tlsConfig.RootCAs = pool
+		}
+		t.TLSClientConfig = tlsConfig
+	}
+	return t, nil
+}
+
+// Add the -token and -api-key auth headers to a request
+func setAuthHeaders(req *http.Request) {
+	if *token != "" {
+		req.Header.Set("Authorization", "Bearer "+*token)
+	}
+	if *apiKey != "" {
+		req.Header.Set("X-API-Key", *apiKey)
+	}
+}
+
+// Pick an ID from the -id-pool, or generate a unique one when there is no pool
+func eventID(now time.Time) string {
+	if len(idPool) > 0 {
+		return idPool[rand.Intn(len(idPool))]
+	}
+	return fmt.Sprintf("load-test-%d-%d", rand.Intn(100000), now.UnixNano())
+}
+
 // Generate a random event
 func generateRandomEvent() Event {
 	domains := []string{"Banking"}
@@ -82,7 +273,7 @@ func generateRandomEvent() Event {
 	return Event{
 		EventTimestamp: now.UnixNano(),
 		EventTime:      now.Format("2006-01-02T15:04:05.000Z07:00"),
-		ID:             fmt.Sprintf("load-test-%d-%d", rand.Intn(100000), now.UnixNano()),
+		ID:             eventID(now),
 		Domain:         domains[rand.Intn(len(domains))],
 		Subdomain:      subdomains[rand.Intn(len(subdomains))],
 		Code:           codes[rand.Intn(len(codes))],
@@ -95,16 +286,68 @@ func generateRandomEvent() Event {
 	}
 }
 
-// Send a single request to the API
-func sendRequest(client *http.Client, events []Event) (*EventResponse, time.Duration, error) {
+// Error for a response with an unexpected status code
+type statusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("HTTP %d: %s", e.StatusCode, e.Body)
+}
+
+// Whether err is a request that timed out
+func isTimeout(err error) bool {
+	return strings.Contains(err.Error(), "timeout") || strings.Contains(err.Error(), "context deadline exceeded")
+}
+
+// Whether a failed request may succeed when sent again: timeouts and 5xx responses
+func isRetryable(err error) bool {
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500
+	}
+	return isTimeout(err)
+}
+
+// Send a request, retrying timeouts and 5xx responses up to -retries times with a
+// doubling pause. The latency covers all attempts and pauses, as a retrying client
+// sees it. Returns the number of retries made.
+func sendWithRetries(ctx context.Context, client *http.Client, path string, events []Event) (*EventResponse, time.Duration, int, error) {
+	start := time.Now()
+	pause := retryBackoff
+	for attempt := 0; ; attempt++ {
+		response, _, err := sendRequest(ctx, client, path, events)
+		if err == nil || attempt == *retries || !isRetryable(err) || ctx.Err() != nil {
+			return response, time.Since(start), attempt, err
+		}
+		if *verbose {
+			log.Printf("Retrying request after %v (retry %d/%d): %v", pause, attempt+1, *retries, err)
+		}
+		if !sleepContext(ctx, pause) {
+			return nil, time.Since(start), attempt, err
+		}
+		pause *= 2
+	}
+}
+
+// Send a single request to the API path
+func sendRequest(ctx context.Context, client *http.Client, path string, events []Event) (*EventResponse, time.Duration, error) {
 	jsonData, err := json.Marshal(events)
 	if err != nil {
 		return nil, 0, fmt.Errorf("failed to marshal events: %w", err)
 	}
 
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, *apiURL+path, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	setAuthHeaders(req)
+
 	start := time.Now()
 
-	resp, err := client.Post(*apiURL+"/events", "application/json", bytes.NewBuffer(jsonData))
+	resp, err := client.Do(req)
 	if err != nil {
 		return nil, time.Since(start), fmt.Errorf("failed to send request: %w", err)
 	}
@@ -112,35 +355,233 @@ func sendRequest(client *http.Client, events []Event) (*EventResponse, time.Dura
 
 	latency := time.Since(start)
 
-	if resp.StatusCode != http.StatusOK {
+	// 207 Multi-Status answers a batch where some events were invalid or failed, and
+	// 202 Accepted one whose events were spooled, buffered or accumulated for a later write
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusMultiStatus && resp.StatusCode != http.StatusAccepted {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, latency, fmt.Errorf("HTTP %d: %s", resp.StatusCode, string(body))
+		return nil, latency, &statusError{StatusCode: resp.StatusCode, Body: string(body)}
 	}
 
 	var response EventResponse
 	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
 		return nil, latency, fmt.Errorf("failed to decode response: %w", err)
 	}
+	// The API owns events it accepted, so they count as succeeded
+	response.SuccessEventIds = append(response.SuccessEventIds, response.AcceptedEventIds...)
+	response.SuccessEventIds = append(response.SuccessEventIds, response.BufferedEventIds...)
+	response.SuccessEventIds = append(response.SuccessEventIds, response.SpooledEventIds...)
 
 	return &response, latency, nil
 }
 
-// Update statistics
-func updateStats(response *EventResponse, latency time.Duration, err error) {
+// Stats a worker records on its own, without locking, and merges into the shared
+// stats every statsMergeInterval and once more when it stops, so requests do not
+// contend for statsMutex. The printers only see merged stats, lagging the
+// workers by about statsMergeInterval.
+type workerStats struct {
+	id      int
+	stats   LoadTestStats
+	spacing WorkerSpacing
+	merged  time.Time // last merge into the shared stats
+}
+
+func newWorkerStats(id int) *workerStats {
+	w := &workerStats{id: id, merged: time.Now()}
+	w.reset()
+	return w
+}
+
+// Start over after a merge; StartTime is fixed before the workers start
+func (w *workerStats) reset() {
+	w.stats = LoadTestStats{
+		MinLatency: time.Hour,
+		Batches:    make(map[int]*BatchStats),
+		Endpoints:  make(map[string]*EndpointStats),
+		StartTime:  stats.StartTime,
+	}
+	w.spacing = WorkerSpacing{}
+}
+
+// Merge into the shared stats if statsMergeInterval has passed since the last merge
+func (w *workerStats) mergeDue() {
+	if time.Since(w.merged) >= statsMergeInterval {
+		w.merge()
+	}
+}
+
+// Add everything recorded since the last merge to the shared stats
+func (w *workerStats) merge() {
 	statsMutex.Lock()
-	defer statsMutex.Unlock()
+	stats.add(&w.stats)
+	spacing := &stats.Spacing[w.id-1]
+	spacing.Intervals += w.spacing.Intervals
+	spacing.Total += w.spacing.Total
+	spacing.SumSquares += w.spacing.SumSquares
+	statsMutex.Unlock()
+
+	w.reset()
+	w.merged = time.Now()
+}
+
+// Record the interval since the worker's previous request started
+func (w *workerStats) recordSpacing(interval time.Duration) {
+	w.spacing.Intervals++
+	w.spacing.Total += interval
+	w.spacing.SumSquares += interval.Seconds() * interval.Seconds()
+}
+
+// Add the counts of other to s; StartTime, EndTime and Spacing are left alone
+func (s *LoadTestStats) add(other *LoadTestStats) {
+	s.TotalRequests += other.TotalRequests
+	s.SuccessRequests += other.SuccessRequests
+	s.FailedRequests += other.FailedRequests
+	s.TimeoutRequests += other.TimeoutRequests
+	s.WarmupRequests += other.WarmupRequests
+	s.TotalEvents += other.TotalEvents
+	s.SuccessEvents += other.SuccessEvents
+	s.FailedEvents += other.FailedEvents
+	s.InvalidEvents += other.InvalidEvents
+	s.TotalLatency += other.TotalLatency
+	if other.MinLatency < s.MinLatency {
+		s.MinLatency = other.MinLatency
+	}
+	if other.MaxLatency > s.MaxLatency {
+		s.MaxLatency = other.MaxLatency
+	}
+
+	s.Backoffs += other.Backoffs
+	s.BackoffTime += other.BackoffTime
+	s.Retries += other.Retries
+	s.RetriedRequests += other.RetriedRequests
+	s.RetriedSucceeded += other.RetriedSucceeded
+
+	for size, from := range other.Batches {
+		batch, ok := s.Batches[size]
+		if !ok {
+			batch = &BatchStats{Size: size, SuccessCounts: make(map[int]int64)}
+			s.Batches[size] = batch
+		}
+		batch.Requests += from.Requests
+		batch.AllSucceeded += from.AllSucceeded
+		batch.Partial += from.Partial
+		batch.NoneSucceeded += from.NoneSucceeded
+		batch.Errored += from.Errored
+		for succeeded, count := range from.SuccessCounts {
+			batch.SuccessCounts[succeeded] += count
+		}
+	}
+
+	for path, from := range other.Endpoints {
+		endpoint := s.endpoint(path)
+		endpoint.Requests += from.Requests
+		endpoint.SuccessRequests += from.SuccessRequests
+		endpoint.FailedRequests += from.FailedRequests
+		endpoint.TimeoutRequests += from.TimeoutRequests
+		endpoint.SuccessEvents += from.SuccessEvents
+		endpoint.TotalLatency += from.TotalLatency
+		if from.MinLatency < endpoint.MinLatency {
+			endpoint.MinLatency = from.MinLatency
+		}
+		if from.MaxLatency > endpoint.MaxLatency {
+			endpoint.MaxLatency = from.MaxLatency
+		}
+	}
+
+	s.Window.Requests += other.Window.Requests
+	s.Window.Failed += other.Window.Failed
+	s.Window.Events += other.Window.Events
+	s.Window.Latencies = append(s.Window.Latencies, other.Window.Latencies...)
+}
+
+// Stats of the given -endpoint path, created on first use
+func (s *LoadTestStats) endpoint(path string) *EndpointStats {
+	endpoint, ok := s.Endpoints[path]
+	if !ok {
+		endpoint = &EndpointStats{Path: path, MinLatency: time.Hour}
+		s.Endpoints[path] = endpoint
+	}
+	return endpoint
+}
+
+// Record the outcome of one request in the stats of its endpoint
+func (s *LoadTestStats) updateEndpointStats(path string, response *EventResponse, latency time.Duration, err error) {
+	endpoint := s.endpoint(path)
+	endpoint.Requests++
+	switch {
+	case err != nil && isTimeout(err):
+		endpoint.TimeoutRequests++
+		return
+	case err != nil:
+		endpoint.FailedRequests++
+		return
+	}
+
+	endpoint.SuccessRequests++
+	if response != nil {
+		endpoint.SuccessEvents += int64(len(response.SuccessEventIds))
+	}
+	endpoint.TotalLatency += latency
+	if latency < endpoint.MinLatency {
+		endpoint.MinLatency = latency
+	}
+	if latency > endpoint.MaxLatency {
+		endpoint.MaxLatency = latency
+	}
+}
+
+// Record the outcome of one request in its batch size bucket
+func (s *LoadTestStats) updateBatchStats(batchSize int, response *EventResponse, err error) {
+	batch, ok := s.Batches[batchSize]
+	if !ok {
+		batch = &BatchStats{Size: batchSize, SuccessCounts: make(map[int]int64)}
+		s.Batches[batchSize] = batch
+	}
+	batch.Requests++
 
-	atomic.AddInt64(&stats.TotalRequests, 1)
+	if err != nil || response == nil {
+		batch.Errored++
+		return
+	}
+
+	succeeded := len(response.SuccessEventIds)
+	batch.SuccessCounts[succeeded]++
+	switch {
+	case succeeded == batchSize:
+		batch.AllSucceeded++
+	case succeeded == 0:
+		batch.NoneSucceeded++
+	default:
+		batch.Partial++
+	}
+}
+
+// Update statistics
+func (s *LoadTestStats) updateStats(path string, batchSize int, response *EventResponse, latency time.Duration, err error) {
+	// Requests completed before measurement starts only count as warmup
+	if time.Now().Before(s.StartTime) {
+		s.WarmupRequests++
+		return
+	}
+
+	s.TotalRequests++
+	if *soak {
+		s.updateWindow(response, latency, err)
+	}
+
+	if *batchStats {
+		s.updateBatchStats(batchSize, response, err)
+	}
+	s.updateEndpointStats(path, response, latency, err)
 
 	if err != nil {
 		// Check if it's a timeout error
-		if strings.Contains(err.Error(), "timeout") || strings.Contains(err.Error(), "context deadline exceeded") {
-			atomic.AddInt64(&stats.TimeoutRequests, 1)
+		if isTimeout(err) {
+			s.TimeoutRequests++
 			if *verbose {
 				log.Printf("Request timeout: %v", err)
 			}
 		} else {
-			atomic.AddInt64(&stats.FailedRequests, 1)
+			s.FailedRequests++
 			if *verbose {
 				log.Printf("Request failed: %v", err)
 			}
@@ -148,23 +589,23 @@ func updateStats(response *EventResponse, latency time.Duration, err error) {
 		return
 	}
 
-	atomic.AddInt64(&stats.SuccessRequests, 1)
+	s.SuccessRequests++
 
 	// Update event statistics
 	if response != nil {
-		atomic.AddInt64(&stats.TotalEvents, int64(len(response.SuccessEventIds)+len(response.FailedEventIds)+len(response.InvalidEventIds)))
-		atomic.AddInt64(&stats.SuccessEvents, int64(len(response.SuccessEventIds)))
-		atomic.AddInt64(&stats.FailedEvents, int64(len(response.FailedEventIds)))
-		atomic.AddInt64(&stats.InvalidEvents, int64(len(response.InvalidEventIds)))
+		s.TotalEvents += int64(len(response.SuccessEventIds) + len(response.FailedEventIds) + len(response.InvalidEventIds))
+		s.SuccessEvents += int64(len(response.SuccessEventIds))
+		s.FailedEvents += int64(len(response.FailedEventIds))
+		s.InvalidEvents += int64(len(response.InvalidEventIds))
 	}
 
 	// Update latency statistics
-	stats.TotalLatency += latency
-	if latency < stats.MinLatency {
-		stats.MinLatency = latency
+	s.TotalLatency += latency
+	if latency < s.MinLatency {
+		s.MinLatency = latency
 	}
-	if latency > stats.MaxLatency {
-		stats.MaxLatency = latency
+	if latency > s.MaxLatency {
+		s.MaxLatency = latency
 	}
 
 	if *verbose && response != nil {
@@ -173,48 +614,153 @@ func updateStats(response *EventResponse, latency time.Duration, err error) {
 	}
 }
 
+// Record a request in the current -soak window
+func (s *LoadTestStats) updateWindow(response *EventResponse, latency time.Duration, err error) {
+	s.Window.Requests++
+	if err != nil {
+		s.Window.Failed++
+		return
+	}
+	s.Window.Latencies = append(s.Window.Latencies, latency)
+	if response != nil {
+		s.Window.Events += int64(len(response.SuccessEventIds))
+	}
+}
+
+// Record the retries one request needed and whether it finally succeeded
+func (s *LoadTestStats) recordRetries(n int, err error) {
+	if time.Now().Before(s.StartTime) {
+		return
+	}
+	s.Retries += int64(n)
+	s.RetriedRequests++
+	if err == nil {
+		s.RetriedSucceeded++
+	}
+}
+
+// Record a pause taken after consecutive failures
+func (s *LoadTestStats) recordBackoff(pause time.Duration) {
+	if time.Now().Before(s.StartTime) {
+		return
+	}
+	s.Backoffs++
+	s.BackoffTime += pause
+}
+
+// Pause after the given number of consecutive failures, doubling from -failure-backoff
+// up to maxFailureBackoff. Returns the pause, 0 when no backoff applies yet.
+func failurePause(consecutiveFailures int) time.Duration {
+	if *failureBackoff <= 0 || consecutiveFailures < failuresBeforeBackoff {
+		return 0
+	}
+	pause := time.Duration(*failureBackoff) * time.Millisecond
+	for i := failuresBeforeBackoff; i < consecutiveFailures && pause < maxFailureBackoff; i++ {
+		pause *= 2
+	}
+	if pause > maxFailureBackoff {
+		pause = maxFailureBackoff
+	}
+	return pause
+}
+
+// Sleep for d, returning false if ctx is cancelled first
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
 // Worker goroutine
-func worker(workerID int, stopChan <-chan bool, wg *sync.WaitGroup) {
+func worker(ctx context.Context, workerID int, wg *sync.WaitGroup) {
 	defer wg.Done()
 
 	client := &http.Client{
-		Timeout: 1 * time.Second, // 1 second timeout for REST API calls
+		Transport: transport,
+		Timeout:   time.Duration(*requestTimeout) * time.Millisecond,
 	}
 
 	log.Printf("Worker %d started", workerID)
+	defer log.Printf("Worker %d stopped", workerID)
 
-	for {
-		select {
-		case <-stopChan:
-			log.Printf("Worker %d stopped", workerID)
-			return
-		default:
-			// Generate events for this request
-			events := make([]Event, *eventsPerReq)
-			for i := 0; i < *eventsPerReq; i++ {
-				events[i] = generateRandomEvent()
-			}
+	local := newWorkerStats(workerID)
+	defer local.merge()
 
-			// Send request
-			response, latency, err := sendRequest(client, events)
-			updateStats(response, latency, err)
+	// Workers start on different endpoints so each one gets an even share from the start
+	next := (workerID - 1) % len(endpoints)
+
+	consecutiveFailures := 0
+	var lastStart time.Time // zero after a backoff pause, so that interval is not recorded
+	for ctx.Err() == nil {
+		// Spacing is only measured once warmup is over, like the other stats
+		start := time.Now()
+		if !lastStart.IsZero() && !lastStart.Before(stats.StartTime) {
+			local.recordSpacing(start.Sub(lastStart))
+		}
+		lastStart = start
 
-			// Wait before next request
-			if *requestDelay > 0 {
-				time.Sleep(time.Duration(*requestDelay) * time.Millisecond)
+		// Generate events for this request
+		events := make([]Event, *eventsPerReq)
+		for i := 0; i < *eventsPerReq; i++ {
+			events[i] = generateRandomEvent()
+		}
+
+		// Take the endpoints in turns
+		path := endpoints[next]
+		next = (next + 1) % len(endpoints)
+
+		// Send request; one cut off by the end of the test is not counted
+		response, latency, retried, err := sendWithRetries(ctx, client, path, events)
+		if ctx.Err() != nil {
+			return
+		}
+		local.stats.updateStats(path, len(events), response, latency, err)
+		if retried > 0 {
+			local.stats.recordRetries(retried, err)
+		}
+		local.mergeDue()
+
+		// Back off while the API keeps failing, so a dead server is not hammered
+		// in a tight loop that inflates the request count
+		if err != nil {
+			consecutiveFailures++
+		} else {
+			consecutiveFailures = 0
+		}
+		if pause := failurePause(consecutiveFailures); pause > 0 {
+			if *verbose {
+				log.Printf("Worker %d backing off for %v after %d consecutive failures", workerID, pause, consecutiveFailures)
+			}
+			local.stats.recordBackoff(pause)
+			local.merge() // the pause may be long, do not hold back what was recorded
+			if !sleepContext(ctx, pause) {
+				return
 			}
+			lastStart = time.Time{}
+			continue
+		}
+
+		// Wait before next request
+		if *requestDelay > 0 && !sleepContext(ctx, time.Duration(*requestDelay)*time.Millisecond) {
+			return
 		}
 	}
 }
 
 // Print real-time statistics
-func printRealTimeStats(stopChan <-chan bool) {
+func printRealTimeStats(ctx context.Context) {
 	ticker := time.NewTicker(5 * time.Second)
 	defer ticker.Stop()
 
 	for {
 		select {
-		case <-stopChan:
+		case <-ctx.Done():
 			return
 		case <-ticker.C:
 			printCurrentStats()
@@ -228,6 +774,10 @@ func printCurrentStats() {
 	defer statsMutex.Unlock()
 
 	elapsed := time.Since(stats.StartTime)
+	if elapsed <= 0 {
+		fmt.Printf("\n=== Warming up (%d requests so far) ===\n", stats.WarmupRequests)
+		return
+	}
 	requestsPerSecond := float64(stats.TotalRequests) / elapsed.Seconds()
 	eventsPerSecond := float64(stats.TotalEvents) / elapsed.Seconds()
 
@@ -253,6 +803,79 @@ func printCurrentStats() {
 			float64(stats.TimeoutRequests)/float64(stats.TotalRequests)*100,
 			stats.TimeoutRequests, stats.TotalRequests)
 	}
+	if stats.Retries > 0 {
+		fmt.Printf("Retries: %d, %d requests retried, %d succeeded after retrying\n", stats.Retries, stats.RetriedRequests, stats.RetriedSucceeded)
+	}
+	if stats.Backoffs > 0 {
+		fmt.Printf("Failure backoff: %d pauses, %v total\n", stats.Backoffs, stats.BackoffTime.Round(time.Millisecond))
+	}
+}
+
+// Print a -soak report every reportInterval, starting a new percentile window each time
+func printSoakReports(ctx context.Context) {
+	interval := time.Duration(*reportInterval) * time.Second
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var baseline runtime.MemStats
+	runtime.ReadMemStats(&baseline)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			printSoakReport(interval, &baseline)
+		}
+	}
+}
+
+// Print the statistics of the window that just ended, the totals so far and the
+// load test's own memory usage compared to the start of the run
+func printSoakReport(interval time.Duration, baseline *runtime.MemStats) {
+	statsMutex.Lock()
+	window := stats.Window
+	stats.Window = SoakWindow{}
+	elapsed := time.Since(stats.StartTime)
+	totalRequests, totalFailed := stats.TotalRequests, stats.FailedRequests+stats.TimeoutRequests
+	statsMutex.Unlock()
+
+	if elapsed <= 0 {
+		fmt.Printf("\n=== Soak: warming up ===\n")
+		return
+	}
+
+	sort.Slice(window.Latencies, func(i, j int) bool { return window.Latencies[i] < window.Latencies[j] })
+
+	fmt.Printf("\n=== Soak Report (Elapsed: %v) ===\n", elapsed.Round(time.Second))
+	fmt.Printf("Interval: %d requests, %d failed, %.2f req/sec, %.2f events/sec\n",
+		window.Requests, window.Failed, float64(window.Requests)/interval.Seconds(), float64(window.Events)/interval.Seconds())
+	if len(window.Latencies) > 0 {
+		fmt.Printf("Interval latency: p50=%v, p95=%v, p99=%v, max=%v\n",
+			percentile(window.Latencies, 50).Round(time.Millisecond),
+			percentile(window.Latencies, 95).Round(time.Millisecond),
+			percentile(window.Latencies, 99).Round(time.Millisecond),
+			window.Latencies[len(window.Latencies)-1].Round(time.Millisecond))
+	}
+	fmt.Printf("Total: %d requests, %d failed\n", totalRequests, totalFailed)
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	fmt.Printf("Memory: heap %.1f MB (%+.1f MB since start), sys %.1f MB, %d GCs, %d goroutines\n",
+		float64(mem.HeapAlloc)/(1<<20), (float64(mem.HeapAlloc)-float64(baseline.HeapAlloc))/(1<<20),
+		float64(mem.Sys)/(1<<20), mem.NumGC, runtime.NumGoroutine())
+}
+
+// Nearest-rank percentile p (0-100) of sorted latencies
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(float64(len(sorted))*p/100+0.5) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
 }
 
 // Print final report
@@ -275,11 +898,30 @@ func printFinalReport() {
 	}
 
 	fmt.Printf("Test Configuration:\n")
-	fmt.Printf("  Duration: %d seconds\n", *duration)
+	if *soak {
+		fmt.Printf("  Duration: soak, ran until interrupted (reports every %d seconds)\n", *reportInterval)
+	} else {
+		fmt.Printf("  Duration: %d seconds\n", *duration)
+	}
+	if *warmup > 0 {
+		fmt.Printf("  Warmup: %d seconds (%d requests excluded)\n", *warmup, stats.WarmupRequests)
+	}
 	fmt.Printf("  Goroutines: %d\n", *goroutines)
 	fmt.Printf("  Events per request: %d\n", *eventsPerReq)
+	if *idPoolSize > 0 {
+		fmt.Printf("  ID pool: %d IDs (event IDs repeat)\n", *idPoolSize)
+	} else {
+		fmt.Printf("  ID pool: none (every event ID is unique)\n")
+	}
 	fmt.Printf("  Request delay: %d ms\n", *requestDelay)
+	fmt.Printf("  Failure backoff: %d ms\n", *failureBackoff)
+	fmt.Printf("  Request timeout: %d ms\n", *requestTimeout)
+	fmt.Printf("  Retries: %d\n", *retries)
 	fmt.Printf("  API URL: %s\n", *apiURL)
+	fmt.Printf("  Endpoints: %s\n", strings.Join(endpoints, ", "))
+	fmt.Printf("  Max idle conns per host: %d\n", *maxIdleConnsPerHost)
+	fmt.Printf("  Max conns per host: %d\n", *maxConnsPerHost)
+	fmt.Printf("  Force HTTP/2: %t\n", *forceHTTP2)
 	fmt.Printf("\n")
 
 	fmt.Printf("Request Statistics:\n")
@@ -292,6 +934,11 @@ func printFinalReport() {
 	if stats.TimeoutRequests > 0 {
 		fmt.Printf("  Timeout rate: %.2f%%\n", float64(stats.TimeoutRequests)/float64(stats.TotalRequests)*100)
 	}
+	if *retries > 0 {
+		fmt.Printf("  Retries: %d\n", stats.Retries)
+		fmt.Printf("  Retried requests: %d (%d succeeded after retrying)\n", stats.RetriedRequests, stats.RetriedSucceeded)
+	}
+	fmt.Printf("  Failure backoff pauses: %d (%v total across workers)\n", stats.Backoffs, stats.BackoffTime.Round(time.Millisecond))
 	fmt.Printf("\n")
 
 	fmt.Printf("Event Statistics:\n")
@@ -309,62 +956,311 @@ func printFinalReport() {
 	fmt.Printf("  Maximum latency: %v\n", stats.MaxLatency.Round(time.Millisecond))
 	fmt.Printf("\n")
 
+	printSpacingStats()
+
+	if len(endpoints) > 1 {
+		printEndpointStats()
+	}
+
+	if *batchStats {
+		printBatchStats()
+	}
+
 	fmt.Printf("%s\n", separator)
 }
 
+// Print the achieved spacing between requests against -delay, pooled over all
+// workers and, with -verbose, per worker (statsMutex must be held)
+func printSpacingStats() {
+	var pooled WorkerSpacing
+	var minRPS, maxRPS float64
+	measured := 0
+	for _, spacing := range stats.Spacing {
+		if spacing.Intervals == 0 {
+			continue
+		}
+		pooled.Intervals += spacing.Intervals
+		pooled.Total += spacing.Total
+		pooled.SumSquares += spacing.SumSquares
+
+		mean, _ := spacing.meanStddev()
+		rps := 1 / mean.Seconds()
+		if measured == 0 || rps < minRPS {
+			minRPS = rps
+		}
+		if measured == 0 || rps > maxRPS {
+			maxRPS = rps
+		}
+		measured++
+	}
+
+	fmt.Printf("Request Spacing:\n")
+	if *requestDelay > 0 {
+		fmt.Printf("  Configured delay: %d ms (at most %.2f requests per second per worker)\n", *requestDelay, 1000/float64(*requestDelay))
+	} else {
+		fmt.Printf("  Configured delay: none\n")
+	}
+	if measured == 0 {
+		fmt.Printf("  No intervals measured\n\n")
+		return
+	}
+	mean, stddev := pooled.meanStddev()
+	configured := time.Duration(*requestDelay) * time.Millisecond
+	fmt.Printf("  Achieved interval: mean %v, stddev %v (%d intervals)\n",
+		mean.Round(time.Microsecond), stddev.Round(time.Microsecond), pooled.Intervals)
+	fmt.Printf("  Drift from configured delay: %v\n", (mean - configured).Round(time.Microsecond))
+	fmt.Printf("  Effective requests per second per worker: %.2f (min %.2f, max %.2f across %d workers)\n",
+		1/mean.Seconds(), minRPS, maxRPS, measured)
+	if *verbose {
+		for i, spacing := range stats.Spacing {
+			if spacing.Intervals == 0 {
+				continue
+			}
+			mean, stddev := spacing.meanStddev()
+			fmt.Printf("    Worker %d: mean %v, stddev %v, %.2f requests per second\n",
+				i+1, mean.Round(time.Microsecond), stddev.Round(time.Microsecond), 1/mean.Seconds())
+		}
+	}
+	fmt.Printf("\n")
+}
+
+// Print the request outcomes and latencies of each -endpoint (statsMutex must be held)
+func printEndpointStats() {
+	fmt.Printf("Endpoint Statistics:\n")
+	for _, path := range endpoints {
+		endpoint := stats.endpoint(path)
+		if endpoint.Requests == 0 {
+			fmt.Printf("  %s: no requests\n", path)
+			continue
+		}
+		fmt.Printf("  %s: %d requests, %d success, %d failed, %d timeout (%.2f%% success), %d successful events\n",
+			path, endpoint.Requests, endpoint.SuccessRequests, endpoint.FailedRequests, endpoint.TimeoutRequests,
+			float64(endpoint.SuccessRequests)/float64(endpoint.Requests)*100, endpoint.SuccessEvents)
+		if endpoint.SuccessRequests > 0 {
+			fmt.Printf("    Latency: avg=%v, min=%v, max=%v\n",
+				(endpoint.TotalLatency / time.Duration(endpoint.SuccessRequests)).Round(time.Millisecond),
+				endpoint.MinLatency.Round(time.Millisecond),
+				endpoint.MaxLatency.Round(time.Millisecond))
+		}
+	}
+	fmt.Printf("\n")
+}
+
+// Print per-batch outcomes and a histogram of successful events per request (statsMutex must be held)
+func printBatchStats() {
+	sizes := make([]int, 0, len(stats.Batches))
+	for size := range stats.Batches {
+		sizes = append(sizes, size)
+	}
+	sort.Ints(sizes)
+
+	fmt.Printf("Batch Statistics:\n")
+	for _, size := range sizes {
+		batch := stats.Batches[size]
+		fmt.Printf("  Batch size %d: %d requests, %d all succeeded, %d partial, %d none succeeded, %d errored (%.2f%% fully successful)\n",
+			size, batch.Requests, batch.AllSucceeded, batch.Partial, batch.NoneSucceeded, batch.Errored,
+			float64(batch.AllSucceeded)/float64(batch.Requests)*100)
+
+		answered := batch.Requests - batch.Errored
+		if answered == 0 {
+			continue
+		}
+		fmt.Printf("  Successful events per request:\n")
+		for succeeded := 0; succeeded <= size; succeeded++ {
+			count := batch.SuccessCounts[succeeded]
+			if count == 0 {
+				continue
+			}
+			bar := strings.Repeat("#", int(float64(count)/float64(answered)*40+0.5))
+			fmt.Printf("    %5d/%-5d %8d %6.2f%% %s\n", succeeded, size, count, float64(count)/float64(answered)*100, bar)
+		}
+	}
+	fmt.Printf("\n")
+}
+
+// Check the API health endpoint, retrying up to -connect-retries times
+func checkConnectivity() error {
+	client := &http.Client{Transport: transport, Timeout: 1 * time.Second} // 1 second timeout for health check
+	attempts := *connectRetries + 1
+
+	var lastErr error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(time.Duration(*connectInterval) * time.Second)
+		}
+
+		req, err := http.NewRequest(http.MethodGet, *apiURL+"/protected/health", nil)
+		if err != nil {
+			return fmt.Errorf("Invalid API URL: %w", err)
+		}
+		setAuthHeaders(req)
+
+		resp, err := client.Do(req)
+		if err != nil {
+			lastErr = fmt.Errorf("Failed to connect to API: %w", err)
+		} else {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return nil
+			}
+			// Retrying will not fix missing or wrong credentials
+			if resp.StatusCode == http.StatusUnauthorized {
+				return fmt.Errorf("API health check returned 401 Unauthorized, check -token or -api-key")
+			}
+			lastErr = fmt.Errorf("API health check failed with status: %d", resp.StatusCode)
+		}
+
+		log.Printf("Connectivity check attempt %d/%d failed: %v", attempt, attempts, lastErr)
+	}
+
+	return lastErr
+}
+
 func main() {
 	flag.Parse()
 
+	if *goroutines < 1 || *goroutines > maxGoroutines {
+		log.Fatalf("-goroutines must be between 1 and %d, got %d", maxGoroutines, *goroutines)
+	}
+	if *soak && *reportInterval < 1 {
+		log.Fatalf("-report-interval must be at least 1 second, got %d", *reportInterval)
+	}
+	if *requestTimeout <= 0 {
+		log.Fatalf("-timeout must be positive, got %d", *requestTimeout)
+	}
+	if *retries < 0 {
+		log.Fatalf("-retries must not be negative, got %d", *retries)
+	}
+	if *idPoolSize < 0 {
+		log.Fatalf("-id-pool must not be negative, got %d", *idPoolSize)
+	}
+	for _, path := range strings.Split(*endpointList, ",") {
+		path = strings.TrimSpace(path)
+		if !strings.HasPrefix(path, "/") {
+			log.Fatalf("-endpoint paths must start with /, got %q", path)
+		}
+		endpoints = append(endpoints, path)
+	}
+
+	// IDs are fixed for the whole run, so the same ones repeat across requests
+	idPool = make([]string, *idPoolSize)
+	for i := range idPool {
+		idPool[i] = fmt.Sprintf("load-test-pool-%d", i+1)
+	}
+
 	// Modern Go random number generation (no need for seed)
 	// rand.Seed is deprecated since Go 1.20
 
-	fmt.Printf("Starting load test with %d goroutines for %d seconds...\n", *goroutines, *duration)
+	if *soak {
+		fmt.Printf("Starting soak test with %d goroutines until interrupted, reporting every %d seconds...\n", *goroutines, *reportInterval)
+	} else {
+		fmt.Printf("Starting load test with %d goroutines for %d seconds...\n", *goroutines, *duration)
+	}
 	fmt.Printf("Target API: %s\n", *apiURL)
+	fmt.Printf("Endpoints: %s\n", strings.Join(endpoints, ", "))
 	fmt.Printf("Events per request: %d\n", *eventsPerReq)
 	fmt.Printf("Request delay: %d ms\n", *requestDelay)
+	fmt.Printf("Failure backoff: %d ms\n", *failureBackoff)
+	fmt.Printf("Request timeout: %d ms, retries: %d\n", *requestTimeout, *retries)
 	fmt.Printf("Verbose mode: %t\n", *verbose)
+	fmt.Printf("Batch stats: %t\n", *batchStats)
+	if *idPoolSize > 0 {
+		fmt.Printf("ID pool: %d IDs\n", *idPoolSize)
+	}
+	fmt.Printf("Warmup: %d seconds\n", *warmup)
+	fmt.Printf("Max idle conns per host: %d\n", *maxIdleConnsPerHost)
+	fmt.Printf("Max conns per host: %d\n", *maxConnsPerHost)
+	fmt.Printf("Force HTTP/2: %t\n", *forceHTTP2)
+	fmt.Printf("Auth: token %t, API key %t\n", *token != "", *apiKey != "")
+	if *insecure {
+		fmt.Printf("TLS certificate verification: disabled\n")
+	}
 
-	// Test API connectivity first
-	fmt.Printf("\nTesting API connectivity...\n")
-	client := &http.Client{Timeout: 1 * time.Second} // 1 second timeout for health check
-	resp, err := client.Get(*apiURL + "/protected/health")
+	var err error
+	transport, err = newTransport()
 	if err != nil {
-		log.Fatalf("Failed to connect to API: %v", err)
+		log.Fatalf("%v", err)
+	}
+	if flagPassed("http2") && *forceHTTP2 && strings.HasPrefix(*apiURL, "http://") {
+		log.Printf("Warning: -http2 has no effect on plain http:// URLs, requests will use HTTP/1.1")
 	}
-	resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		log.Fatalf("API health check failed with status: %d", resp.StatusCode)
+	// Test API connectivity first, retrying while the API may still be starting up
+	fmt.Printf("\nTesting API connectivity...\n")
+	if err := checkConnectivity(); err != nil {
+		log.Fatalf("%v", err)
 	}
 	fmt.Printf("✓ API connectivity test passed\n")
 
-	// Initialize statistics
-	stats.StartTime = time.Now()
+	// Initialize statistics; measurement starts once the warmup period is over
+	warmupDuration := time.Duration(*warmup) * time.Second
+	stats.StartTime = time.Now().Add(warmupDuration)
+	stats.Spacing = make([]WorkerSpacing, *goroutines)
+	if warmupDuration > 0 {
+		fmt.Printf("Warming up for %d seconds, requests in this period are excluded from stats\n", *warmup)
+		time.AfterFunc(warmupDuration, func() {
+			statsMutex.Lock()
+			warmupRequests := stats.WarmupRequests
+			statsMutex.Unlock()
+			log.Printf("Warmup finished after %d requests, measurement started", warmupRequests)
+		})
+	}
 
-	// Create stop channel and wait group
-	stopChan := make(chan bool)
+	// Cancelling ctx stops the workers and the statistics printer
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 	var wg sync.WaitGroup
 
-	// Start real-time statistics printer
-	go printRealTimeStats(stopChan)
+	// Start real-time statistics printer; soak runs print interval reports instead
+	if *soak {
+		go printSoakReports(ctx)
+	} else {
+		go printRealTimeStats(ctx)
+	}
 
 	// Start worker goroutines
 	fmt.Printf("\nStarting %d worker goroutines...\n", *goroutines)
 	for i := 0; i < *goroutines; i++ {
 		wg.Add(1)
-		go worker(i+1, stopChan, &wg)
+		go worker(ctx, i+1, &wg)
+	}
+
+	// Catch Ctrl-C so an interrupted run still produces a final report
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+
+	// Wait for specified duration or an interrupt, whichever comes first. A soak
+	// run has no deadline and only stops on an interrupt.
+	var deadline <-chan time.Time
+	if *soak {
+		fmt.Printf("Soak test running until interrupted (press Ctrl-C to stop)...\n")
+	} else {
+		fmt.Printf("Load test running for %d seconds (press Ctrl-C to stop early)...\n", *duration)
+		timer := time.NewTimer(warmupDuration + time.Duration(*duration)*time.Second)
+		defer timer.Stop()
+		deadline = timer.C
 	}
 
-	// Wait for specified duration
-	fmt.Printf("Load test running for %d seconds...\n", *duration)
-	time.Sleep(time.Duration(*duration) * time.Second)
+	select {
+	case <-deadline:
+	case sig := <-sigChan:
+		fmt.Printf("\nReceived %v, stopping load test early...\n", sig)
+	}
+	// A second Ctrl-C while workers drain falls back to the default behaviour
+	signal.Stop(sigChan)
 
 	// Stop all workers
 	fmt.Printf("\nStopping load test...\n")
-	close(stopChan)
+	cancel()
 	wg.Wait()
 
+	statsMutex.Lock()
 	stats.EndTime = time.Now()
+	if stats.EndTime.Before(stats.StartTime) {
+		// Interrupted during warmup: nothing was measured
+		stats.StartTime = stats.EndTime
+	}
+	statsMutex.Unlock()
 
 	// Print final report
 	printFinalReport()